@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var atmConfig = struct {
+	OwnNetworkID    string          // собственная сеть банкоматов банка (on-us)
+	PartnerNetworks map[string]bool // сети банков-партнеров, снятие в которых дешевле
+	Denomination    decimal.Decimal // сумма должна быть кратна минимальной купюре
+	MaxAmount       decimal.Decimal
+	ReportWindow    time.Duration // в течение этого времени банкомат может сообщить о сбое выдачи, позже — только через претензию
+}{
+	OwnNetworkID:    envOrDefault("BANKAPP_ATM_NETWORK_ID", "BANKAPP"),
+	PartnerNetworks: map[string]bool{"ALLIANCE": true, "UNITED": true},
+	Denomination:    decimal.NewFromInt(100),
+	MaxAmount:       decimal.NewFromInt(200000),
+	ReportWindow:    15 * time.Minute,
+}
+
+// atmNetworkTypes — типы сетей банкоматов, от которых зависит комиссия за снятие (см. тариф withdrawal).
+var atmNetworkTypes = map[string]bool{
+	"on_us":   true,
+	"partner": true,
+	"off_us":  true,
+}
+
+// depositChannels — каналы внесения денег на счет.
+var depositChannels = map[string]bool{
+	"branch":      true,
+	"atm":         true,
+	"transfer_in": true,
+}
+
+// ClassifyATMNetwork определяет, чей банкомат: свой (on_us), партнера (partner) или чужой (off_us).
+func ClassifyATMNetwork(networkID string) string {
+	switch {
+	case networkID == atmConfig.OwnNetworkID:
+		return "on_us"
+	case atmConfig.PartnerNetworks[networkID]:
+		return "partner"
+	default:
+		return "off_us"
+	}
+}
+
+// NewATM регистрирует банкомат и возвращает его ключ отдельно: в банкомате хранится только хэш.
+func NewATM(req RegisterATMRequest, now time.Time) (ATM, string, error) {
+	req.ID = strings.TrimSpace(req.ID)
+	if req.ID == "" {
+		return ATM{}, "", errATMIDRequired
+	}
+	if req.NetworkID == "" {
+		req.NetworkID = atmConfig.OwnNetworkID
+	}
+	key, hash := generateAPIKey()
+	return ATM{
+		ID:        req.ID,
+		NetworkID: req.NetworkID,
+		Location:  strings.TrimSpace(req.Location),
+		KeyHash:   hash,
+		CreatedAt: now,
+	}, key, nil
+}
+
+// authenticateCardPresent проверяет карту, вставленную в банкомат, и ее PIN.
+func authenticateCardPresent(cardNumber, pin string) (Card, error) {
+	if pin == "" {
+		return Card{}, fmt.Errorf("%w: PIN is required", errCardDataRequired)
+	}
+	if err := ValidateCardNumber(cardNumber); err != nil {
+		return Card{}, fmt.Errorf("%w: %v", errInvalidCardNumber, err)
+	}
+	card, ok := GetCardByNumber(cardNumber)
+	if !ok {
+		return Card{}, errCardNotFound
+	}
+	if err := CheckCardUsable(card, time.Now()); err != nil {
+		return Card{}, err
+	}
+	if card.Type != "physical" {
+		return Card{}, errCardNotPhysical
+	}
+	if err := VerifyCardPIN(card, pin); err != nil {
+		return Card{}, err
+	}
+	return card, nil
+}
+
+// WithdrawCash списывает наличные и комиссию по тарифу со счета карты. Суточные лимиты на наличные
+// проверяются при проводке как лимиты категории cash.
+func WithdrawCash(req ATMWithdrawalRequest, now time.Time) (ATMWithdrawal, error) {
+	if !req.Amount.IsPositive() {
+		return ATMWithdrawal{}, errNonPositiveAmount
+	}
+	if !req.Amount.Mod(atmConfig.Denomination).IsZero() || req.Amount.GreaterThan(atmConfig.MaxAmount) {
+		return ATMWithdrawal{}, fmt.Errorf("%w: must be a multiple of %s and not exceed %s",
+			errInvalidCashAmount, atmConfig.Denomination.String(), atmConfig.MaxAmount.String())
+	}
+	req.ATMID = strings.TrimSpace(req.ATMID)
+	if req.ATMID == "" {
+		return ATMWithdrawal{}, errATMIDRequired
+	}
+	// Сеть берется из реестра банкоматов, а не из запроса: от нее зависит комиссия.
+	atm, ok := GetATM(req.ATMID)
+	if !ok {
+		return ATMWithdrawal{}, errATMNotFound
+	}
+
+	card, err := authenticateCardPresent(req.CardNumber, req.PIN)
+	if err != nil {
+		return ATMWithdrawal{}, err
+	}
+
+	networkType := ClassifyATMNetwork(atm.NetworkID)
+	withdrawal := ATMWithdrawal{
+		ID:              GenerateID(),
+		CardID:          card.ID,
+		AccountID:       card.AccountID,
+		ATMID:           req.ATMID,
+		NetworkID:       atm.NetworkID,
+		NetworkType:     networkType,
+		Amount:          req.Amount,
+		DispensedAmount: req.Amount,
+		Status:          "completed",
+		CreatedAt:       now,
+	}
+	tx := Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   card.AccountID,
+		Amount:          req.Amount,
+		Timestamp:       now,
+		TransactionType: "withdrawal",
+		Description:     fmt.Sprintf("Cash withdrawal at ATM %s (%s)", atm.ID, atm.NetworkID),
+		CardID:          card.ID,
+		Channel:         "atm",
+		TerminalID:      req.ATMID,
+	}
+	txs, quote, err := ChargeFee(tx, "withdrawal", networkType)
+	if err != nil {
+		return ATMWithdrawal{}, err
+	}
+	withdrawal.TransactionID = tx.ID
+	withdrawal.Fee = quote.Fee
+	if len(txs) > 1 {
+		withdrawal.FeeTransactionID = txs[1].ID
+	}
+
+	if err := AddATMWithdrawal(withdrawal, txs...); err != nil {
+		return ATMWithdrawal{}, err
+	}
+	return withdrawal, nil
+}
+
+// atmReversalTransactions возвращает невыданную сумму, а если наличные не выданы совсем — и комиссию.
+func atmReversalTransactions(withdrawal ATMWithdrawal, dispensed decimal.Decimal, now time.Time) []Transaction {
+	txs := []Transaction{{
+		ID:              GenerateID(),
+		ToAccountID:     withdrawal.AccountID,
+		Amount:          withdrawal.Amount.Sub(dispensed),
+		Timestamp:       now,
+		TransactionType: "withdrawal_reversal",
+		Description:     fmt.Sprintf("Reversal of cash not dispensed by ATM %s", withdrawal.ATMID),
+		CardID:          withdrawal.CardID,
+		RelatedTxID:     withdrawal.TransactionID,
+		Channel:         "atm",
+		TerminalID:      withdrawal.ATMID,
+	}}
+	if dispensed.IsZero() && withdrawal.FeeTransactionID != "" {
+		txs = append(txs, Transaction{
+			ID:              GenerateID(),
+			ToAccountID:     withdrawal.AccountID,
+			Amount:          withdrawal.Fee,
+			Timestamp:       now,
+			TransactionType: "atm_fee_reversal",
+			Description:     fmt.Sprintf("Fee refund for failed cash withdrawal at ATM %s", withdrawal.ATMID),
+			CardID:          withdrawal.CardID,
+			RelatedTxID:     withdrawal.FeeTransactionID,
+			Channel:         "atm",
+			TerminalID:      withdrawal.ATMID,
+		})
+	}
+	return txs
+}
@@ -1,6 +1,15 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -13,3 +22,81 @@ func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil //
 }
+
+// checkCardOwner проверяет, что userID — владелец счета карты и пароль принадлежит ему.
+func checkCardOwner(card Card, userID, password string) bool {
+	account, ok := GetAccount(card.AccountID)
+	if !ok || userID == "" || account.UserID != userID {
+		return false
+	}
+	user, ok := GetUser(userID)
+	return ok && CheckPasswordHash(password, user.PasswordHash)
+}
+
+var operatorToken = os.Getenv("BANKAPP_OPERATOR_TOKEN")
+
+// requireOperator пропускает только запросы сотрудников банка с заголовком X-Operator-Token.
+func requireOperator(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if operatorToken == "" {
+			log.Printf("BANKAPP_OPERATOR_TOKEN is not set, rejecting operator request %s %s", r.Method, r.RequestURI)
+			respondError(w, http.StatusForbidden, "Operator access is not configured")
+			return
+		}
+		if !isOperatorRequest(r) {
+			respondError(w, http.StatusForbidden, "Operator access required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func isOperatorRequest(r *http.Request) bool {
+	token := r.Header.Get("X-Operator-Token")
+	return operatorToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(operatorToken)) == 1
+}
+
+// requireMerchant пропускает запросы мерчанта {merchantId} с его ключом в X-Merchant-Key и запросы сотрудников банка.
+func requireMerchant(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isOperatorRequest(r) {
+			next(w, r)
+			return
+		}
+		merchant, ok := GetMerchant(mux.Vars(r)["merchantId"])
+		if !ok || !checkAPIKey(r.Header.Get("X-Merchant-Key"), merchant.APIKeyHash) {
+			respondError(w, http.StatusForbidden, "Merchant authentication required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// generateAPIKey создает ключ доступа для банкомата или мерчанта. Хранится только его хэш.
+func generateAPIKey() (key, hash string) {
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	key = hex.EncodeToString(raw)
+	return key, hashAPIKey(key)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func checkAPIKey(key, hash string) bool {
+	return key != "" && hash != "" && subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(hash)) == 1
+}
+
+// requireATM пропускает только запросы зарегистрированного банкомата с заголовками X-ATM-ID и X-ATM-Key.
+func requireATM(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atm, ok := GetATM(r.Header.Get("X-ATM-ID"))
+		if !ok || !checkAPIKey(r.Header.Get("X-ATM-Key"), atm.KeyHash) {
+			respondError(w, http.StatusForbidden, "ATM authentication required")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const maxBatchLines = 10000
+
+// ParseBatchCSV читает строки пакета из CSV с заголовком to,amount[,description][,to_type].
+func ParseBatchCSV(r io.Reader) ([]BatchPaymentLine, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["to"]; !ok {
+		return nil, fmt.Errorf("CSV header must contain 'to' column")
+	}
+	if _, ok := columns["amount"]; !ok {
+		return nil, fmt.Errorf("CSV header must contain 'amount' column")
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var lines []BatchPaymentLine
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		line := BatchPaymentLine{
+			To:          field(record, "to"),
+			ToType:      field(record, "to_type"),
+			Description: field(record, "description"),
+		}
+		// Некорректная сумма не прерывает разбор: строка будет отклонена при проверке.
+		if amount, err := decimal.NewFromString(field(record, "amount")); err == nil {
+			line.Amount = amount
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// ValidateBatch проверяет каждую строку пакета до исполнения и заполняет статусы строк.
+func ValidateBatch(batch *PaymentBatch, lines []BatchPaymentLine) {
+	batch.Lines = make([]BatchLine, 0, len(lines))
+	batch.TotalAmount = decimal.Zero
+	for i, l := range lines {
+		line := BatchLine{
+			Line:        i + 1,
+			To:          l.To,
+			ToType:      l.ToType,
+			Amount:      l.Amount,
+			Description: l.Description,
+			Status:      "pending",
+		}
+		if l.Amount.LessThanOrEqual(decimal.Zero) {
+			line.Status = "invalid"
+			line.Error = "amount must be a positive number"
+		} else if acc, _, err := ResolveRecipient(l.To, l.ToType); err != nil {
+			line.Status = "invalid"
+			line.Error = err.Error()
+		} else if acc.ID == batch.FromAccountID {
+			line.Status = "invalid"
+			line.Error = errSameAccount.Error()
+		} else if acc.Status != "active" {
+			line.Status = "invalid"
+			line.Error = fmt.Sprintf("recipient account is %s", acc.Status)
+		} else {
+			line.ToAccountID = acc.ID
+		}
+
+		if line.Status == "invalid" {
+			batch.InvalidLines++
+		} else {
+			batch.ValidLines++
+			batch.TotalAmount = batch.TotalAmount.Add(line.Amount)
+		}
+		batch.Lines = append(batch.Lines, line)
+	}
+}
+
+// batchLineTransactions возвращает перевод по строке пакета и комиссию за него; перевод идет первым.
+// pending — уже подготовленные проводки пакета, если он проводится одной операцией.
+func batchLineTransactions(batch PaymentBatch, from Account, line BatchLine, pending []Transaction) ([]Transaction, error) {
+	to, ok := GetAccount(line.ToAccountID)
+	if !ok {
+		return nil, fmt.Errorf("account %s not found", line.ToAccountID)
+	}
+	description := line.Description
+	if description == "" {
+		description = fmt.Sprintf("Batch %s, line %d", batch.ID, line.Line)
+	}
+	txs, _, err := ChargeFee(NewTransferTransaction(from, to, line.Amount, description), "transfer", "", pending...)
+	return txs, err
+}
+
+// ProcessPaymentBatch исполняет валидные строки пакета. В режиме all_or_nothing
+// все переводы проводятся одной атомарной операцией, в best_effort — по одному.
+func ProcessPaymentBatch(batch PaymentBatch) {
+	from, ok := GetAccount(batch.FromAccountID)
+	if !ok {
+		batch.Status = "failed"
+		finishPaymentBatch(batch)
+		return
+	}
+
+	if batch.Mode == "all_or_nothing" {
+		var txs []Transaction
+		lineTxIDs := make([]string, len(batch.Lines))
+		var prepareErr error
+		for i, line := range batch.Lines {
+			lineTxs, err := batchLineTransactions(batch, from, line, txs)
+			if err != nil {
+				prepareErr = fmt.Errorf("line %d: %w", line.Line, err)
+				break
+			}
+			lineTxIDs[i] = lineTxs[0].ID
+			txs = append(txs, lineTxs...)
+		}
+		if prepareErr == nil {
+			prepareErr = PostTransactionsAtomic(txs)
+		}
+		for i := range batch.Lines {
+			if prepareErr != nil {
+				batch.Lines[i].Status = "failed"
+				batch.Lines[i].Error = prepareErr.Error()
+				batch.FailedLines++
+			} else {
+				batch.Lines[i].Status = "success"
+				batch.Lines[i].TransactionID = lineTxIDs[i]
+				batch.SucceededLines++
+			}
+		}
+	} else {
+		for i, line := range batch.Lines {
+			if line.Status != "pending" {
+				continue
+			}
+			txs, err := batchLineTransactions(batch, from, line, nil)
+			if err == nil {
+				err = PostTransactionsAtomic(txs)
+			}
+			if err != nil {
+				batch.Lines[i].Status = "failed"
+				batch.Lines[i].Error = err.Error()
+				batch.FailedLines++
+				continue
+			}
+			batch.Lines[i].Status = "success"
+			batch.Lines[i].TransactionID = txs[0].ID
+			batch.SucceededLines++
+		}
+	}
+
+	switch {
+	case batch.FailedLines == 0 && batch.InvalidLines == 0:
+		batch.Status = "completed"
+	case batch.SucceededLines == 0:
+		batch.Status = "failed"
+	default:
+		batch.Status = "partially_completed"
+	}
+	finishPaymentBatch(batch)
+}
+
+func finishPaymentBatch(batch PaymentBatch) {
+	now := time.Now()
+	batch.CompletedAt = &now
+	UpdatePaymentBatch(batch)
+	log.Printf("Payment batch %s finished: %s (%d succeeded, %d failed, %d invalid)",
+		batch.ID, batch.Status, batch.SucceededLines, batch.FailedLines, batch.InvalidLines)
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// billProviders — каталог поставщиков услуг с полями, которые нужно заполнить при оплате.
+var billProviders = map[string]BillProvider{
+	"mosenergosbyt": {
+		ID:       "mosenergosbyt",
+		Name:     "Мосэнергосбыт",
+		Category: "utilities",
+		Fields: []BillProviderField{
+			{Name: "account", Label: "Лицевой счет", Pattern: `^\d{10}$`, Hint: "10 цифр", Required: true},
+			{Name: "meter", Label: "Показания счетчика", Pattern: `^\d{1,7}$`},
+		},
+		MinAmount: decimal.NewFromInt(1),
+		MaxAmount: decimal.NewFromInt(100000),
+	},
+	"mosvodokanal": {
+		ID:       "mosvodokanal",
+		Name:     "Мосводоканал",
+		Category: "utilities",
+		Fields: []BillProviderField{
+			{Name: "account", Label: "Лицевой счет", Pattern: `^\d{8}$`, Hint: "8 цифр", Required: true},
+			{Name: "period", Label: "Период оплаты", Pattern: `^(0[1-9]|1[0-2])\.20\d{2}$`, Hint: "ММ.ГГГГ", Required: true},
+		},
+		MinAmount: decimal.NewFromInt(1),
+		MaxAmount: decimal.NewFromInt(100000),
+	},
+	"mts": {
+		ID:       "mts",
+		Name:     "МТС",
+		Category: "mobile",
+		Fields: []BillProviderField{
+			{Name: "phone", Label: "Номер телефона", Pattern: `^9\d{9}$`, Hint: "10 цифр без +7", Required: true},
+		},
+		MinAmount: decimal.NewFromInt(10),
+		MaxAmount: decimal.NewFromInt(15000),
+	},
+	"beeline": {
+		ID:       "beeline",
+		Name:     "Билайн",
+		Category: "mobile",
+		Fields: []BillProviderField{
+			{Name: "phone", Label: "Номер телефона", Pattern: `^9\d{9}$`, Hint: "10 цифр без +7", Required: true},
+		},
+		MinAmount: decimal.NewFromInt(10),
+		MaxAmount: decimal.NewFromInt(15000),
+	},
+	"rostelecom": {
+		ID:       "rostelecom",
+		Name:     "Ростелеком",
+		Category: "internet",
+		Fields: []BillProviderField{
+			{Name: "contract", Label: "Номер договора", Pattern: `^\d{12}$`, Hint: "12 цифр", Required: true},
+		},
+		MinAmount: decimal.NewFromInt(1),
+		MaxAmount: decimal.NewFromInt(50000),
+	},
+	"domru": {
+		ID:       "domru",
+		Name:     "Дом.ру",
+		Category: "internet",
+		Fields: []BillProviderField{
+			{Name: "account", Label: "Лицевой счет", Pattern: `^\d{9,12}$`, Hint: "от 9 до 12 цифр", Required: true},
+		},
+		MinAmount: decimal.NewFromInt(1),
+		MaxAmount: decimal.NewFromInt(50000),
+	},
+}
+
+// billFieldPatterns — скомпилированные шаблоны полей каталога, ключ — Pattern.
+var billFieldPatterns = compileBillFieldPatterns()
+
+func compileBillFieldPatterns() map[string]*regexp.Regexp {
+	patterns := make(map[string]*regexp.Regexp)
+	for _, provider := range billProviders {
+		for _, field := range provider.Fields {
+			patterns[field.Pattern] = regexp.MustCompile(field.Pattern)
+		}
+	}
+	return patterns
+}
+
+func GetBillProvider(providerID string) (BillProvider, bool) {
+	provider, ok := billProviders[providerID]
+	return provider, ok
+}
+
+// GetBillProviders возвращает каталог, отфильтрованный по категории (пустая — все), в порядке названий.
+func GetBillProviders(category string) []BillProvider {
+	providers := []BillProvider{}
+	for _, provider := range billProviders {
+		if category == "" || provider.Category == category {
+			providers = append(providers, provider)
+		}
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	return providers
+}
+
+// ValidateBillFields проверяет реквизиты платежа по правилам поставщика и отбрасывает лишние поля.
+func ValidateBillFields(provider BillProvider, fields map[string]string) (map[string]string, error) {
+	clean := make(map[string]string)
+	for _, field := range provider.Fields {
+		value := strings.TrimSpace(fields[field.Name])
+		if value == "" {
+			if field.Required {
+				return nil, fmt.Errorf("field '%s' (%s) is required", field.Name, field.Label)
+			}
+			continue
+		}
+		if !billFieldPatterns[field.Pattern].MatchString(value) {
+			message := fmt.Sprintf("field '%s' (%s) has invalid format", field.Name, field.Label)
+			if field.Hint != "" {
+				message += ", expected " + field.Hint
+			}
+			return nil, fmt.Errorf("%s", message)
+		}
+		clean[field.Name] = value
+	}
+	return clean, nil
+}
+
+// ProviderPayment — платеж, передаваемый поставщику.
+type ProviderPayment struct {
+	PaymentID  string            `json:"payment_id"`
+	ProviderID string            `json:"provider_id"`
+	Fields     map[string]string `json:"fields"`
+	Amount     decimal.Decimal   `json:"amount"`
+}
+
+// BillConnector передает платеж поставщику. Итог приходит позже через ProcessBillPaymentStatus.
+type BillConnector interface {
+	SendPayment(payment ProviderPayment) (externalID string, err error)
+}
+
+// billConnectors — подключения к поставщикам; для поставщика без своего подключения используется defaultBillConnector.
+var (
+	billConnectors       = map[string]BillConnector{}
+	defaultBillConnector BillConnector
+)
+
+func InitBillConnectors() {
+	log.Println("Bill provider connectors are not configured, using mock connector")
+	defaultBillConnector = &MockBillConnector{Delay: 2 * time.Second}
+}
+
+func RegisterBillConnector(providerID string, connector BillConnector) {
+	billConnectors[providerID] = connector
+}
+
+func billConnectorFor(providerID string) BillConnector {
+	if connector, ok := billConnectors[providerID]; ok {
+		return connector
+	}
+	return defaultBillConnector
+}
+
+// MockBillConnector имитирует поставщика: принимает платеж и через Delay сообщает результат.
+// Реквизиты, начинающиеся с "000", поставщик не находит и отклоняет платеж.
+type MockBillConnector struct {
+	Delay time.Duration
+}
+
+func (m *MockBillConnector) SendPayment(payment ProviderPayment) (string, error) {
+	externalID := "MOCK-" + GenerateID()
+	status, reason := "success", ""
+	for _, value := range payment.Fields {
+		if strings.HasPrefix(value, "000") {
+			status, reason = "failed", "subscriber not found"
+		}
+	}
+	go func() {
+		time.Sleep(m.Delay)
+		if err := ProcessBillPaymentStatus(payment.PaymentID, status, reason); err != nil {
+			log.Printf("Mock bill payment status for %s failed: %v", payment.PaymentID, err)
+		}
+	}()
+	return externalID, nil
+}
+
+// ProcessBillPaymentStatus фиксирует ответ поставщика: success или failed с возвратом денег.
+func ProcessBillPaymentStatus(paymentID, status, reason string) error {
+	payment, ok := GetBillPayment(paymentID)
+	if !ok {
+		return fmt.Errorf("bill payment %s not found", paymentID)
+	}
+	now := time.Now()
+	switch status {
+	case "success":
+		if _, err := SettleBillPayment(payment.ID, "success", "", nil, now); err != nil {
+			return err
+		}
+		log.Printf("Bill payment %s to %s succeeded", payment.ID, payment.ProviderID)
+	case "failed":
+		refund := billPaymentRefund(payment, now)
+		settled, err := SettleBillPayment(payment.ID, "failed", reason, &refund, now)
+		if err != nil {
+			return err
+		}
+		log.Printf("Bill payment %s to %s failed (%s): %s", payment.ID, payment.ProviderID, settled.Status, reason)
+	default:
+		return fmt.Errorf("unknown bill payment status '%s'", status)
+	}
+	return nil
+}
+
+// RetryBillPaymentRefunds повторяет возвраты по неуспешным платежам, которые не удалось зачислить сразу,
+// например на замороженный счет.
+func RetryBillPaymentRefunds(now time.Time) {
+	for _, payment := range GetBillPaymentsByStatus("refund_pending") {
+		refund := billPaymentRefund(payment, now)
+		settled, err := SettleBillPayment(payment.ID, "failed", payment.Reason, &refund, now)
+		if err != nil {
+			log.Printf("Failed to retry refund for bill payment %s: %v", payment.ID, err)
+			continue
+		}
+		if settled.Status == "failed" {
+			log.Printf("Refund for bill payment %s credited to account %s", payment.ID, payment.AccountID)
+		}
+	}
+}
+
+func billPaymentRefund(payment BillPayment, now time.Time) Transaction {
+	return Transaction{
+		ID:              GenerateID(),
+		ToAccountID:     payment.AccountID,
+		Amount:          payment.Amount,
+		Timestamp:       now,
+		TransactionType: "bill_payment_refund",
+		Description:     fmt.Sprintf("Refund of failed payment to %s (ID: %s)", payment.ProviderName, payment.ID),
+		RelatedTxID:     payment.TransactionID,
+	}
+}
+
+// applyBillTemplate дополняет запрос данными шаблона: явно указанные в запросе значения имеют приоритет.
+func applyBillTemplate(req *CreateBillPaymentRequest, template BillTemplate) {
+	if req.ProviderID == "" {
+		req.ProviderID = template.ProviderID
+	}
+	if req.FromAccountID == "" {
+		req.FromAccountID = template.FromAccountID
+	}
+	if req.Amount.IsZero() && template.Amount != nil {
+		req.Amount = *template.Amount
+	}
+	fields := make(map[string]string, len(template.Fields))
+	for k, v := range template.Fields {
+		fields[k] = v
+	}
+	for k, v := range req.Fields {
+		fields[k] = v
+	}
+	req.Fields = fields
+}
+
+// NewBillTemplate проверяет реквизиты шаблона по правилам поставщика.
+func NewBillTemplate(req CreateBillTemplateRequest, now time.Time) (BillTemplate, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return BillTemplate{}, fmt.Errorf("template name is required")
+	}
+	provider, ok := GetBillProvider(req.ProviderID)
+	if !ok {
+		return BillTemplate{}, fmt.Errorf("bill provider %s not found", req.ProviderID)
+	}
+	fields, err := ValidateBillFields(provider, req.Fields)
+	if err != nil {
+		return BillTemplate{}, err
+	}
+	if req.Amount != nil && (req.Amount.LessThan(provider.MinAmount) || req.Amount.GreaterThan(provider.MaxAmount)) {
+		return BillTemplate{}, fmt.Errorf("amount must be between %s and %s", provider.MinAmount.String(), provider.MaxAmount.String())
+	}
+	if req.FromAccountID != "" {
+		account, ok := GetAccount(req.FromAccountID)
+		if !ok || account.UserID != req.UserID {
+			return BillTemplate{}, errAccountNotOwned
+		}
+	}
+	return BillTemplate{
+		ID:            GenerateID(),
+		UserID:        req.UserID,
+		Name:          name,
+		ProviderID:    provider.ID,
+		Fields:        fields,
+		FromAccountID: req.FromAccountID,
+		Amount:        req.Amount,
+		CreatedAt:     now,
+	}, nil
+}
+
+// PayBill списывает оплату услуги со счета клиента и передает платеж поставщику.
+// Деньги списываются сразу; если поставщик отклонит платеж, они вернутся на счет.
+func PayBill(req CreateBillPaymentRequest, now time.Time) (BillPayment, error) {
+	if req.TemplateID != "" {
+		template, ok := GetBillTemplate(req.TemplateID)
+		if !ok || template.UserID != req.UserID {
+			return BillPayment{}, fmt.Errorf("bill template %s not found", req.TemplateID)
+		}
+		applyBillTemplate(&req, template)
+	}
+
+	provider, ok := GetBillProvider(req.ProviderID)
+	if !ok {
+		return BillPayment{}, fmt.Errorf("bill provider %s not found", req.ProviderID)
+	}
+	fields, err := ValidateBillFields(provider, req.Fields)
+	if err != nil {
+		return BillPayment{}, &BillValidationError{Message: err.Error()}
+	}
+	if req.Amount.LessThan(provider.MinAmount) || req.Amount.GreaterThan(provider.MaxAmount) {
+		return BillPayment{}, &BillValidationError{Message: fmt.Sprintf("amount must be between %s and %s",
+			provider.MinAmount.String(), provider.MaxAmount.String())}
+	}
+	account, ok := GetAccount(req.FromAccountID)
+	if !ok {
+		return BillPayment{}, fmt.Errorf("account %s not found", req.FromAccountID)
+	}
+	if account.UserID != req.UserID {
+		return BillPayment{}, errAccountNotOwned
+	}
+
+	payment := BillPayment{
+		ID:           GenerateID(),
+		UserID:       req.UserID,
+		AccountID:    account.ID,
+		ProviderID:   provider.ID,
+		ProviderName: provider.Name,
+		Fields:       fields,
+		Amount:       req.Amount,
+		Status:       "pending",
+		TemplateID:   req.TemplateID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	tx := Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   account.ID,
+		Amount:          req.Amount,
+		Timestamp:       now,
+		TransactionType: "bill_payment",
+		Description:     fmt.Sprintf("Payment to %s", provider.Name),
+	}
+	payment.TransactionID = tx.ID
+	if err := AddBillPayment(payment, tx); err != nil {
+		return BillPayment{}, err
+	}
+
+	externalID, err := billConnectorFor(provider.ID).SendPayment(ProviderPayment{
+		PaymentID:  payment.ID,
+		ProviderID: provider.ID,
+		Fields:     fields,
+		Amount:     payment.Amount,
+	})
+	if err != nil {
+		log.Printf("Provider %s rejected bill payment %s: %v", provider.ID, payment.ID, err)
+		refund := billPaymentRefund(payment, time.Now())
+		settled, settleErr := SettleBillPayment(payment.ID, "failed", err.Error(), &refund, time.Now())
+		if settleErr != nil {
+			log.Printf("Failed to refund bill payment %s: %v", payment.ID, settleErr)
+			return payment, nil
+		}
+		return settled, nil
+	}
+	SetBillPaymentExternalID(payment.ID, externalID)
+	payment.ExternalID = externalID
+
+	if req.SaveAsTemplate != "" {
+		amount := payment.Amount
+		template, err := NewBillTemplate(CreateBillTemplateRequest{
+			UserID:        req.UserID,
+			Name:          req.SaveAsTemplate,
+			ProviderID:    provider.ID,
+			Fields:        fields,
+			FromAccountID: account.ID,
+			Amount:        &amount,
+		}, now)
+		if err != nil {
+			log.Printf("Failed to save bill template for payment %s: %v", payment.ID, err)
+		} else {
+			AddBillTemplate(template)
+		}
+	}
+	return payment, nil
+}
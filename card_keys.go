@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cardKeyring хранит ключи шифрования номеров карт по версиям. Новые номера шифруются
+// текущим ключом, старые версии остаются для расшифровки до перешифрования.
+type cardKeyring struct {
+	mu      sync.RWMutex
+	keys    map[int]cipher.AEAD
+	current int
+	hashKey []byte // ключ HMAC для индекса по номеру карты, при ротации не меняется
+}
+
+var cardKeys *cardKeyring
+
+// InitCardKeys загружает ключи карт при старте. Без ключей сервер не запускается: встроенных ключей для разработки нет.
+func InitCardKeys() {
+	var missing []string
+	for _, name := range []string{"BANKAPP_CARD_KEYS", "BANKAPP_CARD_HASH_KEY", "BANKAPP_CVV_KEY", "BANKAPP_PIN_KEY"} {
+		if os.Getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		log.Fatalf("Card keys are not configured, set %s", strings.Join(missing, ", "))
+	}
+	cardKeys = loadCardKeyring()
+}
+
+// loadCardKeyring читает ключи из BANKAPP_CARD_KEYS в виде "1:<base64>,2:<base64>" (ключи по 32 байта).
+// Текущая версия задается BANKAPP_CARD_KEY_VERSION, по умолчанию — наибольшая.
+func loadCardKeyring() *cardKeyring {
+	k := &cardKeyring{
+		keys:    make(map[int]cipher.AEAD),
+		hashKey: []byte(os.Getenv("BANKAPP_CARD_HASH_KEY")),
+	}
+
+	spec := os.Getenv("BANKAPP_CARD_KEYS")
+
+	for _, part := range strings.Split(spec, ",") {
+		versionStr, encoded, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			log.Fatalf("Invalid card key entry '%s', expected <version>:<base64 key>", part)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			log.Fatalf("Invalid card key version '%s'", versionStr)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			log.Fatalf("Invalid card key %d: %v", version, err)
+		}
+		if err := k.add(version, key); err != nil {
+			log.Fatalf("Invalid card key %d: %v", version, err)
+		}
+	}
+	if v := envOrDefault("BANKAPP_CARD_KEY_VERSION", ""); v != "" {
+		version, err := strconv.Atoi(v)
+		if _, ok := k.keys[version]; err != nil || !ok {
+			log.Fatalf("Card key version %s is not configured", v)
+		}
+		k.current = version
+	}
+	return k
+}
+
+func (k *cardKeyring) add(version int, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.keys[version] = aead
+	if version > k.current {
+		k.current = version
+	}
+	return nil
+}
+
+func (k *cardKeyring) CurrentVersion() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// Encrypt шифрует номер текущим ключом. ID карты участвует как дополнительные данные,
+// поэтому шифртекст нельзя переставить на другую карту.
+func (k *cardKeyring) Encrypt(pan, cardID string) ([]byte, int, error) {
+	k.mu.RLock()
+	version := k.current
+	aead := k.keys[version]
+	k.mu.RUnlock()
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, 0, err
+	}
+	return aead.Seal(nonce, nonce, []byte(pan), []byte(cardID)), version, nil
+}
+
+func (k *cardKeyring) Decrypt(ciphertext []byte, version int, cardID string) (string, error) {
+	k.mu.RLock()
+	aead, ok := k.keys[version]
+	k.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("card key version %d is not available", version)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid card number ciphertext")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, []byte(cardID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt card number: %w", err)
+	}
+	return string(plain), nil
+}
+
+// Rotate добавляет новый случайный ключ и делает его текущим.
+func (k *cardKeyring) Rotate() (int, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return 0, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	version := k.current + 1
+	if err := k.add(version, key); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func CardNumberHash(pan string) string {
+	mac := hmac.New(sha256.New, cardKeys.hashKey)
+	mac.Write([]byte(pan))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func MaskCardNumber(pan string) string {
+	if len(pan) < 10 {
+		return strings.Repeat("*", len(pan))
+	}
+	return pan[:6] + strings.Repeat("*", len(pan)-10) + pan[len(pan)-4:]
+}
+
+// SealCardNumber заполняет зашифрованный номер, маску и хэш для поиска.
+func SealCardNumber(card *Card, pan string) error {
+	encrypted, version, err := cardKeys.Encrypt(pan, card.ID)
+	if err != nil {
+		return err
+	}
+	card.EncryptedNumber = encrypted
+	card.KeyVersion = version
+	card.MaskedNumber = MaskCardNumber(pan)
+	card.NumberHash = CardNumberHash(pan)
+	return nil
+}
+
+func RevealCardNumber(card Card) (string, error) {
+	return cardKeys.Decrypt(card.EncryptedNumber, card.KeyVersion, card.ID)
+}
+
+// RotateCardKey выпускает новый ключ и перешифровывает им номера всех карт.
+func RotateCardKey() (int, int, error) {
+	version, err := cardKeys.Rotate()
+	if err != nil {
+		return 0, 0, err
+	}
+	count, err := RewrapCardNumbers(version, func(card Card) (Card, error) {
+		pan, err := RevealCardNumber(card)
+		if err != nil {
+			return Card{}, err
+		}
+		if err := SealCardNumber(&card, pan); err != nil {
+			return Card{}, err
+		}
+		return card, nil
+	})
+	if err != nil {
+		return version, 0, err
+	}
+	log.Printf("Card encryption key rotated to version %d, %d cards re-encrypted", version, count)
+	return version, count, nil
+}
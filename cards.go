@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var cardSecurityConfig = struct {
+	CVVKey            []byte // ключ HMAC для хранения CVV
+	MaxFailedCVV      int    // после стольких неудачных проверок подряд карта блокируется
+	PINKey            []byte // ключ HMAC для хранения PIN
+	MaxFailedPIN      int
+	RevealTTL         time.Duration // время жизни кода для показа полного номера
+	MaxRevealAttempts int
+}{
+	CVVKey:            []byte(os.Getenv("BANKAPP_CVV_KEY")), // проверяется в InitCardKeys
+	MaxFailedCVV:      3,
+	PINKey:            []byte(os.Getenv("BANKAPP_PIN_KEY")),
+	MaxFailedPIN:      3,
+	RevealTTL:         5 * time.Minute,
+	MaxRevealAttempts: 3,
+}
+
+const maxCardNumberAttempts = 10
+
+var cardTypes = map[string]struct {
+	DefaultMonths int
+	MaxMonths     int
+	SpendingLimit bool // можно ли задать собственный лимит трат
+}{
+	"physical":   {DefaultMonths: 48, MaxMonths: 48},
+	"virtual":    {DefaultMonths: 12, MaxMonths: 36, SpendingLimit: true},
+	"single_use": {DefaultMonths: 1, MaxMonths: 12, SpendingLimit: true},
+}
+
+// NormalizeCardRequest проверяет параметры выпуска и подставляет значения по умолчанию.
+func NormalizeCardRequest(req *GenerateCardRequest) error {
+	if req.Product == "" {
+		req.Product = "visa"
+	}
+	if _, ok := cardProducts[req.Product]; !ok {
+		return fmt.Errorf("unsupported card product '%s'", req.Product)
+	}
+	if req.Type == "" {
+		req.Type = "physical"
+	}
+	cardType, ok := cardTypes[req.Type]
+	if !ok {
+		return fmt.Errorf("unsupported card type '%s'", req.Type)
+	}
+	if req.ValidMonths == 0 {
+		req.ValidMonths = cardType.DefaultMonths
+	}
+	if req.ValidMonths < 1 || req.ValidMonths > cardType.MaxMonths {
+		return fmt.Errorf("%s card validity must be between 1 and %d months", req.Type, cardType.MaxMonths)
+	}
+	if req.SpendingLimit != nil {
+		if !cardType.SpendingLimit {
+			return fmt.Errorf("spending limit is only available for virtual and single-use cards")
+		}
+		if !req.SpendingLimit.IsPositive() {
+			return fmt.Errorf("spending limit must be positive")
+		}
+	}
+	return nil
+}
+
+// NewCard выпускает карту и возвращает CVV отдельно: в карте хранится только его хэш.
+func NewCard(req GenerateCardRequest) (Card, string, error) {
+	number, err := GenerateCardNumber(req.Product)
+	if err != nil {
+		return Card{}, "", err
+	}
+	month, year := GenerateExpiryDate(req.ValidMonths)
+	card := Card{
+		ID:            GenerateID(),
+		AccountID:     req.AccountID,
+		Product:       req.Product,
+		Type:          req.Type,
+		SpendingLimit: req.SpendingLimit,
+		ExpiryMonth:   month,
+		ExpiryYear:    year,
+		Status:        "active",
+		CreatedAt:     time.Now(),
+	}
+	if err := SealCardNumber(&card, number); err != nil {
+		return Card{}, "", err
+	}
+	cvv := GenerateCVV()
+	card.CVVHash = HashCVV(card.ID, cvv)
+	return card, cvv, nil
+}
+
+// IssueCard выпускает и сохраняет карту, перегенерируя номер при совпадении с уже выпущенным.
+func IssueCard(req GenerateCardRequest) (Card, string, error) {
+	for attempt := 1; ; attempt++ {
+		card, cvv, err := NewCard(req)
+		if err != nil {
+			return Card{}, "", err
+		}
+		err = AddCard(card)
+		if err == nil {
+			return card, cvv, nil
+		}
+		if !errors.Is(err, errCardNumberTaken) || attempt >= maxCardNumberAttempts {
+			return Card{}, "", err
+		}
+		log.Printf("Card number collision, regenerating")
+	}
+}
+
+// ReissueCard выпускает замену карты того же продукта и типа на тот же счет.
+func ReissueCard(old Card, byOperator bool, now time.Time) (Card, Card, string, error) {
+	if old.Type == "single_use" {
+		return Card{}, Card{}, "", errSingleUseReissue
+	}
+	req := GenerateCardRequest{AccountID: old.AccountID, Product: old.Product, Type: old.Type, SpendingLimit: old.SpendingLimit}
+	if err := NormalizeCardRequest(&req); err != nil {
+		return Card{}, Card{}, "", err
+	}
+	for attempt := 1; ; attempt++ {
+		newCard, cvv, err := NewCard(req)
+		if err != nil {
+			return Card{}, Card{}, "", err
+		}
+		replaced, card, err := ReplaceCard(old.ID, newCard, byOperator, now)
+		if err == nil {
+			return replaced, card, cvv, nil
+		}
+		if !errors.Is(err, errCardNumberTaken) || attempt >= maxCardNumberAttempts {
+			return Card{}, Card{}, "", err
+		}
+		log.Printf("Card number collision, regenerating")
+	}
+}
+
+// HashCVV привязывает CVV к карте, чтобы одинаковые коды разных карт давали разные хэши.
+func HashCVV(cardID, cvv string) string {
+	return cardSecretMAC(cardSecurityConfig.CVVKey, cardID, cvv)
+}
+
+func HashPIN(cardID, pin string) string {
+	return cardSecretMAC(cardSecurityConfig.PINKey, cardID, pin)
+}
+
+func cardSecretMAC(key []byte, cardID, secret string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(cardID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidatePIN допускает только 4 цифры и отклоняет очевидные комбинации вроде 1111 и 1234.
+func ValidatePIN(pin string) error {
+	if len(pin) != 4 {
+		return fmt.Errorf("PIN must contain 4 digits")
+	}
+	for _, ch := range pin {
+		if ch < '0' || ch > '9' {
+			return fmt.Errorf("PIN must contain only digits")
+		}
+	}
+	same, ascending, descending := true, true, true
+	for i := 1; i < len(pin); i++ {
+		same = same && pin[i] == pin[0]
+		ascending = ascending && pin[i] == pin[i-1]+1
+		descending = descending && pin[i] == pin[i-1]-1
+	}
+	if same || ascending || descending {
+		return fmt.Errorf("PIN is too simple")
+	}
+	return nil
+}
+
+// VerifyCardNotPresent проверяет срок действия и CVV при оплате без карты.
+// Неудачные проверки считаются, и после cardSecurityConfig.MaxFailedCVV карта блокируется.
+func VerifyCardNotPresent(card Card, expiryMonth, expiryYear int, cvv string) error {
+	givenHash := HashCVV(card.ID, cvv)
+	return verifyCardSecret(card.ID, "cvv", cardSecurityConfig.MaxFailedCVV,
+		func(c *Card) *int { return &c.FailedCVVChecks },
+		func(c Card) bool {
+			cvvOK := cardSecretMatches(c.CVVHash, givenHash)
+			return cvvOK && c.ExpiryMonth == expiryMonth && c.ExpiryYear == expiryYear
+		})
+}
+
+// VerifyCardPIN проверяет PIN-код карты. После cardSecurityConfig.MaxFailedPIN неверных попыток подряд карта блокируется.
+// Такую блокировку (security_blocked) снимает только сотрудник банка.
+func VerifyCardPIN(card Card, pin string) error {
+	if card.PINHash == "" {
+		return errPINNotSet
+	}
+	givenHash := HashPIN(card.ID, pin)
+	return verifyCardSecret(card.ID, "pin", cardSecurityConfig.MaxFailedPIN,
+		func(c *Card) *int { return &c.FailedPINAttempts },
+		func(c Card) bool { return cardSecretMatches(c.PINHash, givenHash) })
+}
+
+// cardSecretMatches сравнивает хэши за постоянное время.
+func cardSecretMatches(storedHash, givenHash string) bool {
+	expected, _ := hex.DecodeString(storedHash)
+	given, _ := hex.DecodeString(givenHash)
+	return hmac.Equal(expected, given)
+}
+
+// verifyCardSecret проверяет порог попыток, сравнивает код и ведет счетчик под одной блокировкой хранилища,
+// чтобы параллельные запросы не перебирали коды сверх лимита. После исчерпания попыток ответ одинаков
+// для верного и неверного кода.
+func verifyCardSecret(cardID, method string, maxFailed int, counter func(*Card) *int, matches func(Card) bool) error {
+	now := time.Now()
+	var blockedNow bool
+	var attemptsLeft int
+	matched := false
+	_, err := UpdateCard(cardID, func(c *Card) error {
+		if c.Status == "security_blocked" || *counter(c) >= maxFailed {
+			return nil
+		}
+		if matches(*c) {
+			matched = true
+			*counter(c) = 0
+			return nil
+		}
+		*counter(c)++
+		attemptsLeft = maxFailed - *counter(c)
+		if attemptsLeft <= 0 && c.Status == "active" {
+			c.Status = "security_blocked"
+			c.StatusReason = fmt.Sprintf("too many failed %s attempts", method)
+			c.StatusChangedAt = &now
+			blockedNow = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if matched {
+		return nil
+	}
+	if blockedNow {
+		log.Printf("Card %s blocked after %d failed %s attempts", cardID, maxFailed, method)
+	}
+	if attemptsLeft < 0 {
+		attemptsLeft = 0
+	}
+	return &CardVerificationError{CardID: cardID, Method: method, AttemptsLeft: attemptsLeft}
+}
+
+func NewCardToken(cardID, merchantID string) CardToken {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return CardToken{
+		Token:      "tok_" + hex.EncodeToString(raw),
+		CardID:     cardID,
+		MerchantID: merchantID,
+		Status:     "active",
+		CreatedAt:  time.Now(),
+	}
+}
+
+func GenerateRevealCode() string {
+	n, _ := rand.Int(rand.Reader, big.NewInt(1000000))
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+// AuthorizeCardPayment проверяет карту и способ аутентификации (токен или срок действия с CVV, а также PIN, если он передан;
+// только PIN — если карта предъявлена на терминале) и проводит оплату. При capture == false выполняется только авторизация без списания.
+func AuthorizeCardPayment(req PaymentRequest, capture bool) (Transaction, error) {
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		return Transaction{}, errNonPositiveAmount
+	}
+	merchant, err := ResolvePaymentMerchant(req.MerchantID)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	var card Card
+	if req.Token != "" {
+		token, tokenCard, ok := GetCardByToken(req.Token)
+		// Токен действует только у мерчанта, для которого выпущен.
+		if !ok || token.Status != "active" || token.MerchantID != merchant.ID {
+			return Transaction{}, errInvalidPaymentToken
+		}
+		card = tokenCard
+		if err := CheckCardUsable(card, time.Now()); err != nil {
+			return Transaction{}, err
+		}
+	} else {
+		pinOnly := req.CardPresent && req.PIN != ""
+		if !pinOnly && (req.ExpiryMonth == 0 || req.ExpiryYear == 0 || req.CVV == "") {
+			return Transaction{}, errCardDataRequired
+		}
+		if err := ValidateCardNumber(req.CardNumber); err != nil {
+			return Transaction{}, fmt.Errorf("%w: %v", errInvalidCardNumber, err)
+		}
+
+		var ok bool
+		card, ok = GetCardByNumber(req.CardNumber)
+		if !ok {
+			return Transaction{}, errCardNotFound
+		}
+		if err := CheckCardUsable(card, time.Now()); err != nil {
+			return Transaction{}, err
+		}
+		if !pinOnly {
+			if err := VerifyCardNotPresent(card, req.ExpiryMonth, req.ExpiryYear, req.CVV); err != nil {
+				return Transaction{}, err
+			}
+		}
+		if req.PIN != "" {
+			if err := VerifyCardPIN(card, req.PIN); err != nil {
+				return Transaction{}, err
+			}
+		}
+	}
+
+	tx := Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   card.AccountID,
+		Amount:          req.Amount,
+		Timestamp:       time.Now(),
+		TransactionType: "payment",
+		Description:     fmt.Sprintf("Payment to %s", merchant.Name),
+		CardID:          card.ID,
+		MerchantID:      merchant.ID,
+	}
+	if !capture {
+		return tx, CheckTransaction(tx)
+	}
+	txs, _, err := ChargeFee(tx, "card_payment", "")
+	if err != nil {
+		return Transaction{}, err
+	}
+	reward, accrued, err := PostCardPurchase(txs)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if accrued {
+		log.Printf("Cashback %s accrued for payment %s", reward.Amount.String(), txs[0].ID)
+	}
+	return txs[0], nil
+}
+
+// CheckCardUsable возвращает ошибку, если по карте нельзя проводить операции.
+func CheckCardUsable(card Card, now time.Time) error {
+	if card.Status == "active" && card.IsExpired(now) {
+		return &CardStatusError{CardID: card.ID, Status: "expired"}
+	}
+	if card.Status != "active" {
+		return &CardStatusError{CardID: card.ID, Status: card.Status}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var cashbackConfig = struct {
+	MonthlyCap decimal.Decimal // общий лимит кешбэка на клиента за месяц
+	MaxPercent decimal.Decimal
+}{
+	MonthlyCap: decimal.NewFromInt(5000),
+	MaxPercent: decimal.NewFromInt(30),
+}
+
+// defaultCashbackRules — базовая ставка и повышенный кешбэк в популярных категориях.
+var defaultCashbackRules = []CreateCashbackRuleRequest{
+	{Percent: decimal.NewFromInt(1)},
+	{MCC: "5411", Percent: decimal.NewFromInt(3), MonthlyCap: decimalPtr(decimal.NewFromInt(2000))}, // продуктовые магазины
+	{MCC: "5812", Percent: decimal.NewFromInt(5), MonthlyCap: decimalPtr(decimal.NewFromInt(3000))}, // рестораны
+	{MCC: "5814", Percent: decimal.NewFromInt(5), MonthlyCap: decimalPtr(decimal.NewFromInt(3000))}, // фастфуд
+	{MCC: "5912", Percent: decimal.NewFromInt(2)},                                                   // аптеки
+	{MCC: "4111", Percent: decimal.NewFromInt(5), MonthlyCap: decimalPtr(decimal.NewFromInt(1000))}, // пассажирские перевозки
+}
+
+func InitCashbackRules() {
+	now := time.Now()
+	for _, req := range defaultCashbackRules {
+		rule, err := NewCashbackRule(req, now)
+		if err != nil {
+			log.Fatalf("Invalid default cashback rule for MCC '%s': %v", req.MCC, err)
+		}
+		AddCashbackRule(rule)
+	}
+	log.Printf("Loaded %d default cashback rules", len(defaultCashbackRules))
+}
+
+func NewCashbackRule(req CreateCashbackRuleRequest, now time.Time) (CashbackRule, error) {
+	if req.MerchantID != "" && req.MCC != "" {
+		return CashbackRule{}, fmt.Errorf("rule can be set either for a partner merchant or for an MCC, not both")
+	}
+	if req.MCC != "" {
+		if err := ValidateMCC(req.MCC); err != nil {
+			return CashbackRule{}, err
+		}
+	}
+	if req.MerchantID != "" {
+		if _, ok := GetMerchant(req.MerchantID); !ok {
+			return CashbackRule{}, errMerchantNotFound
+		}
+	}
+	if !req.Percent.IsPositive() || req.Percent.GreaterThan(cashbackConfig.MaxPercent) {
+		return CashbackRule{}, fmt.Errorf("percent must be between 0 and %s", cashbackConfig.MaxPercent.String())
+	}
+	if req.MonthlyCap != nil && !req.MonthlyCap.IsPositive() {
+		return CashbackRule{}, fmt.Errorf("monthly cap must be positive")
+	}
+	return CashbackRule{
+		ID:         GenerateID(),
+		MerchantID: req.MerchantID,
+		MCC:        req.MCC,
+		Percent:    req.Percent,
+		MonthlyCap: req.MonthlyCap,
+		CreatedAt:  now,
+	}, nil
+}
+
+func CashbackPeriod(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+func ValidateCashbackPeriod(period string) error {
+	if _, err := time.Parse("2006-01", period); err != nil {
+		return fmt.Errorf("period must be in YYYY-MM format")
+	}
+	return nil
+}
+
+func (rule CashbackRule) specificity() int {
+	switch {
+	case rule.MerchantID != "":
+		return 2
+	case rule.MCC != "":
+		return 1
+	default:
+		return 0
+	}
+}
+
+func matchCashbackRuleLocked(merchant Merchant) (CashbackRule, bool) {
+	var best CashbackRule
+	found := false
+	for _, rule := range storage.cashbackRules {
+		if (rule.MerchantID != "" && rule.MerchantID != merchant.ID) || (rule.MCC != "" && rule.MCC != merchant.MCC) {
+			continue
+		}
+		if !found || rule.specificity() > best.specificity() ||
+			(rule.specificity() == best.specificity() && rule.CreatedAt.After(best.CreatedAt)) {
+			best, found = rule, true
+		}
+	}
+	return best, found
+}
+
+// cashbackAccruedLocked суммирует кешбэк клиента за месяц (кроме отмененного); с ruleID — только по этому правилу.
+func cashbackAccruedLocked(userID, period, ruleID string) decimal.Decimal {
+	total := decimal.Zero
+	for _, id := range storage.cashbackIndex[userID] {
+		reward := storage.cashbackRewards[id]
+		if reward.Period == period && reward.Status != "cancelled" && (ruleID == "" || reward.RuleID == ruleID) {
+			total = total.Add(reward.Amount)
+		}
+	}
+	return total
+}
+
+// accrueCashbackLocked начисляет кешбэк за оплату картой с учетом лимита правила и общего месячного лимита клиента.
+func accrueCashbackLocked(tx Transaction) (CashbackReward, bool) {
+	if tx.TransactionType != "payment" || tx.CardID == "" {
+		return CashbackReward{}, false
+	}
+	merchant, ok := storage.merchants[tx.MerchantID]
+	if !ok {
+		return CashbackReward{}, false
+	}
+	account, ok := storage.accounts[tx.FromAccountID]
+	if !ok {
+		return CashbackReward{}, false
+	}
+	rule, ok := matchCashbackRuleLocked(merchant)
+	if !ok {
+		return CashbackReward{}, false
+	}
+
+	period := CashbackPeriod(tx.Timestamp)
+	amount := tx.Amount.Mul(rule.Percent).Div(decimal.NewFromInt(100)).RoundDown(2)
+	left := cashbackConfig.MonthlyCap.Sub(cashbackAccruedLocked(account.UserID, period, ""))
+	if rule.MonthlyCap != nil {
+		left = decimal.Min(left, rule.MonthlyCap.Sub(cashbackAccruedLocked(account.UserID, period, rule.ID)))
+	}
+	capped := false
+	if amount.GreaterThan(left) {
+		amount, capped = left, true
+	}
+	if !amount.IsPositive() {
+		return CashbackReward{}, false
+	}
+
+	reward := CashbackReward{
+		ID:             GenerateID(),
+		UserID:         account.UserID,
+		AccountID:      account.ID,
+		CardID:         tx.CardID,
+		TransactionID:  tx.ID,
+		MerchantID:     merchant.ID,
+		MCC:            merchant.MCC,
+		RuleID:         rule.ID,
+		Period:         period,
+		PurchaseAmount: tx.Amount,
+		RefundedAmount: decimal.Zero,
+		Percent:        rule.Percent,
+		Amount:         amount,
+		Capped:         capped,
+		Status:         "pending",
+		CreatedAt:      tx.Timestamp,
+	}
+	storage.cashbackRewards[reward.ID] = reward
+	storage.cashbackIndex[reward.UserID] = append(storage.cashbackIndex[reward.UserID], reward.ID)
+	storage.cashbackTxIndex[tx.ID] = reward.ID
+	return reward, true
+}
+
+// adjustCashbackLocked уменьшает еще не выплаченный кешбэк за покупку после возврата или отмены оплаты.
+// Уже выплаченный кешбэк не пересчитывается.
+func adjustCashbackLocked(paymentID string, refunded decimal.Decimal) {
+	id, ok := storage.cashbackTxIndex[paymentID]
+	if !ok {
+		return
+	}
+	reward := storage.cashbackRewards[id]
+	if reward.Status != "pending" {
+		return
+	}
+	reward.RefundedAmount = reward.RefundedAmount.Add(refunded)
+	remaining := reward.PurchaseAmount.Sub(reward.RefundedAmount)
+	if remaining.IsPositive() {
+		reward.Amount = decimal.Min(reward.Amount, remaining.Mul(reward.Percent).Div(decimal.NewFromInt(100)).RoundDown(2))
+	} else {
+		reward.Amount = decimal.Zero
+	}
+	if !reward.Amount.IsPositive() {
+		reward.Status = "cancelled"
+	}
+	storage.cashbackRewards[id] = reward
+}
+
+// previousCashbackPeriod — последний завершенный месяц, кешбэк за который пора зачислять.
+func previousCashbackPeriod(now time.Time) string {
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return CashbackPeriod(startOfMonth.AddDate(0, 0, -1))
+}
+
+// PayoutCashback зачисляет ожидающий кешбэк за месяцы по period включительно.
+func PayoutCashback(period string, now time.Time) []Transaction {
+	payouts := make([]Transaction, 0)
+	for _, userID := range GetPendingCashbackUsers(period) {
+		txs, err := PayUserCashback(userID, period, now)
+		if err != nil {
+			log.Printf("Cashback payout for user %s failed: %v", userID, err)
+		}
+		for _, tx := range txs {
+			log.Printf("Cashback of %s credited to account %s", tx.Amount.String(), tx.ToAccountID)
+		}
+		payouts = append(payouts, txs...)
+	}
+	return payouts
+}
+
+// PayoutMonthlyCashback — ежемесячное зачисление кешбэка за прошедший месяц. Запускается ежедневно,
+// поэтому кешбэк, который не удалось зачислить, будет зачислен при следующем запуске.
+func PayoutMonthlyCashback(now time.Time) {
+	PayoutCashback(previousCashbackPeriod(now), now)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type termRateTier struct {
+	MinTermMonths int
+	Rate          decimal.Decimal
+}
+
+var termDepositConfig = struct {
+	MinAmount        decimal.Decimal
+	MinTermMonths    int
+	MaxTermMonths    int
+	FixedRates       []termRateTier  // по возрастанию MinTermMonths
+	KeyRateSpread    decimal.Decimal // ставка = ключевая ставка ЦБ - спред
+	EarlyClosureRate decimal.Decimal
+}{
+	MinAmount:     decimal.NewFromInt(10000),
+	MinTermMonths: 1,
+	MaxTermMonths: 60,
+	FixedRates: []termRateTier{
+		{MinTermMonths: 1, Rate: decimal.NewFromInt(12)},
+		{MinTermMonths: 6, Rate: decimal.NewFromInt(13)},
+		{MinTermMonths: 12, Rate: decimal.NewFromInt(14)},
+		{MinTermMonths: 24, Rate: decimal.NewFromFloat(12.5)},
+	},
+	KeyRateSpread:    decimal.NewFromInt(2),
+	EarlyClosureRate: decimal.NewFromFloat(0.01),
+}
+
+func TermDepositRate(rateType string, termMonths int) (decimal.Decimal, error) {
+	switch rateType {
+	case "fixed":
+		rate := decimal.Zero
+		for _, tier := range termDepositConfig.FixedRates {
+			if termMonths >= tier.MinTermMonths {
+				rate = tier.Rate
+			}
+		}
+		return rate, nil
+	case "key_rate":
+		keyRate, err := GetCBRKeyRate()
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("failed to get key rate: %w", err)
+		}
+		rate := keyRate.Sub(termDepositConfig.KeyRateSpread)
+		if rate.IsNegative() {
+			rate = decimal.Zero
+		}
+		return rate, nil
+	default:
+		return decimal.Zero, fmt.Errorf("unknown rate type '%s'", rateType)
+	}
+}
+
+// CalculateSimpleInterest считает проценты по формуле сумма * ставка * дни / 365.
+func CalculateSimpleInterest(amount decimal.Decimal, annualRate decimal.Decimal, from, to time.Time) decimal.Decimal {
+	days := int64(to.Sub(from).Hours() / 24)
+	if days <= 0 {
+		return decimal.Zero
+	}
+	return amount.Mul(annualRate).Div(decimal.NewFromInt(100)).
+		Mul(decimal.NewFromInt(days)).Div(decimal.NewFromInt(365)).RoundBank(2)
+}
+
+func termDepositInterestTx(deposit TermDeposit, amount decimal.Decimal, now time.Time) Transaction {
+	return Transaction{
+		ID:              GenerateID(),
+		ToAccountID:     deposit.AccountID,
+		Amount:          amount,
+		Timestamp:       now,
+		TransactionType: "term_deposit_interest",
+		Description:     fmt.Sprintf("Interest on term deposit (ID: %s)", deposit.ID),
+	}
+}
+
+func termDepositReturnTx(deposit TermDeposit, amount decimal.Decimal, now time.Time) Transaction {
+	return Transaction{
+		ID:              GenerateID(),
+		ToAccountID:     deposit.AccountID,
+		Amount:          amount,
+		Timestamp:       now,
+		TransactionType: "term_deposit_closure",
+		Description:     fmt.Sprintf("Term deposit closure (ID: %s)", deposit.ID),
+	}
+}
+
+// ProcessTermDeposit выплачивает наступившие ежемесячные проценты и обрабатывает окончание срока вклада.
+func ProcessTermDeposit(depositID string, now time.Time) (TermDeposit, error) {
+	rolledOver := false
+	deposit, err := UpdateTermDeposit(depositID, func(deposit *TermDeposit) ([]Transaction, error) {
+		var txs []Transaction
+
+		if deposit.InterestPayout == "monthly" {
+			for deposit.PaidPeriods < deposit.TermMonths {
+				periodStart := deposit.StartDate.AddDate(0, deposit.PaidPeriods, 0)
+				periodEnd := deposit.StartDate.AddDate(0, deposit.PaidPeriods+1, 0)
+				if now.Before(periodEnd) {
+					break
+				}
+				interest := CalculateSimpleInterest(deposit.Amount, deposit.InterestRate, periodStart, periodEnd)
+				if interest.IsPositive() {
+					txs = append(txs, termDepositInterestTx(*deposit, interest, now))
+					deposit.InterestPaid = deposit.InterestPaid.Add(interest)
+				}
+				deposit.PaidPeriods++
+			}
+		}
+
+		if now.Before(deposit.MaturityDate) {
+			return txs, nil
+		}
+
+		if deposit.InterestPayout == "maturity" {
+			interest := CalculateSimpleInterest(deposit.Amount, deposit.InterestRate, deposit.StartDate, deposit.MaturityDate)
+			if interest.IsPositive() {
+				txs = append(txs, termDepositInterestTx(*deposit, interest, now))
+				deposit.InterestPaid = deposit.InterestPaid.Add(interest)
+			}
+		}
+
+		if deposit.AutoRollover {
+			rate, err := TermDepositRate(deposit.RateType, deposit.TermMonths)
+			if err != nil {
+				return nil, err
+			}
+			deposit.InterestRate = rate
+			deposit.StartDate = deposit.MaturityDate
+			deposit.MaturityDate = deposit.StartDate.AddDate(0, deposit.TermMonths, 0)
+			deposit.InterestPaid = decimal.Zero
+			deposit.PaidPeriods = 0
+			deposit.Rollovers++
+			rolledOver = true
+		} else {
+			txs = append(txs, termDepositReturnTx(*deposit, deposit.Amount, now))
+			deposit.Status = "closed"
+			deposit.ClosedAt = &now
+		}
+		return txs, nil
+	})
+	if err != nil {
+		return TermDeposit{}, err
+	}
+
+	if rolledOver {
+		log.Printf("Term deposit %s rolled over until %s at %s%%", deposit.ID, deposit.MaturityDate.Format("2006-01-02"), deposit.InterestRate.String())
+	} else if deposit.Status == "closed" {
+		log.Printf("Term deposit %s matured, %s returned to account %s", deposit.ID, deposit.Amount.String(), deposit.AccountID)
+	}
+	return deposit, nil
+}
+
+// CloseTermDepositEarly пересчитывает проценты по ставке досрочного расторжения.
+// Уже выплаченные за текущий срок проценты удерживаются из возвращаемой суммы.
+func CloseTermDepositEarly(depositID string, now time.Time) (TermDeposit, decimal.Decimal, error) {
+	payout := decimal.Zero
+	deposit, err := UpdateTermDeposit(depositID, func(deposit *TermDeposit) ([]Transaction, error) {
+		interest := CalculateSimpleInterest(deposit.Amount, deposit.EarlyClosureRate, deposit.StartDate, now)
+		payout = decimal.Max(deposit.Amount.Add(interest).Sub(deposit.InterestPaid), decimal.Zero)
+
+		deposit.Status = "closed_early"
+		deposit.ClosedAt = &now
+
+		var txs []Transaction
+		if payout.IsPositive() {
+			txs = append(txs, termDepositReturnTx(*deposit, payout, now))
+		}
+		return txs, nil
+	})
+	if err != nil {
+		return TermDeposit{}, decimal.Zero, err
+	}
+	return deposit, payout, nil
+}
+
+func ProcessTermDeposits(now time.Time) {
+	for _, deposit := range GetActiveTermDeposits() {
+		if _, err := ProcessTermDeposit(deposit.ID, now); err != nil {
+			log.Printf("Failed to process term deposit %s: %v", deposit.ID, err)
+		}
+	}
+}
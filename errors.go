@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	errAccountNumberTaken   = errors.New("account number already exists")
+	errCardNumberTaken      = errors.New("card number already exists")
+	errInvalidRevealCode    = errors.New("invalid confirmation code")
+	errPINNotSet            = errors.New("PIN is not set for this card")
+	errSingleUseReissue     = errors.New("single-use cards cannot be reissued")
+	errCardNotFound         = errors.New("card not found")
+	errInvalidCardNumber    = errors.New("invalid card number")
+	errCardDataRequired     = errors.New("card expiry month, year and CVV are required")
+	errInvalidPaymentToken  = errors.New("invalid payment token")
+	errDuplicateCardPayment = errors.New("duplicate card payment")
+	errMerchantNotFound     = errors.New("merchant not found")
+	errMerchantInactive     = errors.New("merchant is not accepting payments")
+	errAcceptorIDTaken      = errors.New("acceptor ID already exists")
+	errInvalidQRPayload     = errors.New("invalid QR code or payment link")
+	errQRPaymentNotActive   = errors.New("QR payment is no longer active")
+	errQRPaymentExpired     = errors.New("QR payment has expired")
+	errQRAmountMismatch     = errors.New("amount does not match the QR payment")
+	errMoneyRequestClosed   = errors.New("money request is no longer pending")
+	errInvoiceNotPayable    = errors.New("invoice is paid or cancelled")
+	errAccountNotOwned      = errors.New("account does not belong to the user")
+	errNotPayer             = errors.New("only the payer can respond")
+	errNotRequester         = errors.New("only the requester can cancel the money request")
+	errNotIssuer            = errors.New("only the issuer can cancel the invoice")
+	errInvoiceNotCancelable = errors.New("invoice already has payments or is closed")
+	errNotFound             = errors.New("not found") // оборачивается с указанием объекта: "invoice <id> not found"
+	errCardNotPhysical      = errors.New("only physical cards can be used at ATMs")
+	errInvalidCashAmount    = errors.New("invalid cash amount")
+	errATMIDRequired        = errors.New("ATM ID is required")
+	errATMNotFound          = errors.New("ATM not found")
+	errATMIDTaken           = errors.New("ATM ID already registered")
+	errATMMismatch          = errors.New("operation was made at another ATM")
+	errATMReportTooLate     = errors.New("dispense failure can no longer be reported by the ATM")
+	errSameAccount          = errors.New("cannot transfer to the same account")
+	errCurrencyMismatch     = errors.New("accounts have different currencies")
+	errNonPositiveAmount    = errors.New("amount must be positive")
+)
+
+type RecipientError struct {
+	Code    string // INVALID_IDENTIFIER | RECIPIENT_NOT_FOUND | RECIPIENT_AMBIGUOUS
+	Message string
+}
+
+func (e *RecipientError) Error() string {
+	return e.Message
+}
+
+func (e *RecipientError) HTTPStatus() int {
+	switch e.Code {
+	case "RECIPIENT_NOT_FOUND":
+		return http.StatusNotFound
+	case "RECIPIENT_AMBIGUOUS":
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+type InsufficientFundsError struct {
+	AccountID string
+	Requested decimal.Decimal
+	Available decimal.Decimal
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("insufficient funds in account %s: requested %s, available %s",
+		e.AccountID, e.Requested.String(), e.Available.String())
+}
+
+type AccountStatusError struct {
+	AccountID string
+	Status    string
+}
+
+func (e *AccountStatusError) Error() string {
+	return fmt.Sprintf("account %s is %s", e.AccountID, e.Status)
+}
+
+func (e *AccountStatusError) Code() string {
+	return "ACCOUNT_" + strings.ToUpper(e.Status)
+}
+
+func (e *AccountStatusError) HTTPStatus() int {
+	if e.Status == "closed" {
+		return http.StatusGone
+	}
+	return http.StatusLocked
+}
+
+type CardStatusError struct {
+	CardID string
+	Status string
+}
+
+func (e *CardStatusError) Error() string {
+	return fmt.Sprintf("card %s is %s", e.CardID, strings.ReplaceAll(e.Status, "_", " "))
+}
+
+func (e *CardStatusError) Code() string {
+	return "CARD_" + strings.ToUpper(e.Status)
+}
+
+// CardVerificationError не уточняет, что именно не совпало — срок действия или CVV.
+type CardVerificationError struct {
+	CardID       string
+	Method       string // cvv | pin
+	AttemptsLeft int
+}
+
+func (e *CardVerificationError) Error() string {
+	if e.Method == "pin" {
+		return "incorrect PIN"
+	}
+	return "invalid card expiry date or CVV"
+}
+
+func (e *CardVerificationError) Code() string {
+	if e.Method == "pin" {
+		return "INCORRECT_PIN"
+	}
+	return "CARD_VERIFICATION_FAILED"
+}
+
+type RefundExceedsPaymentError struct {
+	PaymentID  string
+	Refundable decimal.Decimal
+}
+
+func (e *RefundExceedsPaymentError) Error() string {
+	return fmt.Sprintf("refund exceeds the refundable amount %s of payment %s", e.Refundable.String(), e.PaymentID)
+}
+
+// BillValidationError — реквизиты или сумма платежа не соответствуют правилам поставщика.
+type BillValidationError struct {
+	Message string
+}
+
+func (e *BillValidationError) Error() string {
+	return e.Message
+}
+
+type InvoiceOverpaymentError struct {
+	InvoiceID   string
+	Outstanding decimal.Decimal
+}
+
+func (e *InvoiceOverpaymentError) Error() string {
+	return fmt.Sprintf("payment exceeds the outstanding amount %s of invoice %s", e.Outstanding.String(), e.InvoiceID)
+}
+
+type LimitExceededError struct {
+	Scope     string // card | account | user
+	OwnerID   string
+	Category  string // card_payment | transfer | cash | spending_limit | sbp
+	Period    string // transaction | daily | monthly | lifetime
+	Limit     decimal.Decimal
+	Remaining decimal.Decimal
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s %s limit for %s on %s %s exceeded, remaining %s",
+		e.Period, e.Category, e.Limit.String(), e.Scope, e.OwnerID, e.Remaining.String())
+}
+
+// FeeChangedError — комиссия по тарифу отличается от той, что клиент подтвердил.
+type FeeChangedError struct {
+	QuotedFee decimal.Decimal
+	Fee       decimal.Decimal
+}
+
+func (e *FeeChangedError) Error() string {
+	return fmt.Sprintf("transfer fee changed from %s to %s, prepare the transfer again", e.QuotedFee.String(), e.Fee.String())
+}
+
+// respondDebitError переводит ошибку списания со счета в HTTP-ответ.
+func respondDebitError(w http.ResponseWriter, err error, action string) {
+	var insufficient *InsufficientFundsError
+	var statusErr *AccountStatusError
+	var recipientErr *RecipientError
+	var limitErr *LimitExceededError
+	var cardErr *CardStatusError
+	var verifyErr *CardVerificationError
+	var refundErr *RefundExceedsPaymentError
+	var overpaymentErr *InvoiceOverpaymentError
+	var billErr *BillValidationError
+	var feeErr *FeeChangedError
+	switch {
+	case errors.Is(err, errSameAccount), errors.Is(err, errNonPositiveAmount),
+		errors.Is(err, errInvalidCardNumber), errors.Is(err, errCardDataRequired),
+		errors.Is(err, errInvalidQRPayload), errors.Is(err, errQRAmountMismatch),
+		errors.Is(err, errInvalidCashAmount), errors.Is(err, errATMIDRequired):
+		respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errCurrencyMismatch):
+		respondErrorDetails(w, http.StatusBadRequest, err.Error(), map[string]interface{}{
+			"code": "CURRENCY_MISMATCH",
+		})
+	case errors.Is(err, errQRPaymentNotActive), errors.Is(err, errQRPaymentExpired):
+		code := "QR_NOT_ACTIVE"
+		if errors.Is(err, errQRPaymentExpired) {
+			code = "QR_EXPIRED"
+		}
+		respondErrorDetails(w, http.StatusConflict, err.Error(), map[string]interface{}{
+			"code": code,
+		})
+	case errors.Is(err, errMerchantInactive):
+		respondErrorDetails(w, http.StatusForbidden, err.Error(), map[string]interface{}{
+			"code": "MERCHANT_INACTIVE",
+		})
+	case errors.Is(err, errInvalidPaymentToken):
+		respondErrorDetails(w, http.StatusForbidden, "Invalid payment token", map[string]interface{}{
+			"code": "INVALID_TOKEN",
+		})
+	case errors.Is(err, errSingleUseReissue), errors.Is(err, errATMIDTaken):
+		respondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errATMNotFound):
+		respondError(w, http.StatusNotFound, err.Error())
+	case errors.As(err, &billErr):
+		respondErrorDetails(w, http.StatusBadRequest, billErr.Message, map[string]interface{}{
+			"code": "INVALID_BILL_DETAILS",
+		})
+	case errors.Is(err, errCardNotPhysical):
+		respondErrorDetails(w, http.StatusForbidden, err.Error(), map[string]interface{}{
+			"code": "CARD_NOT_SUPPORTED",
+		})
+	case errors.Is(err, errNotFound):
+		respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errAccountNotOwned), errors.Is(err, errNotPayer), errors.Is(err, errATMMismatch),
+		errors.Is(err, errNotRequester), errors.Is(err, errNotIssuer):
+		respondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, errMoneyRequestClosed):
+		respondErrorDetails(w, http.StatusConflict, err.Error(), map[string]interface{}{
+			"code": "REQUEST_NOT_PENDING",
+		})
+	case errors.Is(err, errATMReportTooLate):
+		respondErrorDetails(w, http.StatusConflict, err.Error(), map[string]interface{}{
+			"code": "REPORT_WINDOW_CLOSED",
+		})
+	case errors.Is(err, errInvoiceNotPayable):
+		respondErrorDetails(w, http.StatusConflict, err.Error(), map[string]interface{}{
+			"code": "INVOICE_NOT_PAYABLE",
+		})
+	case errors.Is(err, errInvoiceNotCancelable):
+		respondErrorDetails(w, http.StatusConflict, err.Error(), map[string]interface{}{
+			"code": "INVOICE_NOT_CANCELABLE",
+		})
+	case errors.As(err, &overpaymentErr):
+		respondErrorDetails(w, http.StatusConflict, err.Error(), map[string]interface{}{
+			"code":        "INVOICE_OVERPAYMENT",
+			"outstanding": overpaymentErr.Outstanding,
+		})
+	case errors.As(err, &recipientErr):
+		respondErrorDetails(w, recipientErr.HTTPStatus(), recipientErr.Message, map[string]interface{}{
+			"code": recipientErr.Code,
+		})
+	case errors.As(err, &statusErr):
+		respondErrorDetails(w, statusErr.HTTPStatus(), err.Error(), map[string]interface{}{
+			"code":       statusErr.Code(),
+			"account_id": statusErr.AccountID,
+		})
+	case errors.As(err, &cardErr):
+		respondErrorDetails(w, http.StatusForbidden, err.Error(), map[string]interface{}{
+			"code":    cardErr.Code(),
+			"card_id": cardErr.CardID,
+		})
+	case errors.Is(err, errPINNotSet):
+		respondErrorDetails(w, http.StatusForbidden, "PIN is not set for this card", map[string]interface{}{
+			"code": "PIN_NOT_SET",
+		})
+	case errors.As(err, &verifyErr):
+		respondErrorDetails(w, http.StatusForbidden, err.Error(), map[string]interface{}{
+			"code":          verifyErr.Code(),
+			"attempts_left": verifyErr.AttemptsLeft,
+		})
+	case errors.As(err, &refundErr):
+		respondErrorDetails(w, http.StatusConflict, err.Error(), map[string]interface{}{
+			"code":       "REFUND_EXCEEDS_PAYMENT",
+			"refundable": refundErr.Refundable,
+		})
+	case errors.As(err, &feeErr):
+		respondErrorDetails(w, http.StatusConflict, err.Error(), map[string]interface{}{
+			"code":       "FEE_CHANGED",
+			"quoted_fee": feeErr.QuotedFee,
+			"fee":        feeErr.Fee,
+		})
+	case errors.As(err, &limitErr) && limitErr.Category == "sbp":
+		respondErrorDetails(w, http.StatusForbidden, "SBP daily limit exceeded", map[string]interface{}{
+			"code":      "SBP_LIMIT_EXCEEDED",
+			"limit":     limitErr.Limit,
+			"remaining": limitErr.Remaining,
+		})
+	case errors.As(err, &limitErr):
+		respondErrorDetails(w, http.StatusForbidden, "Operation limit exceeded", map[string]interface{}{
+			"code":      "LIMIT_EXCEEDED",
+			"scope":     limitErr.Scope,
+			"owner_id":  limitErr.OwnerID,
+			"category":  limitErr.Category,
+			"period":    limitErr.Period,
+			"limit":     limitErr.Limit,
+			"remaining": limitErr.Remaining,
+		})
+	case errors.As(err, &insufficient):
+		respondErrorDetails(w, http.StatusPaymentRequired, "Insufficient funds", map[string]interface{}{
+			"code":       "INSUFFICIENT_FUNDS",
+			"account_id": insufficient.AccountID,
+			"requested":  insufficient.Requested,
+			"available":  insufficient.Available,
+		})
+	case strings.Contains(err.Error(), "not found"):
+		respondError(w, http.StatusNotFound, err.Error())
+	default:
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to %s: %v", action, err))
+	}
+}
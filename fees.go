@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const defaultCustomerTier = "standard"
+
+var customerTiers = map[string]bool{
+	"standard": true,
+	"premium":  true,
+	"private":  true,
+}
+
+// feeOperations — операции, за которые банк берет комиссию, и проводки, которыми она списывается.
+var feeOperations = map[string]struct {
+	TransactionType string
+	Description     string
+}{
+	"transfer":     {TransactionType: "transfer_fee", Description: "Transfer fee"},
+	"interbank":    {TransactionType: "sbp_fee", Description: "SBP transfer fee"},
+	"card_payment": {TransactionType: "card_payment_fee", Description: "Card payment fee"},
+	"withdrawal":   {TransactionType: "atm_fee", Description: "Cash withdrawal fee"},
+	"fx":           {TransactionType: "fx_fee", Description: "Currency exchange fee"},
+}
+
+func decimalPtr(v decimal.Decimal) *decimal.Decimal {
+	return &v
+}
+
+// defaultFeeRules — тариф банка по умолчанию. Переводы между своими счетами и оплаты картой бесплатны.
+var defaultFeeRules = []CreateFeeRuleRequest{
+	{Operation: "transfer", Percent: decimal.NewFromInt(1), MinFee: decimal.NewFromInt(30), MaxFee: decimalPtr(decimal.NewFromInt(3000)),
+		FreeMonthlyAmount: decimal.NewFromInt(50000)},
+	{Operation: "transfer", Tier: "premium"},
+	{Operation: "transfer", Tier: "private"},
+	{Operation: "interbank", Percent: decimal.NewFromFloat(0.5), MaxFee: decimalPtr(decimal.NewFromInt(1500)),
+		FreeMonthlyAmount: decimal.NewFromInt(100000)},
+	{Operation: "interbank", Tier: "private"},
+	{Operation: "withdrawal", Network: "partner", Fixed: decimal.NewFromInt(50)},
+	{Operation: "withdrawal", Network: "off_us", Percent: decimal.NewFromInt(1), MinFee: decimal.NewFromInt(150)},
+	{Operation: "withdrawal", Tier: "premium", Network: "partner"},
+	{Operation: "withdrawal", Tier: "premium", Network: "off_us", Percent: decimal.NewFromInt(1), MinFee: decimal.NewFromInt(150),
+		FreeMonthlyCount: 5},
+	{Operation: "withdrawal", Tier: "private"},
+	{Operation: "fx", Percent: decimal.NewFromFloat(1.5)},
+	{Operation: "fx", Tier: "premium", Percent: decimal.NewFromFloat(0.75)},
+	{Operation: "fx", Tier: "private"},
+}
+
+func InitFeeRules() {
+	now := time.Now()
+	for _, req := range defaultFeeRules {
+		rule, err := NewFeeRule(req, now)
+		if err != nil {
+			log.Fatalf("Invalid default fee rule for %s: %v", req.Operation, err)
+		}
+		AddFeeRule(rule)
+	}
+	log.Printf("Loaded %d default fee rules", len(defaultFeeRules))
+}
+
+func NewFeeRule(req CreateFeeRuleRequest, now time.Time) (FeeRule, error) {
+	if _, ok := feeOperations[req.Operation]; !ok {
+		return FeeRule{}, fmt.Errorf("unknown operation '%s'", req.Operation)
+	}
+	if req.Tier != "" && !customerTiers[req.Tier] {
+		return FeeRule{}, fmt.Errorf("unknown customer tier '%s'", req.Tier)
+	}
+	if req.Network != "" {
+		if req.Operation != "withdrawal" {
+			return FeeRule{}, fmt.Errorf("network can only be set for withdrawal rules")
+		}
+		if !atmNetworkTypes[req.Network] {
+			return FeeRule{}, fmt.Errorf("unknown ATM network type '%s'", req.Network)
+		}
+	}
+	for name, v := range map[string]decimal.Decimal{"min_amount": req.MinAmount, "percent": req.Percent, "fixed": req.Fixed,
+		"min_fee": req.MinFee, "free_monthly_amount": req.FreeMonthlyAmount} {
+		if v.IsNegative() {
+			return FeeRule{}, fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if req.MaxAmount != nil && !req.MaxAmount.GreaterThan(req.MinAmount) {
+		return FeeRule{}, fmt.Errorf("max_amount must be greater than min_amount")
+	}
+	if req.MaxFee != nil && req.MaxFee.LessThan(req.MinFee) {
+		return FeeRule{}, fmt.Errorf("max_fee must not be less than min_fee")
+	}
+	if req.FreeMonthlyCount < 0 {
+		return FeeRule{}, fmt.Errorf("free_monthly_count must not be negative")
+	}
+	return FeeRule{
+		ID:                GenerateID(),
+		Operation:         req.Operation,
+		Tier:              req.Tier,
+		Network:           req.Network,
+		MinAmount:         req.MinAmount,
+		MaxAmount:         req.MaxAmount,
+		Percent:           req.Percent,
+		Fixed:             req.Fixed,
+		MinFee:            req.MinFee,
+		MaxFee:            req.MaxFee,
+		FreeMonthlyCount:  req.FreeMonthlyCount,
+		FreeMonthlyAmount: req.FreeMonthlyAmount,
+		CreatedAt:         now,
+	}, nil
+}
+
+func (rule FeeRule) matches(operation, tier, network string, amount decimal.Decimal) bool {
+	return rule.Operation == operation &&
+		(rule.Tier == "" || rule.Tier == tier) &&
+		(rule.Network == "" || rule.Network == network) &&
+		!amount.LessThan(rule.MinAmount) &&
+		(rule.MaxAmount == nil || amount.LessThan(*rule.MaxAmount))
+}
+
+func (rule FeeRule) specificity() int {
+	n := 0
+	if rule.Tier != "" {
+		n += 2
+	}
+	if rule.Network != "" {
+		n++
+	}
+	return n
+}
+
+func matchFeeRuleLocked(operation, tier, network string, amount decimal.Decimal) (FeeRule, bool) {
+	var best FeeRule
+	found := false
+	for _, rule := range storage.feeRules {
+		if !rule.matches(operation, tier, network, amount) {
+			continue
+		}
+		if !found || rule.specificity() > best.specificity() ||
+			(rule.specificity() == best.specificity() && rule.CreatedAt.After(best.CreatedAt)) {
+			best, found = rule, true
+		}
+	}
+	return best, found
+}
+
+// feeUsageLocked считает операции клиента за месяц, рассчитанные по правилу, — из них расходуется бесплатный лимит.
+func feeUsageLocked(userID, ruleID string, now time.Time) (count int, volume decimal.Decimal) {
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	volume = decimal.Zero
+	for _, accountID := range storage.accountIndex[userID] {
+		for _, tx := range accountTransactionsLocked(accountID) {
+			if tx.FromAccountID == accountID && tx.FeeRuleID == ruleID && !tx.Timestamp.Before(startOfMonth) {
+				count++
+				volume = volume.Add(tx.Amount)
+			}
+		}
+	}
+	return count, volume
+}
+
+// QuoteFee рассчитывает комиссию за операцию со счета до ее проведения: по уровню клиента,
+// сумме и остатку бесплатных лимитов месяца.
+func QuoteFee(req FeePreviewRequest, now time.Time) (FeeQuote, error) {
+	if _, ok := feeOperations[req.Operation]; !ok {
+		return FeeQuote{}, fmt.Errorf("unknown operation '%s'", req.Operation)
+	}
+	if !req.Amount.IsPositive() {
+		return FeeQuote{}, errNonPositiveAmount
+	}
+	if req.Network != "" && !atmNetworkTypes[req.Network] {
+		return FeeQuote{}, fmt.Errorf("unknown ATM network type '%s'", req.Network)
+	}
+
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	return quoteFeeLocked(req, now)
+}
+
+func quoteFeeLocked(req FeePreviewRequest, now time.Time) (FeeQuote, error) {
+	account, ok := storage.accounts[req.AccountID]
+	if !ok {
+		return FeeQuote{}, fmt.Errorf("account %s not found", req.AccountID)
+	}
+	tier := storage.users[account.UserID].Tier
+	if tier == "" {
+		tier = defaultCustomerTier
+	}
+	quote := FeeQuote{
+		Operation: req.Operation,
+		Tier:      tier,
+		Network:   req.Network,
+		Amount:    req.Amount,
+		Fee:       decimal.Zero,
+		Total:     req.Amount,
+	}
+	if req.Operation == "transfer" {
+		if to, ok := storage.accounts[req.ToAccountID]; ok && to.UserID == account.UserID {
+			return quote, nil
+		}
+	}
+	rule, ok := matchFeeRuleLocked(req.Operation, tier, req.Network, req.Amount)
+	if !ok {
+		return quote, nil
+	}
+	quote.RuleID = rule.ID
+
+	count, volume := feeUsageLocked(account.UserID, rule.ID, now)
+	for _, tx := range req.Pending {
+		if tx.FeeRuleID == rule.ID && storage.accounts[tx.FromAccountID].UserID == account.UserID {
+			count++
+			volume = volume.Add(tx.Amount)
+		}
+	}
+	chargeable := req.Amount
+	if rule.FreeMonthlyCount > 0 {
+		left := rule.FreeMonthlyCount - count
+		if left < 0 {
+			left = 0
+		}
+		quote.FreeOperationsLeft = &left
+		if left > 0 {
+			return quote, nil
+		}
+	}
+	if rule.FreeMonthlyAmount.IsPositive() {
+		free := decimal.Max(rule.FreeMonthlyAmount.Sub(volume), decimal.Zero)
+		quote.FreeAmountLeft = &free
+		chargeable = chargeable.Sub(free)
+		if !chargeable.IsPositive() {
+			return quote, nil
+		}
+	}
+
+	fee := chargeable.Mul(rule.Percent).Div(decimal.NewFromInt(100)).Add(rule.Fixed).RoundBank(2)
+	if fee.LessThan(rule.MinFee) {
+		fee = rule.MinFee
+	}
+	if rule.MaxFee != nil && fee.GreaterThan(*rule.MaxFee) {
+		fee = *rule.MaxFee
+	}
+	quote.Fee = fee
+	quote.Total = req.Amount.Add(fee)
+	return quote, nil
+}
+
+// NewFeeTransaction списывает комиссию отдельной проводкой, связанной с исходной операцией.
+func NewFeeTransaction(tx Transaction, operation string, fee decimal.Decimal) Transaction {
+	return Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   tx.FromAccountID,
+		Amount:          fee,
+		Timestamp:       tx.Timestamp,
+		TransactionType: feeOperations[operation].TransactionType,
+		Description:     fmt.Sprintf("%s (ID: %s)", feeOperations[operation].Description, tx.ID),
+		RelatedTxID:     tx.ID,
+		Channel:         tx.Channel,
+		TerminalID:      tx.TerminalID,
+	}
+}
+
+// ChargeFee рассчитывает комиссию по тарифу и возвращает проводки операции и комиссии,
+// которые нужно провести вместе. pending — проводки, которые будут проведены в той же атомарной операции.
+func ChargeFee(tx Transaction, operation, network string, pending ...Transaction) ([]Transaction, FeeQuote, error) {
+	quote, err := QuoteFee(FeePreviewRequest{
+		AccountID:   tx.FromAccountID,
+		ToAccountID: tx.ToAccountID,
+		Operation:   operation,
+		Amount:      tx.Amount,
+		Network:     network,
+		Pending:     pending,
+	}, tx.Timestamp)
+	if err != nil {
+		return nil, FeeQuote{}, err
+	}
+	return feeTransactions(tx, operation, quote), quote, nil
+}
+
+func feeTransactions(tx Transaction, operation string, quote FeeQuote) []Transaction {
+	tx.FeeRuleID = quote.RuleID
+	txs := []Transaction{tx}
+	if quote.Fee.IsPositive() {
+		txs = append(txs, NewFeeTransaction(tx, operation, quote.Fee))
+	}
+	return txs
+}
+
+// PostWithFee рассчитывает комиссию и проводит операцию вместе с ней под одной блокировкой, чтобы параллельные
+// операции не израсходовали одну и ту же бесплатную квоту месяца. Если задана quotedFee — комиссия, показанная
+// клиенту, — а по тарифу она уже другая, операция не проводится.
+func PostWithFee(tx Transaction, operation, network string, quotedFee *decimal.Decimal) (Transaction, FeeQuote, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	return postWithFeeLocked(tx, operation, network, quotedFee)
+}
+
+func postWithFeeLocked(tx Transaction, operation, network string, quotedFee *decimal.Decimal) (Transaction, FeeQuote, error) {
+	quote, err := quoteFeeLocked(FeePreviewRequest{
+		AccountID:   tx.FromAccountID,
+		ToAccountID: tx.ToAccountID,
+		Operation:   operation,
+		Amount:      tx.Amount,
+		Network:     network,
+	}, tx.Timestamp)
+	if err != nil {
+		return Transaction{}, FeeQuote{}, err
+	}
+	if quotedFee != nil && !quote.Fee.Equal(*quotedFee) {
+		return Transaction{}, FeeQuote{}, &FeeChangedError{QuotedFee: *quotedFee, Fee: quote.Fee}
+	}
+	txs := feeTransactions(tx, operation, quote)
+	if err := applyTransactionsAtomicLocked(txs); err != nil {
+		return Transaction{}, FeeQuote{}, err
+	}
+	return txs[0], quote, nil
+}
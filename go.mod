@@ -3,8 +3,12 @@ module bankapp
 go 1.24.1
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/shopspring/decimal v1.4.0 // indirect
-	golang.org/x/crypto v0.37.0 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
+	github.com/shopspring/decimal v1.4.0
+	golang.org/x/crypto v0.37.0
 )
+
+require github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+
+require go.mozilla.org/pkcs7 v0.0.0-20210826202110-33d05740a352
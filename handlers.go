@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"sort"
@@ -31,6 +34,15 @@ func respondError(w http.ResponseWriter, code int, message string) {
 	respondJSON(w, code, map[string]string{"error": message})
 }
 
+func respondErrorDetails(w http.ResponseWriter, code int, message string, details map[string]interface{}) {
+	log.Printf("HTTP Error %d: %s", code, message)
+	payload := map[string]interface{}{"error": message}
+	for k, v := range details {
+		payload[k] = v
+	}
+	respondJSON(w, code, payload)
+}
+
 func RegisterUserHandler(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -44,6 +56,15 @@ func RegisterUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Phone != "" {
+		phone, err := NormalizePhone(req.Phone)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Phone = phone
+	}
+
 	hashedPassword, err := HashPassword(req.Password)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to hash password")
@@ -54,6 +75,9 @@ func RegisterUserHandler(w http.ResponseWriter, r *http.Request) {
 		ID:           GenerateID(),
 		Username:     req.Username,
 		Email:        req.Email,
+		FullName:     strings.TrimSpace(req.FullName),
+		Phone:        req.Phone,
+		Tier:         defaultCustomerTier,
 		PasswordHash: hashedPassword,
 		CreatedAt:    time.Now(),
 	}
@@ -115,16 +139,40 @@ func CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "UserID is required")
 		return
 	}
+	if req.Type == "" {
+		req.Type = "personal"
+	}
+	if req.Currency == "" {
+		req.Currency = "RUB"
+	}
 
 	account := Account{
 		ID:        GenerateID(),
 		UserID:    req.UserID,
-		Number:    GenerateAccountNumber(),
+		Type:      req.Type,
+		Currency:  req.Currency,
 		Balance:   decimal.Zero,
+		Status:    "active",
 		CreatedAt: time.Now(),
 	}
 
-	if err := AddAccount(account); err != nil {
+	const maxNumberAttempts = 10
+	for attempt := 1; ; attempt++ {
+		number, err := GenerateAccountNumber(req.Type, req.Currency)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		account.Number = number
+
+		err = AddAccount(account)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, errAccountNumberTaken) && attempt < maxNumberAttempts {
+			log.Printf("Account number collision on %s, regenerating", number)
+			continue
+		}
 		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create account: %v", err))
 		return
 	}
@@ -133,345 +181,2516 @@ func CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusCreated, account)
 }
 
-func GetUserAccountsHandler(w http.ResponseWriter, r *http.Request) {
+func SetOverdraftHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	userID := vars["userId"]
-
-	accounts := GetUserAccounts(userID)
-	log.Printf("Fetched %d accounts for user %s", len(accounts), userID)
-	respondJSON(w, http.StatusOK, accounts)
-}
+	accountID := vars["accountId"]
 
-func GenerateCardHandler(w http.ResponseWriter, r *http.Request) {
-	var req GenerateCardRequest
+	var req SetOverdraftRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
 
-	if _, ok := GetAccount(req.AccountID); !ok {
-		respondError(w, http.StatusBadRequest, fmt.Sprintf("Account %s not found", req.AccountID))
+	if req.Limit.IsNegative() || req.Limit.GreaterThan(overdraftConfig.MaxLimit) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Overdraft limit must be between 0 and %s", overdraftConfig.MaxLimit.String()))
 		return
 	}
-
-	month, year := GenerateExpiryDate()
-	card := Card{
-		ID:          GenerateID(),
-		AccountID:   req.AccountID,
-		Number:      GenerateCardNumber(),
-		ExpiryMonth: month,
-		ExpiryYear:  year,
-		CVV:         GenerateCVV(),
-		CreatedAt:   time.Now(),
+	rate := overdraftConfig.DefaultRate
+	if req.InterestRate != nil {
+		if req.InterestRate.IsNegative() {
+			respondError(w, http.StatusBadRequest, "Overdraft interest rate must not be negative")
+			return
+		}
+		rate = *req.InterestRate
 	}
 
-	if err := AddCard(card); err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to generate card: %v", err))
+	account, err := SetAccountOverdraft(accountID, req.Limit, rate)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			respondError(w, http.StatusNotFound, err.Error())
+		} else {
+			respondError(w, http.StatusConflict, err.Error())
+		}
 		return
 	}
 
-	log.Printf("Card generated for account %s", card.AccountID)
-	card.CVV = "***"
-	respondJSON(w, http.StatusCreated, card)
+	log.Printf("Overdraft for account %s set to %s at %s%%", account.ID, account.OverdraftLimit.String(), account.OverdraftRate.String())
+	respondJSON(w, http.StatusOK, account)
 }
 
-func GetAccountCardsHandler(w http.ResponseWriter, r *http.Request) {
+func FreezeAccountHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	accountID := vars["accountId"]
 
-	if _, ok := GetAccount(accountID); !ok {
-		respondError(w, http.StatusNotFound, fmt.Sprintf("Account %s not found", accountID))
-		return
-	}
-
-	cards := GetAccountCards(accountID)
-	for i := range cards {
-		cards[i].CVV = "***"
-	}
-	log.Printf("Fetched %d cards for account %s", len(cards), accountID)
-	respondJSON(w, http.StatusOK, cards)
-}
-
-func PayWithCardHandler(w http.ResponseWriter, r *http.Request) {
-	var req PaymentRequest
+	var req FreezeAccountRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
 
-	if req.Amount.LessThanOrEqual(decimal.Zero) {
-		respondError(w, http.StatusBadRequest, "Payment amount must be positive")
+	if strings.TrimSpace(req.Reason) == "" {
+		respondError(w, http.StatusBadRequest, "Freeze reason is required")
 		return
 	}
 
-	card, ok := GetCardByNumber(req.CardNumber)
-	if !ok {
-		respondError(w, http.StatusNotFound, "Card not found")
+	account, err := SetAccountStatus(accountID, "frozen", req.Reason, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "freeze account")
 		return
 	}
 
-	now := time.Now()
-	expiry := time.Date(card.ExpiryYear, time.Month(card.ExpiryMonth)+1, 0, 23, 59, 59, 0, time.UTC) // Последний день месяца
-	if now.After(expiry) {
-		respondError(w, http.StatusBadRequest, "Card expired")
+	log.Printf("Account %s frozen: %s", account.ID, req.Reason)
+	respondJSON(w, http.StatusOK, account)
+}
+
+func UnfreezeAccountHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID := vars["accountId"]
+
+	var req FreezeAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	defer r.Body.Close()
 
-	account, ok := GetAccount(card.AccountID)
+	account, ok := GetAccount(accountID)
 	if !ok {
-		respondError(w, http.StatusInternalServerError, "Associated account not found")
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Account %s not found", accountID))
 		return
 	}
-
-	if account.Balance.LessThan(req.Amount) {
-		respondError(w, http.StatusPaymentRequired, "Insufficient funds")
+	if account.Status != "frozen" {
+		respondError(w, http.StatusConflict, fmt.Sprintf("Account %s is not frozen", accountID))
 		return
 	}
 
-	err := UpdateAccountBalance(account.ID, req.Amount.Neg())
+	account, err := SetAccountStatus(accountID, "active", req.Reason, time.Now())
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to process payment: %v", err))
+		respondDebitError(w, err, "unfreeze account")
 		return
 	}
 
-	tx := Transaction{
-		ID:              GenerateID(),
-		FromAccountID:   account.ID,
-		ToAccountID:     "",
-		Amount:          req.Amount,
-		Timestamp:       time.Now(),
-		TransactionType: "payment",
-		Description:     fmt.Sprintf("Payment to %s", req.Merchant),
-	}
-	AddTransaction(tx)
-
-	log.Printf("Payment of %s processed from account %s (card %s) to %s", req.Amount.String(), account.ID, card.Number[:4]+"...", req.Merchant)
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Payment successful"})
+	log.Printf("Account %s unfrozen", account.ID)
+	respondJSON(w, http.StatusOK, account)
 }
 
-func TransferHandler(w http.ResponseWriter, r *http.Request) {
-	var req TransferRequest
+func CloseAccountHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID := vars["accountId"]
+
+	var req CloseAccountRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
 
-	if req.FromAccountID == req.ToAccountID {
-		respondError(w, http.StatusBadRequest, "Cannot transfer to the same account")
+	if req.UserID == "" {
+		respondError(w, http.StatusBadRequest, "UserID is required")
 		return
 	}
-	if req.Amount.LessThanOrEqual(decimal.Zero) {
-		respondError(w, http.StatusBadRequest, "Transfer amount must be positive")
+
+	account, sweep, err := CloseAccount(accountID, req.UserID, req.TransferToAccountID, time.Now())
+	if err != nil {
+		var statusErr *AccountStatusError
+		var insufficient *InsufficientFundsError
+		switch {
+		case errors.As(err, &statusErr), errors.As(err, &insufficient), strings.Contains(err.Error(), "not found"):
+			respondDebitError(w, err, "close account")
+		case strings.Contains(err.Error(), "does not belong"):
+			respondError(w, http.StatusForbidden, err.Error())
+		default:
+			respondError(w, http.StatusConflict, err.Error())
+		}
 		return
 	}
 
-	storage.mu.Lock()
-	defer storage.mu.Unlock()
+	log.Printf("Account %s closed by user %s", account.ID, req.UserID)
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"account":          account,
+		"balance_transfer": sweep,
+	})
+}
 
-	fromAccount, okFrom := storage.accounts[req.FromAccountID]
-	toAccount, okTo := storage.accounts[req.ToAccountID]
+func SetDefaultAccountHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
 
-	if !okFrom {
-		respondError(w, http.StatusNotFound, fmt.Sprintf("Source account %s not found", req.FromAccountID))
-		return
-	}
-	if !okTo {
-		respondError(w, http.StatusNotFound, fmt.Sprintf("Destination account %s not found", req.ToAccountID))
+	var req SetDefaultAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	defer r.Body.Close()
 
-	if fromAccount.Balance.LessThan(req.Amount) {
-		respondError(w, http.StatusPaymentRequired, "Insufficient funds in source account")
+	user, err := SetUserDefaultAccount(userID, req.AccountID)
+	if err != nil {
+		if strings.Contains(err.Error(), "does not belong") {
+			respondError(w, http.StatusForbidden, err.Error())
+		} else {
+			respondDebitError(w, err, "set default account")
+		}
 		return
 	}
 
-	fromAccount.Balance = fromAccount.Balance.Sub(req.Amount)
-	toAccount.Balance = toAccount.Balance.Add(req.Amount)
-
-	storage.accounts[req.FromAccountID] = fromAccount
-	storage.accounts[req.ToAccountID] = toAccount
-
-	tx := Transaction{
-		ID:              GenerateID(),
-		FromAccountID:   req.FromAccountID,
-		ToAccountID:     req.ToAccountID,
-		Amount:          req.Amount,
-		Timestamp:       time.Now(),
-		TransactionType: "transfer",
-		Description:     fmt.Sprintf("Transfer from %s to %s", fromAccount.Number, toAccount.Number),
-	}
-	storage.transactions = append(storage.transactions, tx)
-
-	log.Printf("Transfer of %s from %s to %s successful", req.Amount.String(), req.FromAccountID, req.ToAccountID)
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Transfer successful"})
+	log.Printf("Default account for user %s set to %s", userID, req.AccountID)
+	user.PasswordHash = ""
+	respondJSON(w, http.StatusOK, user)
 }
 
-func DepositHandler(w http.ResponseWriter, r *http.Request) {
-	var req DepositRequest
+func SetUserTierHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+
+	var req SetUserTierRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
 
-	if req.Amount.LessThanOrEqual(decimal.Zero) {
-		respondError(w, http.StatusBadRequest, "Deposit amount must be positive")
+	if !customerTiers[req.Tier] {
+		respondError(w, http.StatusBadRequest, "Tier must be one of: standard, premium, private")
 		return
 	}
-
-	err := UpdateAccountBalance(req.ToAccountID, req.Amount)
+	user, err := SetUserTier(userID, req.Tier)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			respondError(w, http.StatusNotFound, err.Error())
-		} else {
-			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to process deposit: %v", err))
-		}
+		respondError(w, http.StatusNotFound, err.Error())
 		return
 	}
 
-	account, _ := GetAccount(req.ToAccountID)
-	tx := Transaction{
-		ID:              GenerateID(),
-		FromAccountID:   "",
-		ToAccountID:     req.ToAccountID,
-		Amount:          req.Amount,
-		Timestamp:       time.Now(),
-		TransactionType: "deposit",
-		Description:     fmt.Sprintf("Deposit to account %s", account.Number),
-	}
-	AddTransaction(tx)
+	log.Printf("Tier of user %s set to %s", userID, req.Tier)
+	user.PasswordHash = ""
+	respondJSON(w, http.StatusOK, user)
+}
 
-	log.Printf("Deposit of %s to account %s successful", req.Amount.String(), req.ToAccountID)
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Deposit successful"})
+func GetUserAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+
+	accounts := GetUserAccounts(userID)
+	log.Printf("Fetched %d accounts for user %s", len(accounts), userID)
+	respondJSON(w, http.StatusOK, accounts)
 }
 
-func ApplyLoanHandler(w http.ResponseWriter, r *http.Request) {
-	var req ApplyLoanRequest
+func GenerateCardHandler(w http.ResponseWriter, r *http.Request) {
+	var req GenerateCardRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
 
-	if req.Amount.LessThanOrEqual(decimal.Zero) || req.TermMonths <= 0 {
-		respondError(w, http.StatusBadRequest, "Loan amount and term must be positive")
+	if _, ok := GetAccount(req.AccountID); !ok {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Account %s not found", req.AccountID))
 		return
 	}
-
-	storage.mu.RLock()
-	_, userExists := storage.users[req.UserID]
-	_, accountExists := storage.accounts[req.AccountID]
-	storage.mu.RUnlock()
-
-	if !userExists {
-		respondError(w, http.StatusNotFound, fmt.Sprintf("User %s not found", req.UserID))
+	if err := NormalizeCardRequest(&req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if !accountExists {
-		respondError(w, http.StatusNotFound, fmt.Sprintf("Account %s not found", req.AccountID))
+
+	card, cvv, err := IssueCard(req)
+	if err != nil {
+		respondDebitError(w, err, "generate card")
 		return
 	}
 
-	baseRate, err := GetCBRKeyRate()
-	if err != nil {
-		log.Printf("Warning: Failed to get key rate, using default 10%%: %v", err)
-		baseRate = decimal.NewFromInt(10)
+	log.Printf("%s card generated for account %s", card.Type, card.AccountID)
+	respondJSON(w, http.StatusCreated, IssuedCard{Card: card, CVV: cvv})
+}
+
+func GetAccountCardsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID := vars["accountId"]
+
+	if _, ok := GetAccount(accountID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Account %s not found", accountID))
+		return
 	}
 
-	interestRate := baseRate.Add(decimal.NewFromInt(5))
+	cards := GetAccountCards(accountID)
+	log.Printf("Fetched %d cards for account %s", len(cards), accountID)
+	respondJSON(w, http.StatusOK, cards)
+}
 
-	monthlyPayment := CalculateMonthlyPayment(req.Amount, interestRate, req.TermMonths)
-	startDate := time.Now()
-	schedule := GeneratePaymentSchedule(req.Amount, interestRate, req.TermMonths, startDate, monthlyPayment)
+// changeCardStatus меняет статус карты. При ownerOnly действие подтверждает владелец паролем или сотрудник банка.
+func changeCardStatus(w http.ResponseWriter, r *http.Request, from []string, to string, ownerOnly bool) {
+	vars := mux.Vars(r)
+	cardID := vars["cardId"]
 
-	loan := Loan{
-		ID:              GenerateID(),
-		UserID:          req.UserID,
-		AccountID:       req.AccountID,
-		Amount:          req.Amount,
-		InterestRate:    interestRate,
-		TermMonths:      req.TermMonths,
-		StartDate:       startDate,
-		PaymentSchedule: schedule,
-		RemainingAmount: req.Amount,
+	var req CardStatusRequest
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
 	}
+	defer r.Body.Close()
 
-	if err := AddLoan(loan); err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save loan: %v", err))
-		return
+	if ownerOnly && !isOperatorRequest(r) {
+		card, ok := GetCard(cardID)
+		if !ok {
+			respondError(w, http.StatusNotFound, fmt.Sprintf("Card %s not found", cardID))
+			return
+		}
+		if !checkCardOwner(card, req.UserID, req.Password) {
+			respondError(w, http.StatusUnauthorized, "Invalid user or password")
+			return
+		}
 	}
 
-	err = UpdateAccountBalance(req.AccountID, req.Amount)
+	now := time.Now()
+	card, err := UpdateCard(cardID, func(card *Card) error {
+		allowed := false
+		for _, status := range from {
+			if card.Status == status {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return &CardStatusError{CardID: card.ID, Status: card.Status}
+		}
+		// счетчики неверных CVV и PIN сбрасывает только снятие блокировки безопасности
+		if card.Status == "security_blocked" && to == "active" {
+			card.FailedCVVChecks = 0
+			card.FailedPINAttempts = 0
+		}
+		card.Status = to
+		card.StatusReason = req.Reason
+		card.StatusChangedAt = &now
+		return nil
+	})
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to disburse loan funds: %v", err))
+		var cardErr *CardStatusError
+		if errors.As(err, &cardErr) {
+			respondErrorDetails(w, http.StatusConflict, fmt.Sprintf("Cannot change card status: %v", err), map[string]interface{}{
+				"code": cardErr.Code(),
+			})
+		} else {
+			respondDebitError(w, err, "change card status")
+		}
 		return
 	}
 
-	tx := Transaction{
-		ID:              GenerateID(),
-		FromAccountID:   "", //
-		ToAccountID:     req.AccountID,
-		Amount:          req.Amount,
-		Timestamp:       time.Now(),
-		TransactionType: "loan_disbursement",
-		Description:     fmt.Sprintf("Loan disbursement (ID: %s)", loan.ID),
-	}
-	AddTransaction(tx)
-
-	log.Printf("Loan %s approved for user %s, amount %s, rate %s%%, term %d months. Funds disbursed to account %s.",
-		loan.ID, req.UserID, req.Amount.String(), interestRate.String(), req.TermMonths, req.AccountID)
+	log.Printf("Card %s is now %s", card.ID, card.Status)
+	respondJSON(w, http.StatusOK, card)
+}
 
-	respondJSON(w, http.StatusCreated, loan)
+func BlockCardHandler(w http.ResponseWriter, r *http.Request) {
+	changeCardStatus(w, r, []string{"active"}, "blocked", false)
 }
 
-func GetLoanScheduleHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	loanID := vars["loanId"]
+func UnblockCardHandler(w http.ResponseWriter, r *http.Request) {
+	changeCardStatus(w, r, []string{"blocked"}, "active", true)
+}
 
-	loan, ok := GetLoan(loanID)
-	if !ok {
-		respondError(w, http.StatusNotFound, fmt.Sprintf("Loan %s not found", loanID))
-		return
-	}
+// SecurityUnblockCardHandler снимает блокировку после неверных CVV или PIN и сбрасывает счетчики попыток.
+func SecurityUnblockCardHandler(w http.ResponseWriter, r *http.Request) {
+	changeCardStatus(w, r, []string{"security_blocked"}, "active", false)
+}
 
-	log.Printf("Fetched payment schedule for loan %s", loanID)
-	respondJSON(w, http.StatusOK, loan.PaymentSchedule)
+func ReportCardLostHandler(w http.ResponseWriter, r *http.Request) {
+	changeCardStatus(w, r, []string{"active", "blocked", "security_blocked"}, "permanently_blocked", false)
 }
 
-func GetTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+// ReissueCardHandler перевыпускает карту по паролю владельца. Карту с блокировкой безопасности
+// перевыпускает только сотрудник банка: иначе перевыпуск обходил бы проверку при снятии такой блокировки.
+func ReissueCardHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	accountID := vars["accountId"]
+	cardID := vars["cardId"]
 
-	if _, ok := GetAccount(accountID); !ok {
-		respondError(w, http.StatusNotFound, fmt.Sprintf("Account %s not found", accountID))
-		return
+	var req CardOwnerAuth
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
 	}
+	defer r.Body.Close()
 
-	transactions := GetAccountTransactions(accountID)
+	old, ok := GetCard(cardID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Card %s not found", cardID))
+		return
+	}
+	byOperator := isOperatorRequest(r)
+	if !byOperator && !checkCardOwner(old, req.UserID, req.Password) {
+		respondError(w, http.StatusUnauthorized, "Invalid user or password")
+		return
+	}
 
-	sort.Slice(transactions, func(i, j int) bool {
-		return transactions[i].Timestamp.After(transactions[j].Timestamp)
-	})
+	old, card, cvv, err := ReissueCard(old, byOperator, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "reissue card")
+		return
+	}
 
-	log.Printf("Fetched %d transactions for account %s", len(transactions), accountID)
-	respondJSON(w, http.StatusOK, transactions)
+	log.Printf("Card %s reissued as %s for account %s", old.ID, card.ID, card.AccountID)
+	respondJSON(w, http.StatusCreated, IssuedCard{Card: card, CVV: cvv})
 }
 
-func GetFinancialSummaryHandler(w http.ResponseWriter, r *http.Request) {
+// SetCardPINHandler устанавливает PIN. Первичная установка и сброс забытого PIN требуют пароль
+// владельца, смена — текущий PIN (неверный PIN засчитывается как неудачная попытка).
+func SetCardPINHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	userID := vars["userId"]
+	cardID := vars["cardId"]
 
-	accounts := GetUserAccounts(userID)
-	loans := GetUserLoans(userID)
+	var req SetPINRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := ValidatePIN(req.PIN); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	card, ok := GetCard(cardID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Card %s not found", cardID))
+		return
+	}
+	if !card.IsLive(time.Now()) {
+		respondDebitError(w, &CardStatusError{CardID: card.ID, Status: card.Status}, "set PIN")
+		return
+	}
+
+	switch {
+	case req.Password != "":
+		account, ok := GetAccount(card.AccountID)
+		if !ok {
+			respondError(w, http.StatusInternalServerError, "Associated account not found")
+			return
+		}
+		user, ok := GetUser(account.UserID)
+		if !ok || !CheckPasswordHash(req.Password, user.PasswordHash) {
+			respondError(w, http.StatusUnauthorized, "Invalid password")
+			return
+		}
+	case req.CurrentPIN != "":
+		if err := VerifyCardPIN(card, req.CurrentPIN); err != nil {
+			respondDebitError(w, err, "change PIN")
+			return
+		}
+	default:
+		respondError(w, http.StatusBadRequest, "Password or current PIN is required")
+		return
+	}
+
+	card, err := UpdateCard(card.ID, func(c *Card) error {
+		c.PINHash = HashPIN(c.ID, req.PIN)
+		c.PINSet = true
+		c.FailedPINAttempts = 0
+		return nil
+	})
+	if err != nil {
+		respondDebitError(w, err, "set PIN")
+		return
+	}
+
+	log.Printf("PIN set for card %s", card.ID)
+	respondJSON(w, http.StatusOK, card)
+}
+
+// RequestCardRevealHandler проверяет пароль владельца и отправляет на его email одноразовый код для показа полного номера карты.
+func RequestCardRevealHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID := vars["cardId"]
+
+	var req CardRevealRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	card, ok := GetCard(cardID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Card %s not found", cardID))
+		return
+	}
+	account, ok := GetAccount(card.AccountID)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, "Associated account not found")
+		return
+	}
+	user, ok := GetUser(account.UserID)
+	if !ok || !CheckPasswordHash(req.Password, user.PasswordHash) {
+		log.Printf("Failed card reveal authentication for card %s", card.ID)
+		respondError(w, http.StatusUnauthorized, "Invalid password")
+		return
+	}
+
+	code := GenerateRevealCode()
+	codeHash, err := HashPassword(code)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create confirmation code")
+		return
+	}
+	now := time.Now()
+	challenge := CardRevealChallenge{
+		ID:        GenerateID(),
+		CardID:    card.ID,
+		CodeHash:  codeHash,
+		ExpiresAt: now.Add(cardSecurityConfig.RevealTTL),
+		CreatedAt: now,
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nYour code to view the number of card %s: %s\nThe code is valid for %d minutes. Do not share it with anyone.",
+		user.Username, card.MaskedNumber, code, int(cardSecurityConfig.RevealTTL.Minutes()))
+	if err := SendEmailNotification(user.Email, "Card number confirmation code", body); err != nil {
+		respondError(w, http.StatusBadGateway, "Failed to send confirmation code")
+		return
+	}
+	AddCardRevealChallenge(challenge)
+
+	respondJSON(w, http.StatusAccepted, challenge)
+}
+
+func ConfirmCardRevealHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID := vars["cardId"]
+
+	var req CardRevealConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	_, err := TakeCardRevealChallenge(req.ChallengeID, cardID, time.Now(), cardSecurityConfig.MaxRevealAttempts,
+		func(c CardRevealChallenge) bool { return CheckPasswordHash(req.Code, c.CodeHash) })
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidRevealCode):
+			respondError(w, http.StatusUnauthorized, "Invalid confirmation code")
+		case strings.Contains(err.Error(), "expired"):
+			respondError(w, http.StatusGone, err.Error())
+		default:
+			respondDebitError(w, err, "reveal card number")
+		}
+		return
+	}
+
+	card, ok := GetCard(cardID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Card %s not found", cardID))
+		return
+	}
+	number, err := RevealCardNumber(card)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to reveal card number")
+		return
+	}
+
+	log.Printf("Full number of card %s revealed to its owner", card.ID)
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"card_id":      card.ID,
+		"number":       number,
+		"expiry_month": card.ExpiryMonth,
+		"expiry_year":  card.ExpiryYear,
+	})
+}
+
+func CreateCardTokenHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID := vars["cardId"]
+
+	var req CreateCardTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if _, err := ResolvePaymentMerchant(req.MerchantID); err != nil {
+		respondDebitError(w, err, "create card token")
+		return
+	}
+	card, ok := GetCard(cardID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Card %s not found", cardID))
+		return
+	}
+	if err := CheckCardUsable(card, time.Now()); err != nil {
+		respondDebitError(w, err, "create card token")
+		return
+	}
+
+	token := NewCardToken(card.ID, req.MerchantID)
+	if err := AddCardToken(token); err != nil {
+		respondDebitError(w, err, "create card token")
+		return
+	}
+
+	log.Printf("Payment token issued for card %s and merchant %s", card.ID, req.MerchantID)
+	respondJSON(w, http.StatusCreated, token)
+}
+
+func GetCardTokensHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cardID := vars["cardId"]
+
+	if _, ok := GetCard(cardID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Card %s not found", cardID))
+		return
+	}
+	respondJSON(w, http.StatusOK, GetCardTokens(cardID))
+}
+
+func RevokeCardTokenHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	token, err := RevokeCardToken(vars["cardId"], vars["token"], time.Now())
+	if err != nil {
+		respondDebitError(w, err, "revoke card token")
+		return
+	}
+
+	log.Printf("Payment token for card %s and merchant %s revoked", token.CardID, token.MerchantID)
+	respondJSON(w, http.StatusOK, token)
+}
+
+func RotateCardKeyHandler(w http.ResponseWriter, r *http.Request) {
+	version, count, err := RotateCardKey()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to rotate card key: %v", err))
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"key_version": version,
+		"reencrypted": count,
+	})
+}
+
+func PayWithCardHandler(w http.ResponseWriter, r *http.Request) {
+	var req PaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	tx, err := AuthorizeCardPayment(req, true)
+	if err != nil {
+		respondDebitError(w, err, "process payment")
+		return
+	}
+
+	log.Printf("Payment of %s processed from account %s (card %s) to %s", req.Amount.String(), tx.FromAccountID, tx.CardID, tx.MerchantID)
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Payment successful", "transaction_id": tx.ID})
+}
+
+func GetFeeRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules := GetFeeRules()
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Operation != rules[j].Operation {
+			return rules[i].Operation < rules[j].Operation
+		}
+		return rules[i].CreatedAt.Before(rules[j].CreatedAt)
+	})
+	respondJSON(w, http.StatusOK, rules)
+}
+
+func CreateFeeRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateFeeRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	rule, err := NewFeeRule(req, time.Now())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	AddFeeRule(rule)
+
+	log.Printf("Fee rule %s added for %s", rule.ID, rule.Operation)
+	respondJSON(w, http.StatusCreated, rule)
+}
+
+func DeleteFeeRuleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ruleID := vars["ruleId"]
+
+	if err := DeleteFeeRule(ruleID); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	log.Printf("Fee rule %s deleted", ruleID)
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Fee rule deleted"})
+}
+
+// PreviewFeeHandler показывает комиссию, которая будет списана за операцию, до ее проведения.
+func PreviewFeeHandler(w http.ResponseWriter, r *http.Request) {
+	var req FeePreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	quote, err := QuoteFee(req, time.Now())
+	if err != nil {
+		respondRequestError(w, err, "preview fee")
+		return
+	}
+	respondJSON(w, http.StatusOK, quote)
+}
+
+func GetCashbackRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules := GetCashbackRules()
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].specificity() != rules[j].specificity() {
+			return rules[i].specificity() < rules[j].specificity()
+		}
+		return rules[i].CreatedAt.Before(rules[j].CreatedAt)
+	})
+	respondJSON(w, http.StatusOK, rules)
+}
+
+func CreateCashbackRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateCashbackRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	rule, err := NewCashbackRule(req, time.Now())
+	if err != nil {
+		if errors.Is(err, errMerchantNotFound) {
+			respondDebitError(w, err, "create cashback rule")
+		} else {
+			respondError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	AddCashbackRule(rule)
+
+	log.Printf("Cashback rule %s added: %s%%", rule.ID, rule.Percent.String())
+	respondJSON(w, http.StatusCreated, rule)
+}
+
+func DeleteCashbackRuleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ruleID := vars["ruleId"]
+
+	if err := DeleteCashbackRule(ruleID); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	log.Printf("Cashback rule %s deleted", ruleID)
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Cashback rule deleted"})
+}
+
+func GetRewardsBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+
+	if _, ok := GetUser(userID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("User %s not found", userID))
+		return
+	}
+	respondJSON(w, http.StatusOK, GetRewardsBalance(userID, time.Now()))
+}
+
+// GetUserCashbackHandler показывает кешбэк по каждой покупке; ?status=pending|paid|cancelled фильтрует список.
+func GetUserCashbackHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+
+	if _, ok := GetUser(userID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("User %s not found", userID))
+		return
+	}
+	status := r.URL.Query().Get("status")
+	rewards := make([]CashbackReward, 0)
+	for _, reward := range GetUserCashbackRewards(userID) {
+		if status == "" || reward.Status == status {
+			rewards = append(rewards, reward)
+		}
+	}
+	sort.Slice(rewards, func(i, j int) bool {
+		return rewards[i].CreatedAt.After(rewards[j].CreatedAt)
+	})
+	respondJSON(w, http.StatusOK, rewards)
+}
+
+// PayoutCashbackHandler досрочно зачисляет кешбэк; без периода — за все завершенные месяцы.
+func PayoutCashbackHandler(w http.ResponseWriter, r *http.Request) {
+	var req CashbackPayoutRequest
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	now := time.Now()
+	if req.Period == "" {
+		req.Period = previousCashbackPeriod(now)
+	}
+	if err := ValidateCashbackPeriod(req.Period); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Period > CashbackPeriod(now) {
+		respondError(w, http.StatusBadRequest, "Cannot pay out cashback for a future period")
+		return
+	}
+
+	payouts := PayoutCashback(req.Period, now)
+	total := decimal.Zero
+	for _, tx := range payouts {
+		total = total.Add(tx.Amount)
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"period":       req.Period,
+		"transactions": payouts,
+		"total":        total,
+	})
+}
+
+func CreateMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateMerchantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	merchant, key, err := NewMerchant(req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	merchant, err = RegisterMerchant(merchant)
+	if err != nil {
+		respondDebitError(w, err, "register merchant")
+		return
+	}
+
+	log.Printf("Merchant %s registered with MCC %s", merchant.ID, merchant.MCC)
+	respondJSON(w, http.StatusCreated, RegisteredMerchant{Merchant: merchant, APIKey: key, CallbackSecret: merchant.CallbackSecret})
+}
+
+func GetMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	merchantID := vars["merchantId"]
+
+	merchant, ok := GetMerchant(merchantID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Merchant %s not found", merchantID))
+		return
+	}
+	respondJSON(w, http.StatusOK, merchant)
+}
+
+func changeMerchantStatus(w http.ResponseWriter, r *http.Request, status string) {
+	vars := mux.Vars(r)
+	merchantID := vars["merchantId"]
+
+	merchant, err := UpdateMerchant(merchantID, func(m *Merchant) error {
+		m.Status = status
+		return nil
+	})
+	if err != nil {
+		respondDebitError(w, err, "change merchant status")
+		return
+	}
+
+	log.Printf("Merchant %s is now %s", merchant.ID, merchant.Status)
+	respondJSON(w, http.StatusOK, merchant)
+}
+
+func SuspendMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	changeMerchantStatus(w, r, "suspended")
+}
+
+func ActivateMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	changeMerchantStatus(w, r, "active")
+}
+
+func GetMerchantPositionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	position, err := GetMerchantPosition(vars["merchantId"], time.Now())
+	if err != nil {
+		respondDebitError(w, err, "get merchant position")
+		return
+	}
+	respondJSON(w, http.StatusOK, position)
+}
+
+func RefundCardPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	merchantID := vars["merchantId"]
+
+	var req MerchantRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		respondError(w, http.StatusBadRequest, "Refund amount must be positive")
+		return
+	}
+
+	tx, err := RefundCardPayment(merchantID, req.TransactionID, req.Amount, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "refund payment")
+		return
+	}
+
+	log.Printf("Merchant %s refunded %s of payment %s", merchantID, tx.Amount.String(), req.TransactionID)
+	respondJSON(w, http.StatusCreated, tx)
+}
+
+func SettleMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	merchantID := vars["merchantId"]
+
+	settlement, settled, err := SettleMerchant(merchantID, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "settle merchant")
+		return
+	}
+	if !settled {
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Nothing to settle"})
+		return
+	}
+	respondJSON(w, http.StatusCreated, settlement)
+}
+
+func GetMerchantSettlementsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	merchantID := vars["merchantId"]
+
+	if _, ok := GetMerchant(merchantID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Merchant %s not found", merchantID))
+		return
+	}
+	respondJSON(w, http.StatusOK, GetMerchantSettlements(merchantID))
+}
+
+func GetMerchantSettlementHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	settlementID := vars["settlementId"]
+
+	settlement, ok := GetMerchantSettlement(settlementID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Settlement %s not found", settlementID))
+		return
+	}
+	respondJSON(w, http.StatusOK, settlement)
+}
+
+func CreateQRPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req CreateQRPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	merchant, err := ResolvePaymentMerchant(vars["merchantId"])
+	if err != nil {
+		respondDebitError(w, err, "create QR payment")
+		return
+	}
+	qr, err := NewQRPayment(merchant, req, time.Now())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := AddQRPayment(qr); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create QR payment: %v", err))
+		return
+	}
+
+	log.Printf("Merchant %s created %s QR payment %s", merchant.ID, qr.Type, qr.ID)
+	respondJSON(w, http.StatusCreated, qr)
+}
+
+func GetMerchantQRPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	merchantID := vars["merchantId"]
+
+	if _, ok := GetMerchant(merchantID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Merchant %s not found", merchantID))
+		return
+	}
+	respondJSON(w, http.StatusOK, GetMerchantQRPayments(merchantID))
+}
+
+func GetQRPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	qrID := vars["qrId"]
+
+	qr, ok := GetQRPayment(qrID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("QR payment %s not found", qrID))
+		return
+	}
+	respondJSON(w, http.StatusOK, qr)
+}
+
+func GetQRPaymentImageHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	qrID := vars["qrId"]
+
+	qr, ok := GetQRPayment(qrID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("QR payment %s not found", qrID))
+		return
+	}
+	image, err := RenderQRPNG(qr.Payload)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to render QR code: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	w.Write(image)
+}
+
+func CancelQRPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	qr, err := UpdateQRPayment(vars["qrId"], func(q *QRPayment) error {
+		if q.MerchantID != vars["merchantId"] {
+			return fmt.Errorf("QR payment %s %w", q.ID, errNotFound) // чужой QR не отличается от несуществующего
+		}
+		if q.Status != "active" {
+			return errQRPaymentNotActive
+		}
+		q.Status = "cancelled"
+		return nil
+	})
+	if err != nil {
+		respondDebitError(w, err, "cancel QR payment")
+		return
+	}
+
+	log.Printf("QR payment %s cancelled", qr.ID)
+	respondJSON(w, http.StatusOK, qr)
+}
+
+// PaymentLinkHandler показывает покупателю, что и кому он оплачивает по ссылке.
+func PaymentLinkHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	qrID := vars["qrId"]
+
+	qr, ok := GetQRPayment(qrID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Payment link %s not found", qrID))
+		return
+	}
+	merchant, _ := GetMerchant(qr.MerchantID)
+
+	info := map[string]interface{}{
+		"merchant_name": merchant.Name,
+		"type":          qr.Type,
+		"status":        qr.Status,
+		"payload":       qr.Payload,
+	}
+	if qr.Amount != nil {
+		info["amount"] = qr.Amount
+	}
+	if qr.Purpose != "" {
+		info["purpose"] = qr.Purpose
+	}
+	if qr.ExpiresAt != nil {
+		info["expires_at"] = qr.ExpiresAt
+	}
+	respondJSON(w, http.StatusOK, info)
+}
+
+func PayQRHandler(w http.ResponseWriter, r *http.Request) {
+	var req PayQRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Payload == "" || req.FromAccountID == "" {
+		respondError(w, http.StatusBadRequest, "Payload and from_account_id are required")
+		return
+	}
+
+	qr, tx, err := PayByQR(req, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "pay by QR")
+		return
+	}
+
+	log.Printf("QR payment %s: %s paid from account %s to merchant %s", qr.ID, tx.Amount.String(), tx.FromAccountID, qr.MerchantID)
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"qr_payment_id":  qr.ID,
+		"status":         qr.Status,
+		"transaction_id": tx.ID,
+		"amount":         tx.Amount,
+	})
+}
+
+func TransferHandler(w http.ResponseWriter, r *http.Request) {
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.FromAccountID == req.ToAccountID {
+		respondError(w, http.StatusBadRequest, "Cannot transfer to the same account")
+		return
+	}
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		respondError(w, http.StatusBadRequest, "Transfer amount must be positive")
+		return
+	}
+
+	if _, err := ExecuteTransfer(req.FromAccountID, req.ToAccountID, req.Amount, nil); err != nil {
+		respondDebitError(w, err, "process transfer")
+		return
+	}
+
+	log.Printf("Transfer of %s from %s to %s successful", req.Amount.String(), req.FromAccountID, req.ToAccountID)
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Transfer successful"})
+}
+
+func PrepareTransferHandler(w http.ResponseWriter, r *http.Request) {
+	var req PrepareTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		respondError(w, http.StatusBadRequest, "Transfer amount must be positive")
+		return
+	}
+	if _, ok := GetAccount(req.FromAccountID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Source account %s not found", req.FromAccountID))
+		return
+	}
+
+	toAccount, toType, err := ResolveRecipient(req.To, req.ToType)
+	if err != nil {
+		respondDebitError(w, err, "resolve recipient")
+		return
+	}
+	if toAccount.ID == req.FromAccountID {
+		respondError(w, http.StatusBadRequest, "Cannot transfer to the same account")
+		return
+	}
+	recipient, _ := GetUser(toAccount.UserID)
+
+	now := time.Now()
+	quote, err := QuoteFee(FeePreviewRequest{
+		AccountID:   req.FromAccountID,
+		ToAccountID: toAccount.ID,
+		Operation:   "transfer",
+		Amount:      req.Amount,
+	}, now)
+	if err != nil {
+		respondDebitError(w, err, "calculate transfer fee")
+		return
+	}
+	confirmation := TransferConfirmation{
+		ID:            GenerateID(),
+		FromAccountID: req.FromAccountID,
+		ToAccountID:   toAccount.ID,
+		Amount:        req.Amount,
+		RecipientType: toType,
+		RecipientName: MaskName(recipient),
+		RecipientHint: MaskAccountNumber(toAccount.Number),
+		Fee:           quote.Fee,
+		Status:        "pending",
+		ExpiresAt:     now.Add(transferConfirmationTTL),
+		CreatedAt:     now,
+	}
+	AddTransferConfirmation(confirmation)
+
+	log.Printf("Transfer confirmation %s prepared from %s by %s", confirmation.ID, req.FromAccountID, toType)
+	respondJSON(w, http.StatusCreated, confirmation)
+}
+
+func ConfirmTransferHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	confirmationID := vars["confirmationId"]
+
+	confirmation, err := TakeTransferConfirmation(confirmationID, time.Now())
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			respondError(w, http.StatusNotFound, err.Error())
+		} else {
+			respondError(w, http.StatusConflict, err.Error())
+		}
+		return
+	}
+
+	tx, err := ExecuteTransfer(confirmation.FromAccountID, confirmation.ToAccountID, confirmation.Amount, &confirmation.Fee)
+	if err != nil {
+		var feeErr *FeeChangedError
+		if !errors.As(err, &feeErr) { // при новой комиссии перевод нужно подготовить заново
+			ReleaseTransferConfirmation(confirmationID)
+		}
+		respondDebitError(w, err, "process transfer")
+		return
+	}
+
+	log.Printf("Transfer of %s from %s confirmed (%s)", confirmation.Amount.String(), confirmation.FromAccountID, confirmationID)
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message":        "Transfer successful",
+		"transaction_id": tx.ID,
+		"recipient_name": confirmation.RecipientName,
+	})
+}
+
+// respondRequestError отвечает на ошибку проверки запроса денег или счета: известные ошибки — как при списании, остальные — 400.
+func respondRequestError(w http.ResponseWriter, err error, action string) {
+	var recipientErr *RecipientError
+	if errors.As(err, &recipientErr) || errors.Is(err, errAccountNotOwned) || errors.Is(err, errNonPositiveAmount) ||
+		errors.Is(err, errNotFound) {
+		respondDebitError(w, err, action)
+		return
+	}
+	respondError(w, http.StatusBadRequest, err.Error())
+}
+
+func CreateMoneyRequestHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateMoneyRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	request, err := NewMoneyRequest(req, time.Now())
+	if err != nil {
+		respondRequestError(w, err, "create money request")
+		return
+	}
+	AddMoneyRequest(request)
+	notifyPayer(request.PayerID, "Money request",
+		fmt.Sprintf("%s requests %s RUB from you: %s", request.RequesterName, request.Amount.StringFixed(2), request.Comment))
+
+	log.Printf("Money request %s for %s created by user %s", request.ID, request.Amount.String(), request.RequesterID)
+	respondJSON(w, http.StatusCreated, request)
+}
+
+// GetUserMoneyRequestsHandler возвращает запросы клиента: role=incoming — адресованные ему, role=outgoing — созданные им.
+func GetUserMoneyRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	role := r.URL.Query().Get("role")
+	status := r.URL.Query().Get("status")
+
+	requests := []MoneyRequest{}
+	for _, request := range GetUserMoneyRequests(userID) {
+		if (role == "incoming" && request.PayerID != userID) || (role == "outgoing" && request.RequesterID != userID) {
+			continue
+		}
+		if status != "" && request.Status != status {
+			continue
+		}
+		requests = append(requests, request)
+	}
+	respondJSON(w, http.StatusOK, requests)
+}
+
+func GetMoneyRequestHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestID := vars["requestId"]
+
+	request, ok := GetMoneyRequest(requestID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Money request %s not found", requestID))
+		return
+	}
+	respondJSON(w, http.StatusOK, request)
+}
+
+func AcceptMoneyRequestHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	requestID := vars["requestId"]
+
+	var req RespondMoneyRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	request, ok := GetMoneyRequest(requestID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Money request %s not found", requestID))
+		return
+	}
+	if request.PayerID != req.UserID {
+		respondDebitError(w, errNotPayer, "accept money request")
+		return
+	}
+
+	request, err := PayMoneyRequest(request, req.FromAccountID, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "accept money request")
+		return
+	}
+
+	log.Printf("Money request %s accepted, transaction %s", request.ID, request.TransactionID)
+	respondJSON(w, http.StatusOK, request)
+}
+
+func DeclineMoneyRequestHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req RespondMoneyRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	now := time.Now()
+	request, err := UpdateMoneyRequest(vars["requestId"], func(mr *MoneyRequest) error {
+		if mr.PayerID != req.UserID {
+			return errNotPayer
+		}
+		if mr.Status != "pending" || !now.Before(mr.ExpiresAt) {
+			return errMoneyRequestClosed
+		}
+		mr.Status = "declined"
+		mr.RespondedAt = &now
+		return nil
+	})
+	if err != nil {
+		respondDebitError(w, err, "decline money request")
+		return
+	}
+
+	log.Printf("Money request %s declined", request.ID)
+	respondJSON(w, http.StatusOK, request)
+}
+
+func CancelMoneyRequestHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req CancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	request, err := UpdateMoneyRequest(vars["requestId"], func(mr *MoneyRequest) error {
+		if mr.RequesterID != req.UserID {
+			return errNotRequester
+		}
+		if mr.Status != "pending" {
+			return errMoneyRequestClosed
+		}
+		mr.Status = "cancelled"
+		return nil
+	})
+	if err != nil {
+		respondDebitError(w, err, "cancel money request")
+		return
+	}
+
+	log.Printf("Money request %s cancelled", request.ID)
+	respondJSON(w, http.StatusOK, request)
+}
+
+func CreateInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateInvoiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	invoice, err := NewInvoice(req, time.Now())
+	if err != nil {
+		respondRequestError(w, err, "create invoice")
+		return
+	}
+	AddInvoice(invoice)
+	notifyPayer(invoice.PayerID, "New invoice",
+		fmt.Sprintf("%s issued you an invoice for %s RUB due %s", invoice.IssuerName, invoice.Total.StringFixed(2), invoice.DueDate.Format("2006-01-02")))
+
+	log.Printf("Invoice %s for %s issued by user %s", invoice.ID, invoice.Total.String(), invoice.IssuerID)
+	respondJSON(w, http.StatusCreated, invoice)
+}
+
+// GetUserInvoicesHandler возвращает счета клиента: role=received — выставленные ему, role=issued — выставленные им.
+func GetUserInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+	role := r.URL.Query().Get("role")
+	status := r.URL.Query().Get("status")
+
+	invoices := []Invoice{}
+	for _, invoice := range GetUserInvoices(userID) {
+		if (role == "received" && invoice.PayerID != userID) || (role == "issued" && invoice.IssuerID != userID) {
+			continue
+		}
+		if status != "" && invoice.Status != status {
+			continue
+		}
+		invoices = append(invoices, invoice)
+	}
+	respondJSON(w, http.StatusOK, invoices)
+}
+
+func GetInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	invoiceID := vars["invoiceId"]
+
+	invoice, ok := GetInvoice(invoiceID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Invoice %s not found", invoiceID))
+		return
+	}
+	respondJSON(w, http.StatusOK, invoice)
+}
+
+func PayInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	invoiceID := vars["invoiceId"]
+
+	var req PayInvoiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	invoice, ok := GetInvoice(invoiceID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Invoice %s not found", invoiceID))
+		return
+	}
+	if invoice.PayerID != req.UserID {
+		respondDebitError(w, errNotPayer, "pay invoice")
+		return
+	}
+
+	invoice, tx, err := PayInvoiceFrom(invoice, req.FromAccountID, req.Amount, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "pay invoice")
+		return
+	}
+
+	log.Printf("Invoice %s paid %s, outstanding %s", invoice.ID, tx.Amount.String(), invoice.Outstanding.String())
+	respondJSON(w, http.StatusOK, invoice)
+}
+
+func CancelInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req CancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	invoice, err := UpdateInvoice(vars["invoiceId"], func(inv *Invoice) error {
+		if inv.IssuerID != req.UserID {
+			return errNotIssuer
+		}
+		if inv.Status == "paid" || inv.Status == "cancelled" || len(inv.Payments) > 0 {
+			return errInvoiceNotCancelable
+		}
+		inv.Status = "cancelled"
+		return nil
+	})
+	if err != nil {
+		respondDebitError(w, err, "cancel invoice")
+		return
+	}
+
+	log.Printf("Invoice %s cancelled", invoice.ID)
+	respondJSON(w, http.StatusOK, invoice)
+}
+
+func GetBillProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, GetBillProviders(r.URL.Query().Get("category")))
+}
+
+func GetBillProviderHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	providerID := vars["providerId"]
+
+	provider, ok := GetBillProvider(providerID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Bill provider %s not found", providerID))
+		return
+	}
+	respondJSON(w, http.StatusOK, provider)
+}
+
+func CreateBillPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateBillPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	payment, err := PayBill(req, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "pay bill")
+		return
+	}
+
+	log.Printf("Bill payment %s of %s to %s from account %s is %s", payment.ID, payment.Amount.String(), payment.ProviderID, payment.AccountID, payment.Status)
+	respondJSON(w, http.StatusAccepted, payment)
+}
+
+func GetBillPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	paymentID := vars["paymentId"]
+
+	payment, ok := GetBillPayment(paymentID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Bill payment %s not found", paymentID))
+		return
+	}
+	respondJSON(w, http.StatusOK, payment)
+}
+
+func GetUserBillPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	status := r.URL.Query().Get("status")
+
+	payments := []BillPayment{}
+	for _, payment := range GetUserBillPayments(vars["userId"]) {
+		if status == "" || payment.Status == status {
+			payments = append(payments, payment)
+		}
+	}
+	respondJSON(w, http.StatusOK, payments)
+}
+
+func CreateBillTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateBillTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	template, err := NewBillTemplate(req, time.Now())
+	if err != nil {
+		respondRequestError(w, err, "create bill template")
+		return
+	}
+	AddBillTemplate(template)
+
+	log.Printf("Bill template %s for %s saved by user %s", template.ID, template.ProviderID, template.UserID)
+	respondJSON(w, http.StatusCreated, template)
+}
+
+func GetUserBillTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	respondJSON(w, http.StatusOK, GetUserBillTemplates(vars["userId"]))
+}
+
+func DeleteBillTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	templateID := vars["templateId"]
+
+	if err := DeleteBillTemplate(templateID); err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Template deleted"})
+}
+
+func CreateStandingOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateStandingOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		respondError(w, http.StatusBadRequest, "Transfer amount must be positive")
+		return
+	}
+	if !standingOrderFrequencies[req.Frequency] {
+		respondError(w, http.StatusBadRequest, "Frequency must be one of: once, daily, weekly, monthly, last_business_day")
+		return
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !req.StartDate.IsZero() && req.StartDate.Before(today) {
+		respondError(w, http.StatusBadRequest, "Start date must not be in the past")
+		return
+	}
+	// дата начала сегодня, но уже прошедшим временем — исполнение с текущего момента, без догоняющих списаний
+	if req.StartDate.Before(now) {
+		req.StartDate = now
+	}
+	if req.EndDate != nil && req.EndDate.Before(req.StartDate) {
+		respondError(w, http.StatusBadRequest, "End date must be after start date")
+		return
+	}
+	if req.MaxExecutions < 0 {
+		respondError(w, http.StatusBadRequest, "Execution count must not be negative")
+		return
+	}
+
+	toAccount, _, err := ResolveRecipient(req.To, req.ToType)
+	if err != nil {
+		respondDebitError(w, err, "resolve recipient")
+		return
+	}
+	if toAccount.ID == req.FromAccountID {
+		respondError(w, http.StatusBadRequest, "Cannot transfer to the same account")
+		return
+	}
+
+	order := StandingOrder{
+		ID:            GenerateID(),
+		UserID:        req.UserID,
+		FromAccountID: req.FromAccountID,
+		ToAccountID:   toAccount.ID,
+		Amount:        req.Amount,
+		Description:   req.Description,
+		Frequency:     req.Frequency,
+		DayOfMonth:    req.StartDate.Day(),
+		StartDate:     req.StartDate,
+		EndDate:       req.EndDate,
+		MaxExecutions: req.MaxExecutions,
+		Status:        "active",
+		Executions:    []StandingOrderExecution{},
+		CreatedAt:     now,
+	}
+	if order.Frequency == "once" {
+		order.MaxExecutions = 1
+	}
+	order.NextRunAt = FirstStandingOrderRun(order)
+	if standingOrderFinished(order) {
+		respondError(w, http.StatusBadRequest, "No execution date falls between the start and end dates")
+		return
+	}
+
+	if err := AddStandingOrder(order); err != nil {
+		if strings.Contains(err.Error(), "does not belong") {
+			respondError(w, http.StatusForbidden, err.Error())
+		} else {
+			respondDebitError(w, err, "create standing order")
+		}
+		return
+	}
+
+	log.Printf("Standing order %s created for user %s (%s), next run at %s", order.ID, order.UserID, order.Frequency, order.NextRunAt.Format(time.RFC3339))
+	respondJSON(w, http.StatusCreated, order)
+}
+
+func GetUserStandingOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+
+	orders := GetUserStandingOrders(userID)
+	log.Printf("Fetched %d standing orders for user %s", len(orders), userID)
+	respondJSON(w, http.StatusOK, orders)
+}
+
+func GetStandingOrderHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID := vars["orderId"]
+
+	order, ok := GetStandingOrder(orderID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Standing order %s not found", orderID))
+		return
+	}
+	respondJSON(w, http.StatusOK, order)
+}
+
+func changeStandingOrderStatus(w http.ResponseWriter, r *http.Request, from []string, to string) {
+	vars := mux.Vars(r)
+	orderID := vars["orderId"]
+	now := time.Now()
+
+	order, err := UpdateStandingOrder(orderID, func(order *StandingOrder) error {
+		allowed := false
+		for _, status := range from {
+			if order.Status == status {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("standing order %s is %s", order.ID, order.Status)
+		}
+		order.Status = to
+		if to == "active" {
+			skipMissedRuns(order, now)
+			if standingOrderFinished(*order) {
+				order.Status = "completed"
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			respondError(w, http.StatusNotFound, err.Error())
+		} else {
+			respondError(w, http.StatusConflict, err.Error())
+		}
+		return
+	}
+
+	log.Printf("Standing order %s is now %s", order.ID, order.Status)
+	respondJSON(w, http.StatusOK, order)
+}
+
+func PauseStandingOrderHandler(w http.ResponseWriter, r *http.Request) {
+	changeStandingOrderStatus(w, r, []string{"active"}, "paused")
+}
+
+func ResumeStandingOrderHandler(w http.ResponseWriter, r *http.Request) {
+	changeStandingOrderStatus(w, r, []string{"paused"}, "active")
+}
+
+func CancelStandingOrderHandler(w http.ResponseWriter, r *http.Request) {
+	changeStandingOrderStatus(w, r, []string{"active", "paused"}, "cancelled")
+}
+
+func CreatePaymentBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req BatchPaymentRequest
+	defer r.Body.Close()
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		query := r.URL.Query()
+		req.UserID = query.Get("user_id")
+		req.FromAccountID = query.Get("from_account_id")
+		req.Mode = query.Get("mode")
+		lines, err := ParseBatchCSV(r.Body)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Lines = lines
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.Mode == "" {
+		req.Mode = "best_effort"
+	}
+	if req.Mode != "best_effort" && req.Mode != "all_or_nothing" {
+		respondError(w, http.StatusBadRequest, "Mode must be 'best_effort' or 'all_or_nothing'")
+		return
+	}
+	if len(req.Lines) == 0 || len(req.Lines) > maxBatchLines {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Batch must contain between 1 and %d lines", maxBatchLines))
+		return
+	}
+
+	fromAccount, ok := GetAccount(req.FromAccountID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Source account %s not found", req.FromAccountID))
+		return
+	}
+	if fromAccount.UserID != req.UserID {
+		respondError(w, http.StatusForbidden, fmt.Sprintf("Account %s does not belong to user %s", req.FromAccountID, req.UserID))
+		return
+	}
+
+	batch := PaymentBatch{
+		ID:            GenerateID(),
+		UserID:        req.UserID,
+		FromAccountID: req.FromAccountID,
+		Mode:          req.Mode,
+		CreatedAt:     time.Now(),
+	}
+	ValidateBatch(&batch, req.Lines)
+
+	if batch.ValidLines == 0 || (batch.Mode == "all_or_nothing" && batch.InvalidLines > 0) {
+		batch.Status = "rejected"
+		for i := range batch.Lines {
+			if batch.Lines[i].Status == "pending" {
+				batch.Lines[i].Status = "skipped"
+			}
+		}
+		now := time.Now()
+		batch.CompletedAt = &now
+		AddPaymentBatch(batch)
+		log.Printf("Payment batch %s rejected: %d invalid lines", batch.ID, batch.InvalidLines)
+		respondJSON(w, http.StatusUnprocessableEntity, batch)
+		return
+	}
+
+	batch.Status = "processing"
+	AddPaymentBatch(batch)
+	go ProcessPaymentBatch(batch)
+
+	log.Printf("Payment batch %s accepted: %d lines, total %s", batch.ID, len(batch.Lines), batch.TotalAmount.String())
+	respondJSON(w, http.StatusAccepted, batch)
+}
+
+func GetPaymentBatchHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	batchID := vars["batchId"]
+
+	batch, ok := GetPaymentBatch(batchID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Payment batch %s not found", batchID))
+		return
+	}
+	respondJSON(w, http.StatusOK, batch)
+}
+
+func GetUserPaymentBatchesHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+
+	batches := GetUserPaymentBatches(userID)
+	for i := range batches {
+		batches[i].Lines = nil
+	}
+	log.Printf("Fetched %d payment batches for user %s", len(batches), userID)
+	respondJSON(w, http.StatusOK, batches)
+}
+
+func CreateSBPTransferHandler(w http.ResponseWriter, r *http.Request) {
+	var req SBPTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Amount.LessThan(sbpConfig.MinAmount) || req.Amount.GreaterThan(sbpConfig.MaxPerTransfer) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("SBP transfer amount must be between %s and %s",
+			sbpConfig.MinAmount.String(), sbpConfig.MaxPerTransfer.String()))
+		return
+	}
+	phone, err := NormalizePhone(req.Phone)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.BankID == "" {
+		respondError(w, http.StatusBadRequest, "Recipient bank ID is required")
+		return
+	}
+	if req.BankID == sbpConfig.BankID {
+		respondError(w, http.StatusBadRequest, "Recipient is a customer of this bank, use /transfers/prepare instead")
+		return
+	}
+
+	account, ok := GetAccount(req.FromAccountID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Source account %s not found", req.FromAccountID))
+		return
+	}
+	if account.UserID != req.UserID {
+		respondError(w, http.StatusForbidden, fmt.Sprintf("Account %s does not belong to user %s", req.FromAccountID, req.UserID))
+		return
+	}
+
+	now := time.Now()
+	transfer := SBPTransfer{
+		ID:          GenerateID(),
+		Direction:   "outgoing",
+		UserID:      req.UserID,
+		AccountID:   account.ID,
+		Phone:       phone,
+		BankID:      req.BankID,
+		Amount:      req.Amount,
+		Description: req.Description,
+		Status:      "pending",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	tx := Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   account.ID,
+		Amount:          req.Amount,
+		Timestamp:       now,
+		TransactionType: "sbp_outgoing",
+		Description:     fmt.Sprintf("SBP transfer to %s (bank %s)", phone, req.BankID),
+	}
+	txs, quote, err := ChargeFee(tx, "interbank", "")
+	if err != nil {
+		respondDebitError(w, err, "process SBP transfer")
+		return
+	}
+	transfer.TransactionID = tx.ID
+	transfer.Fee = quote.Fee
+	if len(txs) > 1 {
+		transfer.FeeTransactionID = txs[1].ID
+	}
+
+	if err := AddSBPTransfer(transfer, txs...); err != nil {
+		respondDebitError(w, err, "process SBP transfer")
+		return
+	}
+
+	user, _ := GetUser(req.UserID)
+	externalID, err := sbpConnector.SendTransfer(SBPOutgoingRequest{
+		TransferID:   transfer.ID,
+		SenderBankID: sbpConfig.BankID,
+		SenderName:   MaskName(user),
+		Phone:        phone,
+		BankID:       req.BankID,
+		Amount:       req.Amount,
+		Description:  req.Description,
+	})
+	if err != nil {
+		settled, settleErr := SettleSBPTransfer(transfer.ID, "rejected", err.Error(), sbpRefundTransactions(transfer, time.Now()), time.Now())
+		if settleErr != nil {
+			log.Printf("Failed to refund SBP transfer %s: %v", transfer.ID, settleErr)
+		}
+		log.Printf("SBP gateway error for transfer %s: %v", transfer.ID, err)
+		respondErrorDetails(w, http.StatusBadGateway, "SBP gateway error", map[string]interface{}{
+			"transfer": settled,
+		})
+		return
+	}
+	SetSBPTransferExternalID(transfer.ID, externalID)
+	transfer.ExternalID = externalID
+
+	log.Printf("SBP transfer %s of %s from account %s sent to bank %s", transfer.ID, req.Amount.String(), account.ID, req.BankID)
+	respondJSON(w, http.StatusAccepted, transfer)
+}
+
+func GetSBPTransferHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	transferID := vars["transferId"]
+
+	transfer, ok := GetSBPTransfer(transferID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("SBP transfer %s not found", transferID))
+		return
+	}
+	respondJSON(w, http.StatusOK, transfer)
+}
+
+func GetAccountSBPTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID := vars["accountId"]
+
+	transfers := GetAccountSBPTransfers(accountID)
+	log.Printf("Fetched %d SBP transfers for account %s", len(transfers), accountID)
+	respondJSON(w, http.StatusOK, transfers)
+}
+
+func SBPCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	defer r.Body.Close()
+
+	if sbpConfig.Secret == "" {
+		log.Printf("BANKAPP_SBP_SECRET is not set, rejecting SBP callback")
+		respondError(w, http.StatusForbidden, "SBP callbacks are not configured")
+		return
+	}
+	if !VerifySBPSignature(body, r.Header.Get("X-SBP-Signature")) {
+		respondError(w, http.StatusUnauthorized, "Invalid SBP signature")
+		return
+	}
+
+	var callback SBPCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if callback.Type == "incoming_transfer" {
+		transfer, err := processSBPIncoming(callback)
+		if err != nil {
+			respondDebitError(w, err, "process incoming SBP transfer")
+			return
+		}
+		respondJSON(w, http.StatusOK, transfer)
+		return
+	}
+
+	if err := ProcessSBPCallback(callback); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			respondError(w, http.StatusNotFound, err.Error())
+		} else {
+			respondError(w, http.StatusConflict, err.Error())
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Callback processed"})
+}
+
+func GetLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	scope, ownerID := vars["scope"], vars["ownerId"]
+
+	if err := validateLimitOwner(scope, ownerID); err != nil {
+		respondDebitError(w, err, "get limits")
+		return
+	}
+	respondJSON(w, http.StatusOK, LimitsView(scope, ownerID))
+}
+
+func SetLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	scope, ownerID := vars["scope"], vars["ownerId"]
+
+	var req SetLimitsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := validateLimitOwner(scope, ownerID); err != nil {
+		respondDebitError(w, err, "set limits")
+		return
+	}
+
+	if req.Reset {
+		ResetCustomLimits(scope, ownerID, req.Category)
+	} else {
+		limits, ok := GetEffectiveLimits(scope, ownerID, req.Category)
+		if !ok {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Limits for '%s' are not available on %s level", req.Category, scope))
+			return
+		}
+		if req.PerTransaction != nil {
+			limits.PerTransaction = *req.PerTransaction
+		}
+		if req.Daily != nil {
+			limits.Daily = *req.Daily
+		}
+		if req.Monthly != nil {
+			limits.Monthly = *req.Monthly
+		}
+		if err := SetCustomLimits(scope, ownerID, req.Category, limits); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	log.Printf("Limits for %s on %s %s updated", req.Category, scope, ownerID)
+	respondJSON(w, http.StatusOK, LimitsView(scope, ownerID))
+}
+
+func DepositHandler(w http.ResponseWriter, r *http.Request) {
+	var req DepositRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		respondError(w, http.StatusBadRequest, "Deposit amount must be positive")
+		return
+	}
+	if req.Channel == "" {
+		req.Channel = "branch"
+	}
+	if !depositChannels[req.Channel] {
+		respondError(w, http.StatusBadRequest, "Channel must be one of: branch, atm, transfer_in")
+		return
+	}
+	if req.Channel == "atm" {
+		if req.ATMID == "" {
+			respondError(w, http.StatusBadRequest, "ATM ID is required for ATM deposits")
+			return
+		}
+		if _, ok := GetATM(req.ATMID); !ok {
+			respondError(w, http.StatusNotFound, fmt.Sprintf("ATM %s not found", req.ATMID))
+			return
+		}
+	}
+
+	account, ok := GetAccount(req.ToAccountID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Account %s not found", req.ToAccountID))
+		return
+	}
+	tx := Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   "",
+		ToAccountID:     req.ToAccountID,
+		Amount:          req.Amount,
+		Timestamp:       time.Now(),
+		TransactionType: "deposit",
+		Description:     fmt.Sprintf("Deposit to account %s", account.Number),
+		Channel:         req.Channel,
+		TerminalID:      req.ATMID,
+	}
+	if err := PostTransaction(tx); err != nil {
+		respondDebitError(w, err, "process deposit")
+		return
+	}
+
+	log.Printf("Deposit of %s to account %s via %s successful", req.Amount.String(), req.ToAccountID, req.Channel)
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Deposit successful"})
+}
+
+func RegisterATMHandler(w http.ResponseWriter, r *http.Request) {
+	var req RegisterATMRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	atm, key, err := NewATM(req, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "register ATM")
+		return
+	}
+	if err := AddATM(atm); err != nil {
+		respondDebitError(w, err, "register ATM")
+		return
+	}
+
+	log.Printf("ATM %s registered in network %s (%s)", atm.ID, atm.NetworkID, ClassifyATMNetwork(atm.NetworkID))
+	respondJSON(w, http.StatusCreated, RegisteredATM{ATM: atm, Key: key})
+}
+
+func GetATMHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	atmID := vars["atmId"]
+
+	atm, ok := GetATM(atmID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("ATM %s not found", atmID))
+		return
+	}
+	respondJSON(w, http.StatusOK, atm)
+}
+
+func ATMWithdrawalHandler(w http.ResponseWriter, r *http.Request) {
+	var req ATMWithdrawalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	atmID := r.Header.Get("X-ATM-ID")
+	if req.ATMID != "" && req.ATMID != atmID {
+		respondDebitError(w, errATMMismatch, "withdraw cash")
+		return
+	}
+	req.ATMID = atmID
+
+	withdrawal, err := WithdrawCash(req, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "withdraw cash")
+		return
+	}
+
+	log.Printf("Cash withdrawal %s of %s (fee %s) at %s ATM %s from account %s", withdrawal.ID, withdrawal.Amount.String(),
+		withdrawal.Fee.String(), withdrawal.NetworkType, withdrawal.ATMID, withdrawal.AccountID)
+	respondJSON(w, http.StatusCreated, withdrawal)
+}
+
+func GetATMWithdrawalHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	withdrawalID := vars["withdrawalId"]
+
+	withdrawal, ok := GetATMWithdrawal(withdrawalID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("ATM withdrawal %s not found", withdrawalID))
+		return
+	}
+	respondJSON(w, http.StatusOK, withdrawal)
+}
+
+// ATMDispenseFailureHandler принимает от банкомата, выдававшего наличные, сообщение о невыданной сумме
+// и возвращает ее на счет.
+func ATMDispenseFailureHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	withdrawalID := vars["withdrawalId"]
+
+	var req DispenseFailureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	withdrawal, err := ReverseATMWithdrawal(withdrawalID, r.Header.Get("X-ATM-ID"), req.DispensedAmount, time.Now())
+	if err != nil {
+		respondDebitError(w, err, "reverse ATM withdrawal")
+		return
+	}
+
+	log.Printf("ATM withdrawal %s %s, dispensed %s of %s", withdrawal.ID, withdrawal.Status, withdrawal.DispensedAmount.String(), withdrawal.Amount.String())
+	respondJSON(w, http.StatusOK, withdrawal)
+}
+
+func ApplyLoanHandler(w http.ResponseWriter, r *http.Request) {
+	var req ApplyLoanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Amount.LessThanOrEqual(decimal.Zero) || req.TermMonths <= 0 {
+		respondError(w, http.StatusBadRequest, "Loan amount and term must be positive")
+		return
+	}
+
+	storage.mu.RLock()
+	_, userExists := storage.users[req.UserID]
+	_, accountExists := storage.accounts[req.AccountID]
+	storage.mu.RUnlock()
+
+	if !userExists {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("User %s not found", req.UserID))
+		return
+	}
+	if !accountExists {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Account %s not found", req.AccountID))
+		return
+	}
+
+	baseRate, err := GetCBRKeyRate()
+	if err != nil {
+		log.Printf("Warning: Failed to get key rate, using default 10%%: %v", err)
+		baseRate = decimal.NewFromInt(10)
+	}
+
+	interestRate := baseRate.Add(decimal.NewFromInt(5))
+
+	monthlyPayment := CalculateMonthlyPayment(req.Amount, interestRate, req.TermMonths)
+	startDate := time.Now()
+	schedule := GeneratePaymentSchedule(req.Amount, interestRate, req.TermMonths, startDate, monthlyPayment)
+
+	loan := Loan{
+		ID:              GenerateID(),
+		UserID:          req.UserID,
+		AccountID:       req.AccountID,
+		Amount:          req.Amount,
+		InterestRate:    interestRate,
+		TermMonths:      req.TermMonths,
+		StartDate:       startDate,
+		PaymentSchedule: schedule,
+		RemainingAmount: req.Amount,
+	}
+
+	disbursement := Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   "",
+		ToAccountID:     req.AccountID,
+		Amount:          req.Amount,
+		Timestamp:       time.Now(),
+		TransactionType: "loan_disbursement",
+		Description:     fmt.Sprintf("Loan disbursement (ID: %s)", loan.ID),
+	}
+	if err := AddLoan(loan, disbursement); err != nil {
+		respondDebitError(w, err, "save loan")
+		return
+	}
+
+	log.Printf("Loan %s approved for user %s, amount %s, rate %s%%, term %d months. Funds disbursed to account %s.",
+		loan.ID, req.UserID, req.Amount.String(), interestRate.String(), req.TermMonths, req.AccountID)
+
+	respondJSON(w, http.StatusCreated, loan)
+}
+
+func OpenTermDepositHandler(w http.ResponseWriter, r *http.Request) {
+	var req OpenTermDepositRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Amount.LessThan(termDepositConfig.MinAmount) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Minimum term deposit amount is %s", termDepositConfig.MinAmount.String()))
+		return
+	}
+	if req.TermMonths < termDepositConfig.MinTermMonths || req.TermMonths > termDepositConfig.MaxTermMonths {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Term must be between %d and %d months", termDepositConfig.MinTermMonths, termDepositConfig.MaxTermMonths))
+		return
+	}
+	if req.RateType == "" {
+		req.RateType = "fixed"
+	}
+	if req.InterestPayout == "" {
+		req.InterestPayout = "maturity"
+	}
+	if req.InterestPayout != "maturity" && req.InterestPayout != "monthly" {
+		respondError(w, http.StatusBadRequest, "Interest payout must be 'maturity' or 'monthly'")
+		return
+	}
+
+	rate, err := TermDepositRate(req.RateType, req.TermMonths)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now()
+	deposit := TermDeposit{
+		ID:               GenerateID(),
+		UserID:           req.UserID,
+		AccountID:        req.AccountID,
+		Amount:           req.Amount,
+		InterestRate:     rate,
+		EarlyClosureRate: termDepositConfig.EarlyClosureRate,
+		RateType:         req.RateType,
+		InterestPayout:   req.InterestPayout,
+		AutoRollover:     req.AutoRollover,
+		TermMonths:       req.TermMonths,
+		StartDate:        now,
+		MaturityDate:     now.AddDate(0, req.TermMonths, 0),
+		InterestPaid:     decimal.Zero,
+		Status:           "active",
+		CreatedAt:        now,
+	}
+	tx := Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   req.AccountID,
+		Amount:          req.Amount,
+		Timestamp:       now,
+		TransactionType: "term_deposit_open",
+		Description:     fmt.Sprintf("Term deposit opening (ID: %s)", deposit.ID),
+	}
+
+	if err := OpenTermDeposit(deposit, tx); err != nil {
+		if strings.Contains(err.Error(), "does not belong") {
+			respondError(w, http.StatusForbidden, err.Error())
+		} else {
+			respondDebitError(w, err, "open term deposit")
+		}
+		return
+	}
+
+	log.Printf("Term deposit %s opened for user %s, amount %s, rate %s%%, term %d months",
+		deposit.ID, deposit.UserID, deposit.Amount.String(), deposit.InterestRate.String(), deposit.TermMonths)
+	respondJSON(w, http.StatusCreated, deposit)
+}
+
+func GetUserTermDepositsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+
+	deposits := GetUserTermDeposits(userID)
+	log.Printf("Fetched %d term deposits for user %s", len(deposits), userID)
+	respondJSON(w, http.StatusOK, deposits)
+}
+
+func GetTermDepositHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	depositID := vars["depositId"]
+
+	deposit, ok := GetTermDeposit(depositID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Term deposit %s not found", depositID))
+		return
+	}
+	respondJSON(w, http.StatusOK, deposit)
+}
+
+func CloseTermDepositHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	depositID := vars["depositId"]
+
+	if _, ok := GetTermDeposit(depositID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Term deposit %s not found", depositID))
+		return
+	}
+
+	deposit, payout, err := CloseTermDepositEarly(depositID, time.Now())
+	if err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	log.Printf("Term deposit %s closed early, %s returned to account %s", deposit.ID, payout.String(), deposit.AccountID)
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"deposit": deposit,
+		"payout":  payout,
+	})
+}
+
+func SetTermDepositRolloverHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	depositID := vars["depositId"]
+
+	var req TermDepositRolloverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if _, ok := GetTermDeposit(depositID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Term deposit %s not found", depositID))
+		return
+	}
+
+	deposit, err := UpdateTermDeposit(depositID, func(deposit *TermDeposit) ([]Transaction, error) {
+		deposit.AutoRollover = req.AutoRollover
+		return nil, nil
+	})
+	if err != nil {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	log.Printf("Term deposit %s auto rollover set to %t", deposit.ID, deposit.AutoRollover)
+	respondJSON(w, http.StatusOK, deposit)
+}
+
+func GetLoanScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	loanID := vars["loanId"]
+
+	loan, ok := GetLoan(loanID)
+	if !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Loan %s not found", loanID))
+		return
+	}
+
+	log.Printf("Fetched payment schedule for loan %s", loanID)
+	respondJSON(w, http.StatusOK, loan.PaymentSchedule)
+}
+
+// GetTransactionsHandler возвращает историю счета от новых операций к старым (см. ParseTransactionFilter):
+// массивом, а при cursor или limit — страницей с next_cursor.
+func GetTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID := vars["accountId"]
+
+	if _, ok := GetAccount(accountID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Account %s not found", accountID))
+		return
+	}
+
+	filter, err := ParseTransactionFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page := QueryAccountTransactions(accountID, filter)
+
+	log.Printf("Fetched %d transactions for account %s", len(page.Transactions), accountID)
+	if filter.Limit == 0 {
+		respondJSON(w, http.StatusOK, page.Transactions) // прежний формат ответа — массив без страниц
+		return
+	}
+	respondJSON(w, http.StatusOK, page)
+}
+
+// GetAccountStatementHandler отдает выписку за период в формате format: csv, ofx, camt053 или pdf.
+// По умолчанию — pdf, а если сертификат подписи выписок не настроен — csv.
+func GetAccountStatementHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	accountID := vars["accountId"]
+
+	if _, ok := GetAccount(accountID); !ok {
+		respondError(w, http.StatusNotFound, fmt.Sprintf("Account %s not found", accountID))
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "pdf"
+		if statementSigning == nil {
+			format = "csv"
+		}
+	}
+	spec, ok := statementFormats[format]
+	if !ok {
+		respondError(w, http.StatusBadRequest, "format must be one of: csv, ofx, camt053, pdf")
+		return
+	}
+	if format == "pdf" && statementSigning == nil {
+		respondError(w, http.StatusServiceUnavailable, errStatementSigningDisabled.Error())
+		return
+	}
+	from, to, err := ParseStatementPeriod(query)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	statement, err := BuildStatement(accountID, from, to, time.Now())
+	if err != nil {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	var body bytes.Buffer
+	switch format {
+	case "csv":
+		err = RenderStatementCSV(&body, statement)
+	case "ofx":
+		err = RenderStatementOFX(&body, statement)
+	case "camt053":
+		err = RenderStatementCAMT053(&body, statement)
+	default:
+		err = RenderStatementPDF(&body, statement)
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to render statement: %v", err))
+		return
+	}
+
+	filename := fmt.Sprintf("statement_%s_%s_%s.%s", statement.AccountNumber,
+		from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"), spec.Extension)
+	log.Printf("Generated %s statement %s for account %s (%d entries)", format, statement.ID, accountID, len(statement.Entries))
+	w.Header().Set("Content-Type", spec.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+func GetFinancialSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["userId"]
+
+	accounts := GetUserAccounts(userID)
+	loans := GetUserLoans(userID)
 
 	totalBalance := decimal.Zero
 	for _, acc := range accounts {
 		totalBalance = totalBalance.Add(acc.Balance)
 	}
 
+	totalTermDeposits := decimal.Zero
+	for _, deposit := range GetUserTermDeposits(userID) {
+		if deposit.Status == "active" {
+			totalTermDeposits = totalTermDeposits.Add(deposit.Amount)
+		}
+	}
+
 	totalLoanDebt := decimal.Zero
 	activeLoans := 0
 	for _, loan := range loans {
@@ -485,6 +2704,7 @@ func GetFinancialSummaryHandler(w http.ResponseWriter, r *http.Request) {
 		"user_id":               userID,
 		"total_account_balance": totalBalance,
 		"number_of_accounts":    len(accounts),
+		"total_term_deposits":   totalTermDeposits,
 		"total_loan_debt":       totalLoanDebt,
 		"active_loans":          activeLoans,
 	}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultHistoryPageSize = 50
+	maxHistoryPageSize     = 200
+)
+
+func EncodeHistoryCursor(pos int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(pos)))
+}
+
+func DecodeHistoryCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	pos, err := strconv.Atoi(string(raw))
+	if err != nil || pos < 0 {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return pos, nil
+}
+
+// parseHistoryTime принимает RFC 3339 или дату YYYY-MM-DD; дата в параметре to включает весь день.
+func parseHistoryTime(name, value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date (YYYY-MM-DD) or RFC 3339 time", name)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func parseHistoryAmount(name, value string) (*decimal.Decimal, error) {
+	amount, err := decimal.NewFromString(value)
+	if err != nil || amount.IsNegative() {
+		return nil, fmt.Errorf("%s must be a non-negative amount", name)
+	}
+	return &amount, nil
+}
+
+// ParseTransactionFilter разбирает параметры запроса истории: cursor, limit, from, to, type (через запятую),
+// min_amount, max_amount, counterparty (ID или номер счета, ID мерчанта) и q — поиск по описанию.
+// Без cursor и limit история не разбивается на страницы.
+func ParseTransactionFilter(query url.Values) (TransactionFilter, error) {
+	filter := TransactionFilter{}
+	if query.Has("cursor") || query.Has("limit") {
+		filter.Limit = defaultHistoryPageSize
+	}
+	var err error
+
+	if v := query.Get("cursor"); v != "" {
+		pos, err := DecodeHistoryCursor(v)
+		if err != nil {
+			return TransactionFilter{}, err
+		}
+		filter.Cursor = &pos
+	}
+	if v := query.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxHistoryPageSize {
+			return TransactionFilter{}, fmt.Errorf("limit must be between 1 and %d", maxHistoryPageSize)
+		}
+	}
+	if v := query.Get("from"); v != "" {
+		if filter.From, err = parseHistoryTime("from", v, false); err != nil {
+			return TransactionFilter{}, err
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if filter.To, err = parseHistoryTime("to", v, true); err != nil {
+			return TransactionFilter{}, err
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.To.After(filter.From) {
+		return TransactionFilter{}, fmt.Errorf("to must be later than from")
+	}
+	if v := query.Get("type"); v != "" {
+		filter.Types = make(map[string]bool)
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				filter.Types[t] = true
+			}
+		}
+	}
+	if v := query.Get("min_amount"); v != "" {
+		if filter.MinAmount, err = parseHistoryAmount("min_amount", v); err != nil {
+			return TransactionFilter{}, err
+		}
+	}
+	if v := query.Get("max_amount"); v != "" {
+		if filter.MaxAmount, err = parseHistoryAmount("max_amount", v); err != nil {
+			return TransactionFilter{}, err
+		}
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && filter.MaxAmount.LessThan(*filter.MinAmount) {
+		return TransactionFilter{}, fmt.Errorf("max_amount must not be less than min_amount")
+	}
+	if v := strings.TrimSpace(query.Get("counterparty")); v != "" {
+		filter.Counterparties = map[string]bool{v: true}
+		if account, ok := GetAccountByNumber(v); ok {
+			filter.Counterparties[account.ID] = true
+		}
+	}
+	filter.Query = strings.ToLower(strings.TrimSpace(query.Get("q")))
+	return filter, nil
+}
+
+// Matches проверяет транзакцию счета accountID на соответствие фильтру.
+func (f TransactionFilter) Matches(tx Transaction, accountID string) bool {
+	if !f.From.IsZero() && tx.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !tx.Timestamp.Before(f.To) {
+		return false
+	}
+	if f.Types != nil && !f.Types[tx.TransactionType] {
+		return false
+	}
+	if f.MinAmount != nil && tx.Amount.LessThan(*f.MinAmount) {
+		return false
+	}
+	if f.MaxAmount != nil && tx.Amount.GreaterThan(*f.MaxAmount) {
+		return false
+	}
+	if f.Counterparties != nil {
+		counterparty := tx.FromAccountID
+		if counterparty == accountID {
+			counterparty = tx.ToAccountID
+		}
+		if !f.Counterparties[counterparty] && (tx.MerchantID == "" || !f.Counterparties[tx.MerchantID]) {
+			return false
+		}
+	}
+	if f.Query != "" && !strings.Contains(strings.ToLower(tx.Description), f.Query) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const maxInvoiceLines = 100
+
+// invoiceIssuerAccountTypes — счета, на которые можно выставлять счета на оплату.
+var invoiceIssuerAccountTypes = map[string]bool{
+	"business":     true,
+	"entrepreneur": true,
+}
+
+// NewInvoice проверяет позиции и считает итог счета.
+func NewInvoice(req CreateInvoiceRequest, now time.Time) (Invoice, error) {
+	account, err := checkReceivingAccount(req.ToAccountID, req.UserID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if !invoiceIssuerAccountTypes[account.Type] {
+		return Invoice{}, fmt.Errorf("invoices can only be issued to business or entrepreneur accounts")
+	}
+	payer, err := resolvePayer(req.Payer)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if payer.ID == req.UserID {
+		return Invoice{}, fmt.Errorf("cannot issue an invoice to yourself")
+	}
+	issuer, ok := GetUser(req.UserID)
+	if !ok {
+		return Invoice{}, fmt.Errorf("user %s %w", req.UserID, errNotFound)
+	}
+	if len(req.Lines) == 0 || len(req.Lines) > maxInvoiceLines {
+		return Invoice{}, fmt.Errorf("invoice must have between 1 and %d lines", maxInvoiceLines)
+	}
+	if !req.DueDate.After(now) {
+		return Invoice{}, fmt.Errorf("due date must be in the future")
+	}
+
+	total := decimal.Zero
+	lines := make([]InvoiceLine, 0, len(req.Lines))
+	for i, line := range req.Lines {
+		line.Description = strings.TrimSpace(line.Description)
+		if line.Description == "" {
+			return Invoice{}, fmt.Errorf("line %d: description is required", i+1)
+		}
+		if line.Quantity.IsZero() {
+			line.Quantity = decimal.NewFromInt(1)
+		}
+		if !line.Quantity.IsPositive() || !line.UnitPrice.IsPositive() {
+			return Invoice{}, fmt.Errorf("line %d: quantity and unit price must be positive", i+1)
+		}
+		line.Amount = line.Quantity.Mul(line.UnitPrice).Round(2)
+		total = total.Add(line.Amount)
+		lines = append(lines, line)
+	}
+
+	issuerName := issuer.FullName
+	if issuerName == "" {
+		issuerName = issuer.Username
+	}
+	return Invoice{
+		ID:          GenerateID(),
+		Number:      strings.TrimSpace(req.Number),
+		IssuerID:    issuer.ID,
+		ToAccountID: account.ID,
+		PayerID:     payer.ID,
+		IssuerName:  issuerName,
+		Lines:       lines,
+		Total:       total,
+		PaidAmount:  decimal.Zero,
+		Outstanding: total,
+		Comment:     strings.TrimSpace(req.Comment),
+		DueDate:     req.DueDate,
+		Status:      "issued",
+		CreatedAt:   now,
+	}, nil
+}
+
+func CheckInvoicePayable(invoice Invoice, amount decimal.Decimal) error {
+	if invoice.Status == "paid" || invoice.Status == "cancelled" {
+		return errInvoiceNotPayable
+	}
+	if !amount.IsPositive() {
+		return errNonPositiveAmount
+	}
+	if amount.GreaterThan(invoice.Outstanding) {
+		return &InvoiceOverpaymentError{InvoiceID: invoice.ID, Outstanding: invoice.Outstanding}
+	}
+	return nil
+}
+
+// ApplyInvoicePayment учитывает оплату в остатке и статусе счета. Просроченный счет остается overdue до полной оплаты.
+func ApplyInvoicePayment(invoice *Invoice, amount decimal.Decimal, now time.Time) {
+	invoice.PaidAmount = invoice.PaidAmount.Add(amount)
+	invoice.Outstanding = invoice.Total.Sub(invoice.PaidAmount)
+	switch {
+	case !invoice.Outstanding.IsPositive():
+		invoice.Status = "paid"
+	case now.After(invoice.DueDate):
+		invoice.Status = "overdue"
+	default:
+		invoice.Status = "partially_paid"
+	}
+}
+
+// PayInvoiceFrom оплачивает счет со счета плательщика; без суммы оплачивается весь остаток.
+func PayInvoiceFrom(invoice Invoice, fromAccountID string, amount *decimal.Decimal, now time.Time) (Invoice, Transaction, error) {
+	from, ok := GetAccount(fromAccountID)
+	if !ok {
+		return Invoice{}, Transaction{}, fmt.Errorf("account %s %w", fromAccountID, errNotFound)
+	}
+	to, ok := GetAccount(invoice.ToAccountID)
+	if !ok {
+		return Invoice{}, Transaction{}, fmt.Errorf("account %s %w", invoice.ToAccountID, errNotFound)
+	}
+	if from.ID == to.ID {
+		return Invoice{}, Transaction{}, errSameAccount
+	}
+
+	value := invoice.Outstanding
+	if amount != nil {
+		value = *amount
+	}
+	description := fmt.Sprintf("Payment of invoice %s", invoice.ID)
+	if invoice.Number != "" {
+		description = fmt.Sprintf("Payment of invoice No. %s", invoice.Number)
+	}
+	tx := NewTransferTransaction(from, to, value, description)
+	tx.Timestamp = now
+	txs, _, err := ChargeFee(tx, "transfer", "")
+	if err != nil {
+		return Invoice{}, Transaction{}, err
+	}
+	invoice, err = PayInvoice(invoice.ID, txs, now)
+	if err != nil {
+		return Invoice{}, Transaction{}, err
+	}
+	return invoice, txs[0], nil
+}
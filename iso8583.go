@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// isoFieldSpec описывает поле сообщения ISO 8583. Все поля кодируются в ASCII,
+// кроме двоичных (b), длина которых задается в байтах.
+type isoFieldSpec struct {
+	Name    string
+	Type    string // n — цифры, an/ans — символы, b — двоичные данные
+	Prefix  int    // 0 — фиксированная длина, 2 — LLVAR, 3 — LLLVAR
+	MaxSize int
+}
+
+// isoFields — поддерживаемое подмножество полей ISO 8583:1987.
+var isoFields = map[int]isoFieldSpec{
+	2:   {Name: "Primary account number", Type: "n", Prefix: 2, MaxSize: 19},
+	3:   {Name: "Processing code", Type: "n", MaxSize: 6},
+	4:   {Name: "Amount, transaction", Type: "n", MaxSize: 12},
+	7:   {Name: "Transmission date and time", Type: "n", MaxSize: 10},
+	11:  {Name: "System trace audit number", Type: "n", MaxSize: 6},
+	12:  {Name: "Time, local transaction", Type: "n", MaxSize: 6},
+	13:  {Name: "Date, local transaction", Type: "n", MaxSize: 4},
+	14:  {Name: "Date, expiration", Type: "n", MaxSize: 4},
+	18:  {Name: "Merchant category code", Type: "n", MaxSize: 4},
+	22:  {Name: "POS entry mode", Type: "n", MaxSize: 3},
+	32:  {Name: "Acquiring institution ID", Type: "n", Prefix: 2, MaxSize: 11},
+	37:  {Name: "Retrieval reference number", Type: "an", MaxSize: 12},
+	38:  {Name: "Authorization ID response", Type: "an", MaxSize: 6},
+	39:  {Name: "Response code", Type: "an", MaxSize: 2},
+	41:  {Name: "Card acceptor terminal ID", Type: "ans", MaxSize: 8},
+	42:  {Name: "Card acceptor ID code", Type: "ans", MaxSize: 15},
+	43:  {Name: "Card acceptor name/location", Type: "ans", MaxSize: 40},
+	48:  {Name: "Additional data (CVV2)", Type: "ans", Prefix: 3, MaxSize: 999},
+	49:  {Name: "Currency code, transaction", Type: "n", MaxSize: 3},
+	52:  {Name: "PIN data", Type: "b", MaxSize: 8},
+	64:  {Name: "Message authentication code", Type: "b", MaxSize: 8},
+	70:  {Name: "Network management information code", Type: "n", MaxSize: 3},
+	90:  {Name: "Original data elements", Type: "n", MaxSize: 42},
+	128: {Name: "Message authentication code", Type: "b", MaxSize: 8},
+}
+
+// ISOMessage — сообщение ISO 8583: MTI и значения полей по номерам.
+type ISOMessage struct {
+	MTI    string
+	Fields map[int]string
+}
+
+func NewISOMessage(mti string) *ISOMessage {
+	return &ISOMessage{MTI: mti, Fields: make(map[int]string)}
+}
+
+func (m *ISOMessage) Set(field int, value string) {
+	m.Fields[field] = value
+}
+
+func (m *ISOMessage) Get(field int) string {
+	return m.Fields[field]
+}
+
+func (m *ISOMessage) Has(field int) bool {
+	_, ok := m.Fields[field]
+	return ok
+}
+
+// Pack кодирует сообщение: MTI, первичный (и при необходимости вторичный) битмап в двоичном виде, затем поля по возрастанию номеров.
+func (m *ISOMessage) Pack() ([]byte, error) {
+	if len(m.MTI) != 4 || !isDigits(m.MTI) {
+		return nil, fmt.Errorf("invalid MTI '%s'", m.MTI)
+	}
+
+	numbers := make([]int, 0, len(m.Fields))
+	secondary := false
+	for n := range m.Fields {
+		if _, ok := isoFields[n]; !ok {
+			return nil, fmt.Errorf("field %d is not supported", n)
+		}
+		if n > 64 {
+			secondary = true
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	bitmap := make([]byte, 8)
+	if secondary {
+		bitmap = make([]byte, 16)
+		bitmap[0] |= 0x80
+	}
+	for _, n := range numbers {
+		bitmap[(n-1)/8] |= 0x80 >> uint((n-1)%8)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(m.MTI)
+	buf.Write(bitmap)
+	for _, n := range numbers {
+		encoded, err := packISOField(n, m.Fields[n])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encoded)
+	}
+	return buf.Bytes(), nil
+}
+
+func packISOField(n int, value string) ([]byte, error) {
+	spec := isoFields[n]
+	if spec.Type == "n" && !isDigits(value) {
+		return nil, fmt.Errorf("field %d (%s) must be numeric", n, spec.Name)
+	}
+	if len(value) > spec.MaxSize {
+		return nil, fmt.Errorf("field %d (%s) is longer than %d", n, spec.Name, spec.MaxSize)
+	}
+
+	switch spec.Prefix {
+	case 0:
+		if len(value) != spec.MaxSize {
+			if spec.Type != "n" && spec.Type != "b" {
+				value += strings.Repeat(" ", spec.MaxSize-len(value))
+			} else if spec.Type == "n" {
+				value = strings.Repeat("0", spec.MaxSize-len(value)) + value
+			} else {
+				return nil, fmt.Errorf("field %d (%s) must be %d bytes", n, spec.Name, spec.MaxSize)
+			}
+		}
+		return []byte(value), nil
+	default:
+		return []byte(fmt.Sprintf("%0*d%s", spec.Prefix, len(value), value)), nil
+	}
+}
+
+// UnpackISOMessage разбирает сообщение, закодированное Pack.
+func UnpackISOMessage(data []byte) (*ISOMessage, error) {
+	r := bytes.NewReader(data)
+	mti := make([]byte, 4)
+	if _, err := io.ReadFull(r, mti); err != nil || !isDigits(string(mti)) {
+		return nil, fmt.Errorf("invalid MTI")
+	}
+	bitmap := make([]byte, 8)
+	if _, err := io.ReadFull(r, bitmap); err != nil {
+		return nil, fmt.Errorf("invalid primary bitmap")
+	}
+	if bitmap[0]&0x80 != 0 {
+		secondary := make([]byte, 8)
+		if _, err := io.ReadFull(r, secondary); err != nil {
+			return nil, fmt.Errorf("invalid secondary bitmap")
+		}
+		bitmap = append(bitmap, secondary...)
+	}
+
+	m := NewISOMessage(string(mti))
+	for n := 2; n <= len(bitmap)*8; n++ {
+		if bitmap[(n-1)/8]&(0x80>>uint((n-1)%8)) == 0 {
+			continue
+		}
+		spec, ok := isoFields[n]
+		if !ok {
+			return nil, fmt.Errorf("field %d is not supported", n)
+		}
+
+		size := spec.MaxSize
+		if spec.Prefix > 0 {
+			prefix := make([]byte, spec.Prefix)
+			if _, err := io.ReadFull(r, prefix); err != nil {
+				return nil, fmt.Errorf("field %d: missing length prefix", n)
+			}
+			l, err := strconv.Atoi(string(prefix))
+			if err != nil || l > spec.MaxSize {
+				return nil, fmt.Errorf("field %d: invalid length '%s'", n, prefix)
+			}
+			size = l
+		}
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, fmt.Errorf("field %d: unexpected end of message", n)
+		}
+		if spec.Type == "n" && !isDigits(string(value)) {
+			return nil, fmt.Errorf("field %d (%s) must be numeric", n, spec.Name)
+		}
+		if spec.Type == "ans" || spec.Type == "an" {
+			value = bytes.TrimRight(value, " ")
+		}
+		m.Fields[n] = string(value)
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("%d unexpected trailing bytes", r.Len())
+	}
+	return m, nil
+}
+
+// isoMACField — поле MAC: 64 в сообщениях без вторичного битмапа, 128 — со вторичным.
+// В обоих случаях MAC упаковывается последним полем сообщения.
+func isoMACField(m *ISOMessage) int {
+	for n := range m.Fields {
+		if n > 64 && n != 128 {
+			return 128
+		}
+	}
+	return 64
+}
+
+// isoMAC — первые 8 байт HMAC-SHA256 упакованного сообщения без значения MAC.
+func isoMAC(packed []byte, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(packed[:len(packed)-8])
+	return mac.Sum(nil)[:8]
+}
+
+// PackSignedISOMessage упаковывает сообщение с MAC в поле 64 или 128.
+func PackSignedISOMessage(m *ISOMessage, key []byte) ([]byte, error) {
+	delete(m.Fields, 64)
+	delete(m.Fields, 128)
+	field := isoMACField(m)
+	m.Set(field, string(make([]byte, 8)))
+	data, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	mac := isoMAC(data, key)
+	copy(data[len(data)-8:], mac)
+	m.Set(field, string(mac))
+	return data, nil
+}
+
+// VerifyISOMAC проверяет MAC сообщения m, разобранного из data.
+func VerifyISOMAC(data []byte, m *ISOMessage, key []byte) error {
+	field := isoMACField(m)
+	if !m.Has(field) {
+		return fmt.Errorf("MAC (field %d) is missing", field)
+	}
+	if !hmac.Equal([]byte(m.Get(field)), isoMAC(data, key)) {
+		return fmt.Errorf("MAC (field %d) does not match", field)
+	}
+	return nil
+}
+
+// Сообщения передаются по TCP с двухбайтовым заголовком длины (big-endian).
+func ReadISOFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func WriteISOFrame(w io.Writer, data []byte) error {
+	if len(data) > 0xFFFF {
+		return fmt.Errorf("message is too long")
+	}
+	header := make([]byte, 2)
+	binary.BigEndian.PutUint16(header, uint16(len(data)))
+	_, err := w.Write(append(header, data...))
+	return err
+}
+
+// EncodePINBlock формирует PIN-блок формата ISO 9564-0 (PIN XOR 12 цифр PAN без контрольной).
+func EncodePINBlock(pin, pan string) ([]byte, error) {
+	if len(pin) < 4 || len(pin) > 12 || !isDigits(pin) {
+		return nil, fmt.Errorf("invalid PIN")
+	}
+	if len(pan) < 13 || !isDigits(pan) {
+		return nil, fmt.Errorf("invalid PAN")
+	}
+	pinField := fmt.Sprintf("0%X%s", len(pin), pin) + strings.Repeat("F", 14-len(pin))
+	panField := "0000" + pan[len(pan)-13:len(pan)-1]
+
+	block := make([]byte, 8)
+	for i := 0; i < 8; i++ {
+		p, _ := strconv.ParseUint(pinField[i*2:i*2+2], 16, 8)
+		a, _ := strconv.ParseUint(panField[i*2:i*2+2], 16, 8)
+		block[i] = byte(p) ^ byte(a)
+	}
+	return block, nil
+}
+
+func DecodePINBlock(block []byte, pan string) (string, error) {
+	if len(block) != 8 || len(pan) < 13 || !isDigits(pan) {
+		return "", fmt.Errorf("invalid PIN block")
+	}
+	panField := "0000" + pan[len(pan)-13:len(pan)-1]
+	var pinField strings.Builder
+	for i := 0; i < 8; i++ {
+		a, _ := strconv.ParseUint(panField[i*2:i*2+2], 16, 8)
+		fmt.Fprintf(&pinField, "%02X", block[i]^byte(a))
+	}
+	field := pinField.String()
+	if field[0] != '0' {
+		return "", fmt.Errorf("unsupported PIN block format")
+	}
+	length, err := strconv.ParseUint(field[1:2], 16, 8)
+	if err != nil || length < 4 || length > 12 {
+		return "", fmt.Errorf("invalid PIN block")
+	}
+	pin := field[2 : 2+length]
+	if !isDigits(pin) {
+		return "", fmt.Errorf("invalid PIN block")
+	}
+	return pin, nil
+}
+
+func isDigits(s string) bool {
+	for _, ch := range s {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
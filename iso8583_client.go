@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// runISO8583Client — локальный тестовый клиент шлюза: go run . iso8583-client -type purchase -pan ... -amount 100
+func runISO8583Client(args []string) int {
+	fs := flag.NewFlagSet("iso8583-client", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8583", "gateway address")
+	msgType := fs.String("type", "purchase", "auth | purchase | reversal | echo")
+	pan := fs.String("pan", "", "card number")
+	expiry := fs.String("expiry", "", "card expiry YYMM")
+	cvv := fs.String("cvv", "", "CVV2")
+	pin := fs.String("pin", "", "PIN (sent as ISO 9564 format 0 PIN block)")
+	amount := fs.String("amount", "", "amount in rubles, e.g. 150.50")
+	merchant := fs.String("merchant", "Test shop", "card acceptor name")
+	acceptorID := fs.String("acceptor-id", "", "merchant acceptor ID (field 42)")
+	terminal := fs.String("terminal", "TERM0001", "terminal ID")
+	rrn := fs.String("rrn", "", "retrieval reference number (required for reversal)")
+	macKey := fs.String("mac-key", os.Getenv("BANKAPP_ISO8583_MAC_KEY"), "shared MAC key (fields 64/128)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	now := time.Now()
+	var req *ISOMessage
+	switch *msgType {
+	case "auth", "purchase":
+		req = NewISOMessage("0200")
+		if *msgType == "auth" {
+			req.MTI = "0100"
+		}
+		req.Set(3, "000000")
+		req.Set(22, "012") // ручной ввод
+		if *pin != "" {
+			req.Set(22, "051") // чип с вводом PIN
+		}
+	case "reversal":
+		req = NewISOMessage("0400")
+		req.Set(3, "000000")
+	case "echo":
+		req = NewISOMessage("0800")
+		req.Set(70, "301")
+	default:
+		fmt.Fprintf(os.Stderr, "unknown message type %s\n", *msgType)
+		return 2
+	}
+
+	req.Set(7, now.UTC().Format("0102150405"))
+	req.Set(11, generateISODigits(6))
+	if *msgType != "echo" {
+		req.Set(12, now.Format("150405"))
+		req.Set(13, now.Format("0102"))
+		req.Set(41, *terminal)
+		req.Set(43, *merchant)
+		if *acceptorID != "" {
+			req.Set(42, *acceptorID)
+		}
+		req.Set(49, "643")
+		if *rrn != "" {
+			req.Set(37, *rrn)
+		}
+		if *pan != "" {
+			req.Set(2, *pan)
+		}
+		if *expiry != "" {
+			req.Set(14, *expiry)
+		}
+		if *cvv != "" {
+			req.Set(48, *cvv)
+		}
+		if *amount != "" {
+			value, err := decimal.NewFromString(*amount)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid amount: %v\n", err)
+				return 2
+			}
+			req.Set(4, value.Shift(2).Round(0).String())
+		}
+		if *pin != "" {
+			block, err := EncodePINBlock(*pin, *pan)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to build PIN block: %v\n", err)
+				return 2
+			}
+			req.Set(52, string(block))
+		}
+	}
+
+	resp, err := sendISOMessage(*addr, req, []byte(*macKey))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "request failed: %v\n", err)
+		return 1
+	}
+	printISOMessage("request", req)
+	printISOMessage("response", resp)
+	if resp.Get(39) != "00" {
+		return 1
+	}
+	return 0
+}
+
+func sendISOMessage(addr string, req *ISOMessage, macKey []byte) (*ISOMessage, error) {
+	var data []byte
+	var err error
+	if len(macKey) > 0 {
+		data, err = PackSignedISOMessage(req, macKey)
+	} else {
+		data, err = req.Pack()
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	if err := WriteISOFrame(conn, data); err != nil {
+		return nil, err
+	}
+	frame, err := ReadISOFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := UnpackISOMessage(frame)
+	if err != nil {
+		return nil, err
+	}
+	if len(macKey) > 0 {
+		if err := VerifyISOMAC(frame, resp, macKey); err != nil {
+			return nil, fmt.Errorf("response rejected: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+func printISOMessage(title string, m *ISOMessage) {
+	fmt.Printf("%s MTI %s\n", title, m.MTI)
+	numbers := make([]int, 0, len(m.Fields))
+	for n := range m.Fields {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	for _, n := range numbers {
+		value := m.Fields[n]
+		switch n {
+		case 2:
+			value = MaskCardNumber(value)
+		case 48, 52, 64, 128:
+			value = "***"
+		}
+		fmt.Printf("  %3d %-38s %s\n", n, isoFields[n].Name, value)
+	}
+}
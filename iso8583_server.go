@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var isoConfig = struct {
+	Addr         string // "off" отключает шлюз; для POS-симулятора — 127.0.0.1:8583
+	MACKey       []byte // общий ключ MAC (поле 64/128); если задан, сообщения без верного MAC отклоняются
+	AllowedPeers string // IP-адреса процессинга через запятую
+	IdleTimeout  time.Duration
+}{
+	Addr:         envOrDefault("BANKAPP_ISO8583_ADDR", "off"),
+	MACKey:       []byte(os.Getenv("BANKAPP_ISO8583_MAC_KEY")),
+	AllowedPeers: os.Getenv("BANKAPP_ISO8583_ALLOWED_PEERS"),
+	IdleTimeout:  5 * time.Minute,
+}
+
+// isoCardPresentEntryModes — способы ввода карты (первые две цифры поля 22), при которых карта предъявлена.
+var isoCardPresentEntryModes = map[string]bool{
+	"02": true, // магнитная полоса
+	"05": true, // чип
+	"07": true, // бесконтактный чип
+	"90": true, // полный трек магнитной полосы
+	"91": true, // бесконтактная магнитная полоса
+}
+
+// isoEchoFields копируются из запроса в ответ.
+var isoEchoFields = []int{2, 3, 4, 7, 11, 12, 13, 32, 37, 41, 42, 49, 70}
+
+// StartISO8583Server принимает TCP-соединения процессинга или POS-симулятора.
+// Каждое сообщение предваряется двухбайтовой длиной, ответы идут в том же соединении.
+func StartISO8583Server(addr string) {
+	if addr == "off" {
+		log.Println("ISO 8583 gateway is disabled")
+		return
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("ISO 8583 gateway failed to start: %v", err)
+		return
+	}
+	log.Printf("ISO 8583 gateway listening on %s", addr)
+	if len(isoConfig.MACKey) == 0 && isoConfig.AllowedPeers == "" {
+		log.Println("BANKAPP_ISO8583_MAC_KEY and BANKAPP_ISO8583_ALLOWED_PEERS are not set, ISO 8583 gateway accepts only local connections")
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("ISO 8583 accept failed: %v", err)
+			continue
+		}
+		if !isoPeerAllowed(conn.RemoteAddr()) {
+			log.Printf("ISO 8583 connection from %s rejected: peer is not allowed", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go serveISOConnection(conn)
+	}
+}
+
+// isoPeerAllowed пропускает адреса из BANKAPP_ISO8583_ALLOWED_PEERS. Без списка пропускаются любые адреса,
+// если сообщения защищены MAC, а без MAC — только локальные соединения.
+func isoPeerAllowed(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if isoConfig.AllowedPeers == "" {
+		return len(isoConfig.MACKey) > 0 || ip.IsLoopback()
+	}
+	for _, peer := range strings.Split(isoConfig.AllowedPeers, ",") {
+		if allowed := net.ParseIP(strings.TrimSpace(peer)); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func serveISOConnection(conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetReadDeadline(time.Now().Add(isoConfig.IdleTimeout))
+		frame, err := ReadISOFrame(conn)
+		if err != nil {
+			return
+		}
+
+		req, err := UnpackISOMessage(frame)
+		if err != nil {
+			log.Printf("ISO 8583 message from %s rejected: %v", conn.RemoteAddr(), err)
+			return
+		}
+		var resp *ISOMessage
+		if len(isoConfig.MACKey) > 0 {
+			if err := VerifyISOMAC(frame, req, isoConfig.MACKey); err != nil {
+				log.Printf("ISO 8583 %s from %s rejected: %v", req.MTI, conn.RemoteAddr(), err)
+				resp = newISOResponse(req)
+				resp.Set(39, "63") // нарушение безопасности
+			}
+		}
+		if resp == nil {
+			resp = HandleISOMessage(req)
+		}
+		var data []byte
+		if len(isoConfig.MACKey) > 0 {
+			data, err = PackSignedISOMessage(resp, isoConfig.MACKey)
+		} else {
+			data, err = resp.Pack()
+		}
+		if err != nil {
+			log.Printf("Failed to pack ISO 8583 response %s: %v", resp.MTI, err)
+			return
+		}
+		if err := WriteISOFrame(conn, data); err != nil {
+			return
+		}
+	}
+}
+
+// newISOResponse создает ответ на запрос с полями, копируемыми из запроса.
+func newISOResponse(req *ISOMessage) *ISOMessage {
+	resp := NewISOMessage(isoResponseMTI(req.MTI))
+	for _, n := range isoEchoFields {
+		if req.Has(n) {
+			resp.Set(n, req.Get(n))
+		}
+	}
+	return resp
+}
+
+// HandleISOMessage обрабатывает запрос и формирует ответ с кодом в поле 39.
+func HandleISOMessage(req *ISOMessage) *ISOMessage {
+	resp := newISOResponse(req)
+
+	var code string
+	switch req.MTI {
+	case "0100", "0101":
+		code = processISOCardPayment(req, resp, false)
+	case "0200", "0201":
+		code = processISOCardPayment(req, resp, true)
+	case "0400", "0401":
+		code = processISOReversal(req)
+	case "0800":
+		code = processISONetworkManagement(req)
+	default:
+		code = "12"
+	}
+	resp.Set(39, code)
+
+	pan := ""
+	if req.Has(2) {
+		pan = MaskCardNumber(req.Get(2))
+	}
+	log.Printf("ISO 8583 %s STAN %s card %s -> %s %s", req.MTI, req.Get(11), pan, resp.MTI, code)
+	return resp
+}
+
+func isoResponseMTI(mti string) string {
+	if len(mti) != 4 || mti[2] == '9' {
+		return mti
+	}
+	return mti[:2] + string(mti[2]+1) + mti[3:]
+}
+
+func isoPaymentKey(req *ISOMessage) string {
+	return req.Get(41) + ":" + req.Get(37)
+}
+
+// processISOCardPayment авторизует (0100) или проводит (0200) оплату картой той же логикой, что и POST /payments/card.
+func processISOCardPayment(req *ISOMessage, resp *ISOMessage, capture bool) string {
+	if !strings.HasPrefix(req.Get(3), "00") {
+		return "12" // поддерживается только покупка
+	}
+	if !req.Has(2) || !req.Has(4) || !req.Has(11) || !req.Has(42) {
+		return "30"
+	}
+	minor, err := strconv.ParseInt(req.Get(4), 10, 64)
+	if err != nil || minor <= 0 {
+		return "13"
+	}
+
+	merchant, ok := GetMerchantByAcceptorID(req.Get(42))
+	if !ok {
+		return "03"
+	}
+
+	payment := PaymentRequest{
+		CardNumber: req.Get(2),
+		Amount:     decimal.New(minor, -2),
+		CVV:        req.Get(48),
+		MerchantID: merchant.ID,
+		// PIN без CVV принимается только при чтении карты терминалом: чип, магнитная полоса или бесконтакт
+		CardPresent: len(req.Get(22)) == 3 && isoCardPresentEntryModes[req.Get(22)[:2]],
+	}
+	if expiry := req.Get(14); len(expiry) == 4 {
+		payment.ExpiryYear, _ = strconv.Atoi("20" + expiry[:2])
+		payment.ExpiryMonth, _ = strconv.Atoi(expiry[2:])
+	}
+	if req.Has(52) {
+		pin, err := DecodePINBlock([]byte(req.Get(52)), payment.CardNumber)
+		if err != nil {
+			return "55"
+		}
+		payment.PIN = pin
+	}
+
+	tx, err := AuthorizeCardPayment(payment, false)
+	if err == nil && capture {
+		if !req.Has(37) {
+			resp.Set(37, generateISODigits(12))
+			req.Set(37, resp.Get(37))
+		}
+		ref := CardPaymentRef{
+			Key:           isoPaymentKey(req),
+			TransactionID: tx.ID,
+			AccountID:     tx.FromAccountID,
+			CardID:        tx.CardID,
+			MerchantID:    tx.MerchantID,
+			Amount:        tx.Amount,
+			AuthCode:      generateISODigits(6),
+			CreatedAt:     time.Now(),
+		}
+		var txs []Transaction
+		if txs, _, err = ChargeFee(tx, "card_payment", ""); err == nil {
+			err = PostCardPayment(ref, txs...)
+		}
+		if err == nil {
+			resp.Set(38, ref.AuthCode)
+		}
+	} else if err == nil {
+		resp.Set(38, generateISODigits(6))
+	}
+	return isoResponseCode(err)
+}
+
+func processISOReversal(req *ISOMessage) string {
+	if !req.Has(37) || !req.Has(41) {
+		return "30"
+	}
+	if _, err := ReverseCardPayment(isoPaymentKey(req), time.Now()); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return "25"
+		}
+		return isoResponseCode(err)
+	}
+	return "00"
+}
+
+func processISONetworkManagement(req *ISOMessage) string {
+	switch req.Get(70) {
+	case "001", "002", "301": // sign-on, sign-off, echo test
+		return "00"
+	default:
+		return "12"
+	}
+}
+
+// isoResponseCode переводит ошибку авторизации в код ответа ISO 8583 (поле 39).
+func isoResponseCode(err error) string {
+	if err == nil {
+		return "00"
+	}
+	var insufficient *InsufficientFundsError
+	var statusErr *AccountStatusError
+	var limitErr *LimitExceededError
+	var cardErr *CardStatusError
+	var verifyErr *CardVerificationError
+	var refundErr *RefundExceedsPaymentError
+	switch {
+	case errors.Is(err, errNonPositiveAmount):
+		return "13"
+	case errors.Is(err, errInvalidCardNumber), errors.Is(err, errCardNotFound):
+		return "14"
+	case errors.Is(err, errCardDataRequired):
+		return "30"
+	case errors.Is(err, errDuplicateCardPayment):
+		return "94"
+	case errors.Is(err, errMerchantNotFound), errors.Is(err, errMerchantInactive):
+		return "03"
+	case errors.Is(err, errPINNotSet):
+		return "55"
+	case errors.As(err, &verifyErr):
+		if verifyErr.Method == "pin" {
+			if verifyErr.AttemptsLeft == 0 {
+				return "75"
+			}
+			return "55"
+		}
+		return "N7"
+	case errors.As(err, &cardErr):
+		switch cardErr.Status {
+		case "expired":
+			return "54"
+		case "blocked", "security_blocked":
+			return "62"
+		case "permanently_blocked":
+			return "41"
+		default:
+			return "57"
+		}
+	case errors.As(err, &refundErr):
+		return "12" // оплата уже полностью возвращена
+	case errors.As(err, &limitErr):
+		return "61"
+	case errors.As(err, &insufficient):
+		return "51"
+	case errors.As(err, &statusErr):
+		return "57"
+	default:
+		log.Printf("ISO 8583 authorization failed: %v", err)
+		return "96"
+	}
+}
+
+func generateISODigits(n int) string {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	v, _ := rand.Int(rand.Reader, max)
+	return fmt.Sprintf("%0*d", n, v)
+}
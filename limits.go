@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var limitCategories = []string{"card_payment", "transfer", "cash"}
+
+// limitCategoryByType относит тип транзакции к категории лимитов. Не перечисленные типы лимитами не ограничиваются.
+var limitCategoryByType = map[string]string{
+	"payment":      "card_payment",
+	"transfer":     "transfer",
+	"sbp_outgoing": "transfer",
+	"qr_payment":   "transfer",
+	"bill_payment": "transfer",
+	"withdrawal":   "cash",
+}
+
+// limitReversalCategoryByType — зачисления, которые отменяют ранее учтенные в лимите операции.
+var limitReversalCategoryByType = map[string]string{
+	"payment_reversal":    "card_payment",
+	"bill_payment_refund": "transfer",
+	"withdrawal_reversal": "cash",
+	"sbp_refund":          "transfer", // только сумма перевода: комиссия возвращается проводкой sbp_fee_refund
+}
+
+// limitReversalCategory возвращает категорию лимита, которую восстанавливает зачисление. Возврат мерчанта
+// относится к оплате картой, если привязан к карте, и к оплате по QR-коду — если нет.
+func limitReversalCategory(tx Transaction) string {
+	if tx.TransactionType == "refund" {
+		if tx.CardID != "" {
+			return "card_payment"
+		}
+		return "transfer"
+	}
+	return limitReversalCategoryByType[tx.TransactionType]
+}
+
+func limitsFor(perTransaction, daily, monthly int64) OperationLimits {
+	return OperationLimits{
+		PerTransaction: decimal.NewFromInt(perTransaction),
+		Daily:          decimal.NewFromInt(daily),
+		Monthly:        decimal.NewFromInt(monthly),
+	}
+}
+
+// defaultLimits — лимиты банка по умолчанию; клиент может только понизить их.
+var defaultLimits = map[string]map[string]OperationLimits{
+	"card": {
+		"card_payment": limitsFor(300000, 500000, 3000000),
+		"cash":         limitsFor(100000, 300000, 2000000),
+	},
+	"account": {
+		"card_payment": limitsFor(500000, 1000000, 5000000),
+		"transfer":     limitsFor(1000000, 3000000, 10000000),
+		"cash":         limitsFor(200000, 500000, 3000000),
+	},
+	"user": {
+		"card_payment": limitsFor(1000000, 2000000, 10000000),
+		"transfer":     limitsFor(2000000, 5000000, 20000000),
+		"cash":         limitsFor(300000, 1000000, 5000000),
+	},
+}
+
+func limitKey(scope, ownerID string) string {
+	return scope + ":" + ownerID
+}
+
+func effectiveLimitsLocked(scope, ownerID, category string) (OperationLimits, bool) {
+	if custom, ok := storage.customLimits[limitKey(scope, ownerID)][category]; ok {
+		return custom, true
+	}
+	limits, ok := defaultLimits[scope][category]
+	return limits, ok
+}
+
+// limitUsageLocked суммирует операции категории за день и месяц по владельцу лимита.
+func limitUsageLocked(scope, ownerID, category string, now time.Time) (daily, monthly decimal.Decimal) {
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	accountIDs := map[string]bool{}
+	switch scope {
+	case "card":
+		if card, ok := storage.cards[ownerID]; ok {
+			accountIDs[card.AccountID] = true
+		}
+	case "account":
+		accountIDs[ownerID] = true
+	case "user":
+		for _, id := range storage.accountIndex[ownerID] {
+			accountIDs[id] = true
+		}
+	}
+
+	daily, monthly = decimal.Zero, decimal.Zero
+	for accountID := range accountIDs {
+		// история идет по времени, поэтому читаем ее с конца и останавливаемся на начале месяца
+		positions := storage.accountTxIndex[accountID]
+		for i := len(positions) - 1; i >= 0; i-- {
+			tx := storage.transactions[positions[i]]
+			if tx.Timestamp.Before(startOfMonth) {
+				break
+			}
+			amount := tx.Amount
+			switch {
+			case tx.FromAccountID == accountID && limitCategoryByType[tx.TransactionType] == category:
+			case tx.ToAccountID == accountID && limitReversalCategory(tx) == category:
+				// Отмененная операция возвращает использованный лимит.
+				amount = amount.Neg()
+			default:
+				continue
+			}
+			if scope == "card" && tx.CardID != ownerID {
+				continue
+			}
+			monthly = monthly.Add(amount)
+			if !tx.Timestamp.Before(startOfDay) {
+				daily = daily.Add(amount)
+			}
+		}
+	}
+	return daily, monthly
+}
+
+func checkLimitLocked(scope, ownerID, category string, amount decimal.Decimal, now time.Time) error {
+	limits, ok := effectiveLimitsLocked(scope, ownerID, category)
+	if !ok {
+		return nil
+	}
+	if amount.GreaterThan(limits.PerTransaction) {
+		return &LimitExceededError{Scope: scope, OwnerID: ownerID, Category: category, Period: "transaction",
+			Limit: limits.PerTransaction, Remaining: limits.PerTransaction}
+	}
+	daily, monthly := limitUsageLocked(scope, ownerID, category, now)
+	if daily.Add(amount).GreaterThan(limits.Daily) {
+		return &LimitExceededError{Scope: scope, OwnerID: ownerID, Category: category, Period: "daily",
+			Limit: limits.Daily, Remaining: decimal.Max(limits.Daily.Sub(daily), decimal.Zero)}
+	}
+	if monthly.Add(amount).GreaterThan(limits.Monthly) {
+		return &LimitExceededError{Scope: scope, OwnerID: ownerID, Category: category, Period: "monthly",
+			Limit: limits.Monthly, Remaining: decimal.Max(limits.Monthly.Sub(monthly), decimal.Zero)}
+	}
+	return nil
+}
+
+// checkTransactionLimitsLocked проверяет лимиты карты, счета и клиента для списания.
+func checkTransactionLimitsLocked(tx Transaction, from Account) error {
+	category, ok := limitCategoryByType[tx.TransactionType]
+	if !ok {
+		return nil
+	}
+	now := tx.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if tx.CardID != "" {
+		if err := checkCardSpendingLimitLocked(tx); err != nil {
+			return err
+		}
+		if err := checkLimitLocked("card", tx.CardID, category, tx.Amount, now); err != nil {
+			return err
+		}
+	}
+	if err := checkLimitLocked("account", from.ID, category, tx.Amount, now); err != nil {
+		return err
+	}
+	return checkLimitLocked("user", from.UserID, category, tx.Amount, now)
+}
+
+// checkCardSpendingLimitLocked проверяет собственный лимит трат виртуальной или одноразовой карты за весь срок ее действия.
+func checkCardSpendingLimitLocked(tx Transaction) error {
+	card, ok := storage.cards[tx.CardID]
+	if !ok || card.SpendingLimit == nil {
+		return nil
+	}
+	spent := decimal.Zero
+	for _, t := range accountTransactionsLocked(card.AccountID) {
+		if t.CardID != card.ID {
+			continue
+		}
+		if t.FromAccountID == card.AccountID {
+			spent = spent.Add(t.Amount)
+		} else if t.TransactionType == "payment_reversal" || t.TransactionType == "refund" {
+			spent = spent.Sub(t.Amount)
+		}
+	}
+	if spent.Add(tx.Amount).GreaterThan(*card.SpendingLimit) {
+		return &LimitExceededError{Scope: "card", OwnerID: card.ID, Category: "spending_limit", Period: "lifetime",
+			Limit: *card.SpendingLimit, Remaining: decimal.Max(card.SpendingLimit.Sub(spent), decimal.Zero)}
+	}
+	return nil
+}
+
+// LimitsView возвращает действующие лимиты владельца с остатком на сегодня и текущий месяц.
+func LimitsView(scope, ownerID string) []LimitStatus {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+
+	now := time.Now()
+	var result []LimitStatus
+	for _, category := range limitCategories {
+		limits, ok := effectiveLimitsLocked(scope, ownerID, category)
+		if !ok {
+			continue
+		}
+		daily, monthly := limitUsageLocked(scope, ownerID, category, now)
+		_, custom := storage.customLimits[limitKey(scope, ownerID)][category]
+		result = append(result, LimitStatus{
+			Category:         category,
+			Limits:           limits,
+			Default:          defaultLimits[scope][category],
+			Custom:           custom,
+			UsedToday:        daily,
+			UsedThisMonth:    monthly,
+			RemainingToday:   decimal.Max(limits.Daily.Sub(daily), decimal.Zero),
+			RemainingMonthly: decimal.Max(limits.Monthly.Sub(monthly), decimal.Zero),
+		})
+	}
+	return result
+}
+
+func validateLimitOwner(scope, ownerID string) error {
+	var exists bool
+	switch scope {
+	case "card":
+		_, exists = GetCard(ownerID)
+	case "account":
+		_, exists = GetAccount(ownerID)
+	case "user":
+		_, exists = GetUser(ownerID)
+	default:
+		return fmt.Errorf("unknown limit scope '%s'", scope)
+	}
+	if !exists {
+		return fmt.Errorf("%s %s not found", scope, ownerID)
+	}
+	return nil
+}
@@ -10,13 +10,34 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "iso8583-client" {
+		os.Exit(runISO8583Client(os.Args[2:]))
+	}
+
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
 	log.Println("Starting Simple Bank API...")
 
+	InitCardKeys()
 	InitStorage()
 	log.Println("In-memory storage initialized.")
+	InitSBPConnector()
+	InitBillConnectors()
+	InitFeeRules()
+	InitCashbackRules()
+
+	go runPeriodically("term deposits", time.Hour, ProcessTermDeposits)
+	go runPeriodically("overdraft interest", 24*time.Hour, AccrueOverdraftInterest)
+	go runPeriodically("standing orders", time.Minute, ProcessStandingOrders)
+	go runPeriodically("card expiry", 24*time.Hour, ExpireCards)
+	go runPeriodically("merchant settlement", 24*time.Hour, SettleMerchants)
+	go runPeriodically("QR payment expiry", time.Minute, ExpireQRPayments)
+	go runPeriodically("money request expiry", time.Hour, ExpireMoneyRequests)
+	go runPeriodically("overdue invoices", time.Hour, MarkOverdueInvoices)
+	go runPeriodically("cashback payout", 24*time.Hour, PayoutMonthlyCashback)
+	go runPeriodically("bill payment refunds", time.Hour, RetryBillPaymentRefunds)
+	go StartISO8583Server(isoConfig.Addr)
 
 	r := mux.NewRouter()
 
@@ -25,13 +46,107 @@ func main() {
 
 	r.HandleFunc("/accounts", CreateAccountHandler).Methods("POST")
 	r.HandleFunc("/users/{userId}/accounts", GetUserAccountsHandler).Methods("GET")
+	r.HandleFunc("/users/{userId}/default-account", SetDefaultAccountHandler).Methods("PUT")
+	r.HandleFunc("/users/{userId}/tier", requireOperator(SetUserTierHandler)).Methods("PUT")
+	r.HandleFunc("/accounts/{accountId}", CloseAccountHandler).Methods("DELETE")
+	r.HandleFunc("/accounts/{accountId}/overdraft", requireOperator(SetOverdraftHandler)).Methods("PUT")
+	r.HandleFunc("/accounts/{accountId}/freeze", requireOperator(FreezeAccountHandler)).Methods("POST")
+	r.HandleFunc("/accounts/{accountId}/unfreeze", requireOperator(UnfreezeAccountHandler)).Methods("POST")
 
 	r.HandleFunc("/cards", GenerateCardHandler).Methods("POST")
 	r.HandleFunc("/accounts/{accountId}/cards", GetAccountCardsHandler).Methods("GET")
+	r.HandleFunc("/accounts/{accountId}/statement", GetAccountStatementHandler).Methods("GET")
+	r.HandleFunc("/cards/{cardId}/block", BlockCardHandler).Methods("POST")
+	r.HandleFunc("/cards/{cardId}/unblock", UnblockCardHandler).Methods("POST")
+	r.HandleFunc("/cards/{cardId}/security-unblock", requireOperator(SecurityUnblockCardHandler)).Methods("POST")
+	r.HandleFunc("/cards/{cardId}/report-lost", ReportCardLostHandler).Methods("POST")
+	r.HandleFunc("/cards/{cardId}/reissue", ReissueCardHandler).Methods("POST")
+	r.HandleFunc("/cards/{cardId}/pin", SetCardPINHandler).Methods("PUT")
+	r.HandleFunc("/cards/{cardId}/reveal", RequestCardRevealHandler).Methods("POST")
+	r.HandleFunc("/cards/{cardId}/reveal/confirm", ConfirmCardRevealHandler).Methods("POST")
+	r.HandleFunc("/cards/{cardId}/tokens", CreateCardTokenHandler).Methods("POST")
+	r.HandleFunc("/cards/{cardId}/tokens", GetCardTokensHandler).Methods("GET")
+	r.HandleFunc("/cards/{cardId}/tokens/{token}", RevokeCardTokenHandler).Methods("DELETE")
+	r.HandleFunc("/card-keys/rotate", requireOperator(RotateCardKeyHandler)).Methods("POST")
 	r.HandleFunc("/payments/card", PayWithCardHandler).Methods("POST")
 
+	r.HandleFunc("/merchants", requireOperator(CreateMerchantHandler)).Methods("POST")
+	r.HandleFunc("/merchants/{merchantId}", GetMerchantHandler).Methods("GET")
+	r.HandleFunc("/merchants/{merchantId}/suspend", requireOperator(SuspendMerchantHandler)).Methods("POST")
+	r.HandleFunc("/merchants/{merchantId}/activate", requireOperator(ActivateMerchantHandler)).Methods("POST")
+	r.HandleFunc("/merchants/{merchantId}/position", GetMerchantPositionHandler).Methods("GET")
+	r.HandleFunc("/merchants/{merchantId}/refunds", requireMerchant(RefundCardPaymentHandler)).Methods("POST")
+	r.HandleFunc("/merchants/{merchantId}/settle", requireOperator(SettleMerchantHandler)).Methods("POST")
+	r.HandleFunc("/merchants/{merchantId}/settlements", GetMerchantSettlementsHandler).Methods("GET")
+	r.HandleFunc("/settlements/{settlementId}", GetMerchantSettlementHandler).Methods("GET")
+	r.HandleFunc("/merchants/{merchantId}/qr-payments", requireMerchant(CreateQRPaymentHandler)).Methods("POST")
+	r.HandleFunc("/merchants/{merchantId}/qr-payments", GetMerchantQRPaymentsHandler).Methods("GET")
+	r.HandleFunc("/merchants/{merchantId}/qr-payments/{qrId}", requireMerchant(CancelQRPaymentHandler)).Methods("DELETE")
+	r.HandleFunc("/qr-payments/pay", PayQRHandler).Methods("POST")
+	r.HandleFunc("/qr-payments/{qrId}", GetQRPaymentHandler).Methods("GET")
+	r.HandleFunc("/qr-payments/{qrId}/qr.png", GetQRPaymentImageHandler).Methods("GET")
+	r.HandleFunc("/pay/{qrId}", PaymentLinkHandler).Methods("GET")
+
 	r.HandleFunc("/transfers", TransferHandler).Methods("POST")
+	r.HandleFunc("/transfers/prepare", PrepareTransferHandler).Methods("POST")
+	r.HandleFunc("/transfers/confirm/{confirmationId}", ConfirmTransferHandler).Methods("POST")
+	r.HandleFunc("/sbp/transfers", CreateSBPTransferHandler).Methods("POST")
+	r.HandleFunc("/sbp/transfers/{transferId}", GetSBPTransferHandler).Methods("GET")
+	r.HandleFunc("/accounts/{accountId}/sbp-transfers", GetAccountSBPTransfersHandler).Methods("GET")
+	r.HandleFunc("/sbp/callback", SBPCallbackHandler).Methods("POST")
+	r.HandleFunc("/batches", CreatePaymentBatchHandler).Methods("POST")
+	r.HandleFunc("/batches/{batchId}", GetPaymentBatchHandler).Methods("GET")
+	r.HandleFunc("/users/{userId}/batches", GetUserPaymentBatchesHandler).Methods("GET")
+	r.HandleFunc("/money-requests", CreateMoneyRequestHandler).Methods("POST")
+	r.HandleFunc("/users/{userId}/money-requests", GetUserMoneyRequestsHandler).Methods("GET")
+	r.HandleFunc("/money-requests/{requestId}", GetMoneyRequestHandler).Methods("GET")
+	r.HandleFunc("/money-requests/{requestId}", CancelMoneyRequestHandler).Methods("DELETE")
+	r.HandleFunc("/money-requests/{requestId}/accept", AcceptMoneyRequestHandler).Methods("POST")
+	r.HandleFunc("/money-requests/{requestId}/decline", DeclineMoneyRequestHandler).Methods("POST")
+	r.HandleFunc("/invoices", CreateInvoiceHandler).Methods("POST")
+	r.HandleFunc("/users/{userId}/invoices", GetUserInvoicesHandler).Methods("GET")
+	r.HandleFunc("/invoices/{invoiceId}", GetInvoiceHandler).Methods("GET")
+	r.HandleFunc("/invoices/{invoiceId}", CancelInvoiceHandler).Methods("DELETE")
+	r.HandleFunc("/invoices/{invoiceId}/payments", PayInvoiceHandler).Methods("POST")
+	r.HandleFunc("/bill-providers", GetBillProvidersHandler).Methods("GET")
+	r.HandleFunc("/bill-providers/{providerId}", GetBillProviderHandler).Methods("GET")
+	r.HandleFunc("/bill-payments", CreateBillPaymentHandler).Methods("POST")
+	r.HandleFunc("/bill-payments/{paymentId}", GetBillPaymentHandler).Methods("GET")
+	r.HandleFunc("/users/{userId}/bill-payments", GetUserBillPaymentsHandler).Methods("GET")
+	r.HandleFunc("/bill-templates", CreateBillTemplateHandler).Methods("POST")
+	r.HandleFunc("/users/{userId}/bill-templates", GetUserBillTemplatesHandler).Methods("GET")
+	r.HandleFunc("/bill-templates/{templateId}", DeleteBillTemplateHandler).Methods("DELETE")
+	r.HandleFunc("/standing-orders", CreateStandingOrderHandler).Methods("POST")
+	r.HandleFunc("/users/{userId}/standing-orders", GetUserStandingOrdersHandler).Methods("GET")
+	r.HandleFunc("/standing-orders/{orderId}", GetStandingOrderHandler).Methods("GET")
+	r.HandleFunc("/standing-orders/{orderId}", CancelStandingOrderHandler).Methods("DELETE")
+	r.HandleFunc("/standing-orders/{orderId}/pause", PauseStandingOrderHandler).Methods("POST")
+	r.HandleFunc("/standing-orders/{orderId}/resume", ResumeStandingOrderHandler).Methods("POST")
 	r.HandleFunc("/deposits", DepositHandler).Methods("POST")
+	r.HandleFunc("/fee-rules", GetFeeRulesHandler).Methods("GET")
+	r.HandleFunc("/fee-rules", requireOperator(CreateFeeRuleHandler)).Methods("POST")
+	r.HandleFunc("/fee-rules/{ruleId}", requireOperator(DeleteFeeRuleHandler)).Methods("DELETE")
+	r.HandleFunc("/fees/preview", PreviewFeeHandler).Methods("POST")
+	r.HandleFunc("/cashback-rules", GetCashbackRulesHandler).Methods("GET")
+	r.HandleFunc("/cashback-rules", requireOperator(CreateCashbackRuleHandler)).Methods("POST")
+	r.HandleFunc("/cashback-rules/{ruleId}", requireOperator(DeleteCashbackRuleHandler)).Methods("DELETE")
+	r.HandleFunc("/users/{userId}/rewards", GetRewardsBalanceHandler).Methods("GET")
+	r.HandleFunc("/users/{userId}/rewards/cashback", GetUserCashbackHandler).Methods("GET")
+	r.HandleFunc("/rewards/payout", requireOperator(PayoutCashbackHandler)).Methods("POST")
+	r.HandleFunc("/atms", requireOperator(RegisterATMHandler)).Methods("POST")
+	r.HandleFunc("/atms/{atmId}", GetATMHandler).Methods("GET")
+	r.HandleFunc("/atm/withdrawals", requireATM(ATMWithdrawalHandler)).Methods("POST")
+	r.HandleFunc("/atm/withdrawals/{withdrawalId}", GetATMWithdrawalHandler).Methods("GET")
+	r.HandleFunc("/atm/withdrawals/{withdrawalId}/dispense-failure", requireATM(ATMDispenseFailureHandler)).Methods("POST")
+
+	r.HandleFunc("/term-deposits", OpenTermDepositHandler).Methods("POST")
+	r.HandleFunc("/users/{userId}/term-deposits", GetUserTermDepositsHandler).Methods("GET")
+	r.HandleFunc("/term-deposits/{depositId}", GetTermDepositHandler).Methods("GET")
+	r.HandleFunc("/term-deposits/{depositId}/close", CloseTermDepositHandler).Methods("POST")
+	r.HandleFunc("/term-deposits/{depositId}/rollover", SetTermDepositRolloverHandler).Methods("POST")
+
+	r.HandleFunc("/limits/{scope}/{ownerId}", GetLimitsHandler).Methods("GET")
+	r.HandleFunc("/limits/{scope}/{ownerId}", SetLimitsHandler).Methods("PUT")
 
 	r.HandleFunc("/loans", ApplyLoanHandler).Methods("POST")
 	r.HandleFunc("/loans/{loanId}/schedule", GetLoanScheduleHandler).Methods("GET")
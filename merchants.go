@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const maxAcceptorIDAttempts = 10
+
+// mccDefaultFees — ставки эквайринга по умолчанию для отдельных MCC, для остальных действует defaultMerchantFeePercent.
+var mccDefaultFees = map[string]decimal.Decimal{
+	"5411": decimal.NewFromFloat(1.2), // продуктовые магазины
+	"5912": decimal.NewFromFloat(1.5), // аптеки
+	"4111": decimal.NewFromFloat(1.0), // пассажирские перевозки
+	"5812": decimal.NewFromFloat(1.8), // рестораны
+	"5814": decimal.NewFromFloat(1.8), // фастфуд
+}
+
+var defaultMerchantFeePercent = decimal.NewFromInt(2)
+
+// merchantPaymentTypes — оплаты в пользу мерчанта: картой и по QR-коду.
+var merchantPaymentTypes = map[string]bool{
+	"payment":    true,
+	"qr_payment": true,
+}
+
+// merchantPositionTypes — операции, из которых складывается позиция мерчанта.
+var merchantPositionTypes = map[string]bool{
+	"payment":          true,
+	"qr_payment":       true,
+	"payment_reversal": true,
+	"refund":           true,
+}
+
+func ValidateMCC(mcc string) error {
+	if len(mcc) != 4 || !isDigits(mcc) {
+		return fmt.Errorf("MCC must contain 4 digits")
+	}
+	return nil
+}
+
+// NewMerchant проверяет заявку и заполняет комиссии по умолчанию для MCC. Ключ доступа мерчанта
+// возвращается отдельно: в мерчанте хранится только его хэш. Секрет уведомлений хранится как есть — им подписываются запросы.
+func NewMerchant(req CreateMerchantRequest) (Merchant, string, error) {
+	if req.Name == "" {
+		return Merchant{}, "", fmt.Errorf("merchant name is required")
+	}
+	if err := ValidateMCC(req.MCC); err != nil {
+		return Merchant{}, "", err
+	}
+	fees := MerchantFeeSchedule{Percent: defaultMerchantFeePercent, Fixed: decimal.Zero}
+	if percent, ok := mccDefaultFees[req.MCC]; ok {
+		fees.Percent = percent
+	}
+	if req.FeePercent != nil {
+		fees.Percent = *req.FeePercent
+	}
+	if req.FixedFee != nil {
+		fees.Fixed = *req.FixedFee
+	}
+	if fees.Percent.IsNegative() || fees.Percent.GreaterThan(decimal.NewFromInt(100)) || fees.Fixed.IsNegative() {
+		return Merchant{}, "", fmt.Errorf("invalid fee schedule")
+	}
+	key, hash := generateAPIKey()
+	callbackSecret, _ := generateAPIKey()
+	return Merchant{
+		ID:                  GenerateID(),
+		Name:                req.Name,
+		MCC:                 req.MCC,
+		SettlementAccountID: req.SettlementAccountID,
+		Fees:                fees,
+		Status:              "active",
+		APIKeyHash:          hash,
+		CallbackSecret:      callbackSecret,
+		CreatedAt:           time.Now(),
+	}, key, nil
+}
+
+// RegisterMerchant сохраняет мерчанта, перегенерируя идентификатор ТСП при совпадении.
+func RegisterMerchant(merchant Merchant) (Merchant, error) {
+	for attempt := 1; ; attempt++ {
+		merchant.AcceptorID = generateISODigits(15)
+		err := AddMerchant(merchant)
+		if err == nil {
+			return merchant, nil
+		}
+		if !errors.Is(err, errAcceptorIDTaken) || attempt >= maxAcceptorIDAttempts {
+			return Merchant{}, err
+		}
+	}
+}
+
+// ResolvePaymentMerchant находит мерчанта, принимающего оплату.
+func ResolvePaymentMerchant(merchantID string) (Merchant, error) {
+	merchant, ok := GetMerchant(merchantID)
+	if !ok {
+		return Merchant{}, errMerchantNotFound
+	}
+	if merchant.Status != "active" {
+		return Merchant{}, errMerchantInactive
+	}
+	return merchant, nil
+}
+
+func CalculateMerchantFee(fees MerchantFeeSchedule, amount decimal.Decimal) decimal.Decimal {
+	fee := amount.Mul(fees.Percent).Div(decimal.NewFromInt(100)).Add(fees.Fixed).RoundBank(2)
+	if fee.GreaterThan(amount) {
+		return amount
+	}
+	return fee
+}
+
+// CalculateMerchantPosition считает нетто-позицию: оплаты минус возвраты и комиссии.
+// При отмене оплаты (reversal) комиссия за нее не удерживается, при возврате — удерживается.
+func CalculateMerchantPosition(merchant Merchant, txs []Transaction) MerchantPosition {
+	position := MerchantPosition{
+		MerchantID:    merchant.ID,
+		GrossAmount:   decimal.Zero,
+		RefundsAmount: decimal.Zero,
+		FeesAmount:    decimal.Zero,
+	}
+	for _, tx := range txs {
+		switch tx.TransactionType {
+		case "payment", "qr_payment":
+			position.PaymentsCount++
+			position.GrossAmount = position.GrossAmount.Add(tx.Amount)
+			position.FeesAmount = position.FeesAmount.Add(CalculateMerchantFee(merchant.Fees, tx.Amount))
+		case "payment_reversal":
+			position.RefundsCount++
+			position.RefundsAmount = position.RefundsAmount.Add(tx.Amount)
+			position.FeesAmount = position.FeesAmount.Sub(CalculateMerchantFee(merchant.Fees, tx.Amount))
+		case "refund":
+			if tx.FromAccountID != "" {
+				continue // возврат уже списан с расчетного счета мерчанта
+			}
+			position.RefundsCount++
+			position.RefundsAmount = position.RefundsAmount.Add(tx.Amount)
+		}
+	}
+	position.NetAmount = position.GrossAmount.Sub(position.RefundsAmount).Sub(position.FeesAmount)
+	return position
+}
+
+// SettleMerchants — ежедневный расчет с мерчантами.
+func SettleMerchants(now time.Time) {
+	for _, merchant := range GetMerchants() {
+		settlement, settled, err := SettleMerchant(merchant.ID, now)
+		if err != nil {
+			log.Printf("Settlement for merchant %s failed: %v", merchant.ID, err)
+			continue
+		}
+		if settled {
+			log.Printf("Merchant %s settled: gross %s, refunds %s, fees %s, paid out %s",
+				merchant.ID, settlement.GrossAmount.String(), settlement.RefundsAmount.String(),
+				settlement.FeesAmount.String(), settlement.NetAmount.String())
+		}
+	}
+}
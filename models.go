@@ -3,48 +3,327 @@ package main
 import (
 	"time"
 
-	"github.com/shopspring/decimal" 
+	"github.com/shopspring/decimal"
+)
 
 type User struct {
-	ID           string    `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"` 
-	CreatedAt    time.Time `json:"created_at"`
+	ID               string    `json:"id"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	FullName         string    `json:"full_name,omitempty"`
+	Phone            string    `json:"phone,omitempty"`
+	DefaultAccountID string    `json:"default_account_id,omitempty"` // счет для входящих переводов по номеру телефона
+	Tier             string    `json:"tier"`                         // standard | premium | private — тарифный план клиента
+	PasswordHash     string    `json:"-"`
+	CreatedAt        time.Time `json:"created_at"`
 }
 
 type Account struct {
-	ID        string          `json:"id"`
-	UserID    string          `json:"user_id"`
-	Number    string          `json:"number"` 
-	Balance   decimal.Decimal `json:"balance"`
-	CreatedAt time.Time       `json:"created_at"`
+	ID                 string          `json:"id"`
+	UserID             string          `json:"user_id"`
+	Number             string          `json:"number"`
+	Type               string          `json:"type"` // personal | entrepreneur | business
+	Currency           string          `json:"currency"`
+	Balance            decimal.Decimal `json:"balance"`
+	OverdraftLimit     decimal.Decimal `json:"overdraft_limit"`
+	OverdraftRate      decimal.Decimal `json:"overdraft_rate"`
+	OverdraftAccrued   decimal.Decimal `json:"overdraft_interest_accrued"`
+	OverdraftChargedAt time.Time       `json:"-"`
+	Status             string          `json:"status"` // active | frozen | closed
+	StatusReason       string          `json:"status_reason,omitempty"`
+	StatusChangedAt    *time.Time      `json:"status_changed_at,omitempty"`
+	CreatedAt          time.Time       `json:"created_at"`
+}
+
+// AvailableBalance возвращает сумму, доступную для списания с учетом овердрафта.
+func (a Account) AvailableBalance() decimal.Decimal {
+	return a.Balance.Add(a.OverdraftLimit)
 }
 
 type Card struct {
-	ID          string    `json:"id"`
-	AccountID   string    `json:"account_id"`
-	Number      string    `json:"number"` 
-	ExpiryMonth int       `json:"expiry_month"`
-	ExpiryYear  int       `json:"expiry_year"`
-	CVV         string    `json:"-"` 
-	CreatedAt   time.Time `json:"created_at"`
+	ID                string           `json:"id"`
+	AccountID         string           `json:"account_id"`
+	MaskedNumber      string           `json:"number"` // первые 6 и последние 4 цифры
+	EncryptedNumber   []byte           `json:"-"`
+	KeyVersion        int              `json:"-"`                        // версия ключа, которым зашифрован номер
+	NumberHash        string           `json:"-"`                        // HMAC номера для поиска карты
+	Product           string           `json:"product"`                  // visa | mastercard | mir
+	Type              string           `json:"type"`                     // physical | virtual | single_use
+	SpendingLimit     *decimal.Decimal `json:"spending_limit,omitempty"` // лимит трат за весь срок действия карты
+	ExpiryMonth       int              `json:"expiry_month"`
+	ExpiryYear        int              `json:"expiry_year"`
+	CVVHash           string           `json:"-"`
+	FailedCVVChecks   int              `json:"-"`
+	PINHash           string           `json:"-"`
+	PINSet            bool             `json:"pin_set"`
+	FailedPINAttempts int              `json:"-"`
+	Status            string           `json:"status"` // active | blocked | security_blocked | permanently_blocked | expired | replaced | used
+	StatusReason      string           `json:"status_reason,omitempty"`
+	StatusChangedAt   *time.Time       `json:"status_changed_at,omitempty"`
+	ReplacesCardID    string           `json:"replaces_card_id,omitempty"`
+	ReplacedByCardID  string           `json:"replaced_by_card_id,omitempty"`
+	CreatedAt         time.Time        `json:"created_at"`
+}
+
+// IsExpired сообщает, истек ли срок действия карты (карта действует до конца месяца).
+func (c Card) IsExpired(now time.Time) bool {
+	expiry := time.Date(c.ExpiryYear, time.Month(c.ExpiryMonth)+1, 0, 23, 59, 59, 0, time.UTC)
+	return now.After(expiry)
+}
+
+// IsLive сообщает, может ли карта еще использоваться (в том числе после временной блокировки).
+func (c Card) IsLive(now time.Time) bool {
+	return (c.Status == "active" || c.Status == "blocked" || c.Status == "security_blocked") && !c.IsExpired(now)
 }
 
 type Transaction struct {
 	ID              string          `json:"id"`
-	FromAccountID   string          `json:"from_account_id,omitempty"` 
-	ToAccountID     string          `json:"to_account_id,omitempty"`   
+	FromAccountID   string          `json:"from_account_id,omitempty"`
+	ToAccountID     string          `json:"to_account_id,omitempty"`
 	Amount          decimal.Decimal `json:"amount"`
 	Timestamp       time.Time       `json:"timestamp"`
 	TransactionType string          `json:"transaction_type"`
 	Description     string          `json:"description,omitempty"`
+	CardID          string          `json:"card_id,omitempty"`
+	MerchantID      string          `json:"merchant_id,omitempty"`
+	RelatedTxID     string          `json:"related_transaction_id,omitempty"` // исходная операция для возвратов, отмен и комиссий
+	Channel         string          `json:"channel,omitempty"`                // branch | atm | transfer_in — канал внесения или выдачи наличных
+	TerminalID      string          `json:"terminal_id,omitempty"`            // банкомат, через который проведена операция
+	FeeRuleID       string          `json:"fee_rule_id,omitempty"`            // правило тарифа, по которому рассчитана комиссия за операцию
+}
+
+// TransactionFilter — условия выборки истории счета. Пустые поля не ограничивают выборку.
+type TransactionFilter struct {
+	From           time.Time
+	To             time.Time // не включительно
+	Types          map[string]bool
+	MinAmount      *decimal.Decimal
+	MaxAmount      *decimal.Decimal
+	Counterparties map[string]bool // ID счета второй стороны или мерчанта
+	Query          string          // подстрока описания в нижнем регистре
+	Cursor         *int
+	Limit          int // 0 — вся история без разбиения на страницы
+}
+
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+	HasMore      bool          `json:"has_more"`
+}
+
+// Statement — выписка по счету за период [From, To): входящий остаток, движения, исходящий остаток и обороты.
+type Statement struct {
+	ID             string           `json:"id"`
+	AccountID      string           `json:"account_id"`
+	AccountNumber  string           `json:"account_number"`
+	Currency       string           `json:"currency"`
+	OwnerName      string           `json:"owner_name"`
+	From           time.Time        `json:"from"`
+	To             time.Time        `json:"to"`
+	OpeningBalance decimal.Decimal  `json:"opening_balance"`
+	ClosingBalance decimal.Decimal  `json:"closing_balance"`
+	TotalDebit     decimal.Decimal  `json:"total_debit"`
+	TotalCredit    decimal.Decimal  `json:"total_credit"`
+	DebitCount     int              `json:"debit_count"`
+	CreditCount    int              `json:"credit_count"`
+	Entries        []StatementEntry `json:"entries"`
+	GeneratedAt    time.Time        `json:"generated_at"`
+}
+
+type StatementEntry struct {
+	Transaction
+	Direction    string          `json:"direction"` // debit | credit
+	Counterparty string          `json:"counterparty,omitempty"`
+	BalanceAfter decimal.Decimal `json:"balance_after"`
+}
+
+// FeeRule — правило тарифа. Из подходящих правил применяется самое конкретное (с уровнем клиента
+// и сетью), а при равенстве — добавленное позже.
+type FeeRule struct {
+	ID                string           `json:"id"`
+	Operation         string           `json:"operation"`            // transfer | interbank | card_payment | withdrawal | fx
+	Tier              string           `json:"tier,omitempty"`       // пусто — для всех клиентов
+	Network           string           `json:"network,omitempty"`    // для withdrawal: on_us | partner | off_us; пусто — любая сеть
+	MinAmount         decimal.Decimal  `json:"min_amount"`           // включительно
+	MaxAmount         *decimal.Decimal `json:"max_amount,omitempty"` // не включительно; без значения — без ограничения
+	Percent           decimal.Decimal  `json:"percent"`
+	Fixed             decimal.Decimal  `json:"fixed"`
+	MinFee            decimal.Decimal  `json:"min_fee"`
+	MaxFee            *decimal.Decimal `json:"max_fee,omitempty"`
+	FreeMonthlyCount  int              `json:"free_monthly_count,omitempty"`  // первые N операций в месяце без комиссии
+	FreeMonthlyAmount decimal.Decimal  `json:"free_monthly_amount,omitempty"` // операции в пределах этой суммы за месяц без комиссии
+	CreatedAt         time.Time        `json:"created_at"`
+}
+
+// FeeQuote — комиссия, рассчитанная до проведения операции.
+type FeeQuote struct {
+	Operation          string           `json:"operation"`
+	Tier               string           `json:"tier"`
+	Network            string           `json:"network,omitempty"`
+	Amount             decimal.Decimal  `json:"amount"`
+	Fee                decimal.Decimal  `json:"fee"`
+	Total              decimal.Decimal  `json:"total"`
+	RuleID             string           `json:"rule_id,omitempty"`
+	FreeOperationsLeft *int             `json:"free_operations_left,omitempty"`
+	FreeAmountLeft     *decimal.Decimal `json:"free_amount_left,omitempty"`
+}
+
+// MerchantFeeSchedule — комиссия эквайринга: процент от суммы оплаты плюс фиксированная часть.
+type MerchantFeeSchedule struct {
+	Percent decimal.Decimal `json:"percent"`
+	Fixed   decimal.Decimal `json:"fixed"`
+}
+
+type Merchant struct {
+	ID                  string              `json:"id"`
+	Name                string              `json:"name"`
+	MCC                 string              `json:"mcc"`
+	AcceptorID          string              `json:"acceptor_id"` // идентификатор ТСП для сети (поле 42 ISO 8583)
+	SettlementAccountID string              `json:"settlement_account_id"`
+	Fees                MerchantFeeSchedule `json:"fees"`
+	Status              string              `json:"status"` // active | suspended
+	APIKeyHash          string              `json:"-"`      // хэш ключа X-Merchant-Key
+	CallbackSecret      string              `json:"-"`      // ключ подписи уведомлений на callback_url
+	CreatedAt           time.Time           `json:"created_at"`
+}
+
+// RegisteredMerchant возвращается только при регистрации: ключ мерчанта и секрет уведомлений показываются один раз.
+type RegisteredMerchant struct {
+	Merchant
+	APIKey         string `json:"api_key"`
+	CallbackSecret string `json:"callback_secret"`
+}
+
+type MerchantSettlement struct {
+	ID             string          `json:"id"`
+	MerchantID     string          `json:"merchant_id"`
+	PeriodStart    time.Time       `json:"period_start"`
+	PeriodEnd      time.Time       `json:"period_end"`
+	PaymentsCount  int             `json:"payments_count"`
+	RefundsCount   int             `json:"refunds_count"`
+	GrossAmount    decimal.Decimal `json:"gross_amount"`
+	RefundsAmount  decimal.Decimal `json:"refunds_amount"`
+	FeesAmount     decimal.Decimal `json:"fees_amount"`
+	NetAmount      decimal.Decimal `json:"net_amount"`
+	TransactionID  string          `json:"transaction_id"` // выплата на расчетный счет
+	TransactionIDs []string        `json:"transaction_ids"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+type CreateMerchantRequest struct {
+	Name                string           `json:"name"`
+	MCC                 string           `json:"mcc"`
+	SettlementAccountID string           `json:"settlement_account_id"`
+	FeePercent          *decimal.Decimal `json:"fee_percent,omitempty"`
+	FixedFee            *decimal.Decimal `json:"fixed_fee,omitempty"`
+}
+
+type MerchantRefundRequest struct {
+	TransactionID string          `json:"transaction_id"`
+	Amount        decimal.Decimal `json:"amount"`
+}
+
+// MerchantPosition — сумма, причитающаяся мерчанту по операциям, еще не вошедшим в расчет.
+type MerchantPosition struct {
+	MerchantID    string          `json:"merchant_id"`
+	PaymentsCount int             `json:"payments_count"`
+	RefundsCount  int             `json:"refunds_count"`
+	GrossAmount   decimal.Decimal `json:"gross_amount"`
+	RefundsAmount decimal.Decimal `json:"refunds_amount"`
+	FeesAmount    decimal.Decimal `json:"fees_amount"`
+	NetAmount     decimal.Decimal `json:"net_amount"`
+}
+
+// CashbackRule — ставка кешбэка за оплаты картой у партнера (MerchantID) или в категории (MCC).
+// Правило партнера важнее правила категории, правило категории — базового (без MCC и мерчанта).
+type CashbackRule struct {
+	ID         string           `json:"id"`
+	MerchantID string           `json:"merchant_id,omitempty"`
+	MCC        string           `json:"mcc,omitempty"`
+	Percent    decimal.Decimal  `json:"percent"`
+	MonthlyCap *decimal.Decimal `json:"monthly_cap,omitempty"` // максимум кешбэка по правилу на клиента за месяц
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+// CashbackReward — кешбэк за одну покупку. Начисленный кешбэк копится как pending
+// и зачисляется на счет карты в начале следующего месяца.
+type CashbackReward struct {
+	ID                  string          `json:"id"`
+	UserID              string          `json:"user_id"`
+	AccountID           string          `json:"account_id"`
+	CardID              string          `json:"card_id"`
+	TransactionID       string          `json:"transaction_id"`
+	MerchantID          string          `json:"merchant_id"`
+	MCC                 string          `json:"mcc"`
+	RuleID              string          `json:"rule_id"`
+	Period              string          `json:"period"` // YYYY-MM — месяц покупки
+	PurchaseAmount      decimal.Decimal `json:"purchase_amount"`
+	RefundedAmount      decimal.Decimal `json:"refunded_amount"`
+	Percent             decimal.Decimal `json:"percent"`
+	Amount              decimal.Decimal `json:"amount"`
+	Capped              bool            `json:"capped,omitempty"` // сумма урезана месячным лимитом
+	Status              string          `json:"status"`           // pending | paid | cancelled
+	PayoutTransactionID string          `json:"payout_transaction_id,omitempty"`
+	CreatedAt           time.Time       `json:"created_at"`
+	PaidAt              *time.Time      `json:"paid_at,omitempty"`
+}
+
+// RewardsBalance — бонусный счет клиента: ожидающий зачисления и уже выплаченный кешбэк.
+type RewardsBalance struct {
+	UserID          string          `json:"user_id"`
+	Pending         decimal.Decimal `json:"pending"`
+	Paid            decimal.Decimal `json:"paid"`
+	CurrentPeriod   string          `json:"current_period"`
+	AccruedInPeriod decimal.Decimal `json:"accrued_in_period"`
+	MonthlyCap      decimal.Decimal `json:"monthly_cap"`
+}
+
+// QRPayment — платежное требование мерчанта. Статический QR содержит только мерчанта, сумму вводит покупатель;
+// динамический — фиксированную сумму и срок действия, оплачивается один раз.
+type QRPayment struct {
+	ID             string           `json:"id"`
+	MerchantID     string           `json:"merchant_id"`
+	Type           string           `json:"type"` // static | dynamic
+	Amount         *decimal.Decimal `json:"amount,omitempty"`
+	Purpose        string           `json:"purpose,omitempty"`
+	Payload        string           `json:"payload"`
+	Link           string           `json:"link"`
+	CallbackURL    string           `json:"callback_url,omitempty"`
+	Status         string           `json:"status"` // active | paid | expired | cancelled
+	TransactionIDs []string         `json:"transaction_ids,omitempty"`
+	ExpiresAt      *time.Time       `json:"expires_at,omitempty"`
+	PaidAt         *time.Time       `json:"paid_at,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+}
+
+type CreateQRPaymentRequest struct {
+	Type         string           `json:"type"` // static | dynamic
+	Amount       *decimal.Decimal `json:"amount,omitempty"`
+	Purpose      string           `json:"purpose,omitempty"`
+	ValidMinutes int              `json:"valid_minutes,omitempty"`
+	CallbackURL  string           `json:"callback_url,omitempty"`
+}
+
+type PayQRRequest struct {
+	Payload       string          `json:"payload"` // строка из QR-кода или платежная ссылка
+	FromAccountID string          `json:"from_account_id"`
+	Amount        decimal.Decimal `json:"amount,omitempty"` // для статического QR
+}
+
+// QRPaymentNotification отправляется на callback_url мерчанта после оплаты.
+type QRPaymentNotification struct {
+	QRPaymentID   string          `json:"qr_payment_id"`
+	MerchantID    string          `json:"merchant_id"`
+	Status        string          `json:"status"`
+	TransactionID string          `json:"transaction_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	PaidAt        time.Time       `json:"paid_at"`
 }
 
 type Loan struct {
 	ID              string          `json:"id"`
 	UserID          string          `json:"user_id"`
-	AccountID       string          `json:"account_id"` 
+	AccountID       string          `json:"account_id"`
 	Amount          decimal.Decimal `json:"amount"`
 	InterestRate    decimal.Decimal `json:"interest_rate"`
 	TermMonths      int             `json:"term_months"`
@@ -53,6 +332,284 @@ type Loan struct {
 	RemainingAmount decimal.Decimal `json:"remaining_amount"`
 }
 
+type TermDeposit struct {
+	ID               string          `json:"id"`
+	UserID           string          `json:"user_id"`
+	AccountID        string          `json:"account_id"` // текущий счет, с которого списаны и на который вернутся средства
+	Amount           decimal.Decimal `json:"amount"`
+	InterestRate     decimal.Decimal `json:"interest_rate"`
+	EarlyClosureRate decimal.Decimal `json:"early_closure_rate"`
+	RateType         string          `json:"rate_type"`       // fixed | key_rate
+	InterestPayout   string          `json:"interest_payout"` // maturity | monthly
+	AutoRollover     bool            `json:"auto_rollover"`
+	TermMonths       int             `json:"term_months"`
+	StartDate        time.Time       `json:"start_date"`
+	MaturityDate     time.Time       `json:"maturity_date"`
+	InterestPaid     decimal.Decimal `json:"interest_paid"` // за текущий срок
+	PaidPeriods      int             `json:"paid_periods"`
+	Rollovers        int             `json:"rollovers"`
+	Status           string          `json:"status"` // active | closed | closed_early
+	ClosedAt         *time.Time      `json:"closed_at,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+type TransferConfirmation struct {
+	ID            string          `json:"id"`
+	FromAccountID string          `json:"from_account_id"`
+	ToAccountID   string          `json:"-"`
+	Amount        decimal.Decimal `json:"amount"`
+	RecipientType string          `json:"recipient_type"` // account_id | account_number | card_number | phone
+	RecipientName string          `json:"recipient_name"` // замаскированное имя получателя
+	RecipientHint string          `json:"recipient_hint"` // замаскированный номер счета получателя
+	Fee           decimal.Decimal `json:"fee"`            // комиссия по тарифу на момент подготовки перевода
+	Status        string          `json:"status"`         // pending | executed
+	ExpiresAt     time.Time       `json:"expires_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+type StandingOrder struct {
+	ID              string                   `json:"id"`
+	UserID          string                   `json:"user_id"`
+	FromAccountID   string                   `json:"from_account_id"`
+	ToAccountID     string                   `json:"to_account_id"`
+	Amount          decimal.Decimal          `json:"amount"`
+	Description     string                   `json:"description,omitempty"`
+	Frequency       string                   `json:"frequency"` // once | daily | weekly | monthly | last_business_day
+	DayOfMonth      int                      `json:"day_of_month,omitempty"`
+	StartDate       time.Time                `json:"start_date"`
+	EndDate         *time.Time               `json:"end_date,omitempty"`
+	MaxExecutions   int                      `json:"max_executions,omitempty"`
+	ExecutionsCount int                      `json:"executions_count"` // только успешные исполнения
+	NextRunAt       time.Time                `json:"next_run_at"`
+	Status          string                   `json:"status"` // active | paused | cancelled | completed
+	Executions      []StandingOrderExecution `json:"executions"`
+	CreatedAt       time.Time                `json:"created_at"`
+}
+
+type StandingOrderExecution struct {
+	ScheduledAt   time.Time `json:"scheduled_at"`
+	ExecutedAt    time.Time `json:"executed_at"`
+	Status        string    `json:"status"` // success | failed
+	TransactionID string    `json:"transaction_id,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// MoneyRequest — запрос денег у другого клиента; при согласии плательщика исполняется перевод.
+type MoneyRequest struct {
+	ID            string          `json:"id"`
+	RequesterID   string          `json:"requester_id"`
+	ToAccountID   string          `json:"to_account_id"`
+	PayerID       string          `json:"payer_id"`
+	RequesterName string          `json:"requester_name"` // замаскированное имя для плательщика
+	Amount        decimal.Decimal `json:"amount"`
+	Comment       string          `json:"comment,omitempty"`
+	Status        string          `json:"status"` // pending | accepted | declined | expired | cancelled
+	TransactionID string          `json:"transaction_id,omitempty"`
+	ExpiresAt     time.Time       `json:"expires_at"`
+	RespondedAt   *time.Time      `json:"responded_at,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+type CreateMoneyRequestRequest struct {
+	UserID      string          `json:"user_id"`
+	ToAccountID string          `json:"to_account_id"`
+	Payer       string          `json:"payer"` // телефон или ID клиента
+	Amount      decimal.Decimal `json:"amount"`
+	Comment     string          `json:"comment,omitempty"`
+	ValidDays   int             `json:"valid_days,omitempty"`
+}
+
+// CancelRequest — отмена запроса денег или счета его автором.
+type CancelRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type RespondMoneyRequestRequest struct {
+	UserID        string `json:"user_id"`
+	FromAccountID string `json:"from_account_id,omitempty"` // только при согласии
+}
+
+// Invoice — счет на оплату от бизнес-клиента с позициями, сроком оплаты и частичными оплатами.
+type Invoice struct {
+	ID          string           `json:"id"`
+	Number      string           `json:"number,omitempty"`
+	IssuerID    string           `json:"issuer_id"`
+	ToAccountID string           `json:"to_account_id"`
+	PayerID     string           `json:"payer_id"`
+	IssuerName  string           `json:"issuer_name"`
+	Lines       []InvoiceLine    `json:"lines"`
+	Total       decimal.Decimal  `json:"total"`
+	PaidAmount  decimal.Decimal  `json:"paid_amount"`
+	Outstanding decimal.Decimal  `json:"outstanding"`
+	Comment     string           `json:"comment,omitempty"`
+	DueDate     time.Time        `json:"due_date"`
+	Status      string           `json:"status"` // issued | partially_paid | paid | overdue | cancelled
+	Payments    []InvoicePayment `json:"payments,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+type InvoiceLine struct {
+	Description string          `json:"description"`
+	Quantity    decimal.Decimal `json:"quantity"`
+	UnitPrice   decimal.Decimal `json:"unit_price"`
+	Amount      decimal.Decimal `json:"amount"`
+}
+
+type InvoicePayment struct {
+	TransactionID string          `json:"transaction_id"`
+	FromAccountID string          `json:"from_account_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	PaidAt        time.Time       `json:"paid_at"`
+}
+
+type CreateInvoiceRequest struct {
+	UserID      string        `json:"user_id"`
+	ToAccountID string        `json:"to_account_id"`
+	Payer       string        `json:"payer"` // телефон или ID клиента
+	Number      string        `json:"number,omitempty"`
+	Lines       []InvoiceLine `json:"lines"`
+	Comment     string        `json:"comment,omitempty"`
+	DueDate     time.Time     `json:"due_date"`
+}
+
+type PayInvoiceRequest struct {
+	UserID        string           `json:"user_id"`
+	FromAccountID string           `json:"from_account_id"`
+	Amount        *decimal.Decimal `json:"amount,omitempty"` // по умолчанию — весь остаток
+}
+
+type PaymentBatch struct {
+	ID             string          `json:"id"`
+	UserID         string          `json:"user_id"`
+	FromAccountID  string          `json:"from_account_id"`
+	Mode           string          `json:"mode"`   // all_or_nothing | best_effort
+	Status         string          `json:"status"` // rejected | processing | completed | partially_completed | failed
+	TotalAmount    decimal.Decimal `json:"total_amount"`
+	ValidLines     int             `json:"valid_lines"`
+	InvalidLines   int             `json:"invalid_lines"`
+	SucceededLines int             `json:"succeeded_lines"`
+	FailedLines    int             `json:"failed_lines"`
+	Lines          []BatchLine     `json:"lines,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	CompletedAt    *time.Time      `json:"completed_at,omitempty"`
+}
+
+type BatchLine struct {
+	Line          int             `json:"line"`
+	To            string          `json:"to"`
+	ToType        string          `json:"to_type,omitempty"`
+	ToAccountID   string          `json:"-"`
+	Amount        decimal.Decimal `json:"amount"`
+	Description   string          `json:"description,omitempty"`
+	Status        string          `json:"status"` // invalid | pending | success | failed | skipped
+	Error         string          `json:"error,omitempty"`
+	TransactionID string          `json:"transaction_id,omitempty"`
+}
+
+type SBPTransfer struct {
+	ID               string          `json:"id"`
+	Direction        string          `json:"direction"` // outgoing | incoming
+	UserID           string          `json:"user_id,omitempty"`
+	AccountID        string          `json:"account_id"`
+	Phone            string          `json:"phone,omitempty"` // телефон получателя (исходящий) или получателя у нас (входящий)
+	BankID           string          `json:"bank_id"`         // идентификатор банка-контрагента в СБП
+	CounterpartyName string          `json:"counterparty_name,omitempty"`
+	Amount           decimal.Decimal `json:"amount"`
+	Fee              decimal.Decimal `json:"fee"`
+	Description      string          `json:"description,omitempty"`
+	Status           string          `json:"status"` // pending | confirmed | rejected
+	Reason           string          `json:"reason,omitempty"`
+	ExternalID       string          `json:"external_id,omitempty"`
+	TransactionID    string          `json:"transaction_id,omitempty"`
+	FeeTransactionID string          `json:"fee_transaction_id,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+}
+
+// BillProvider — поставщик услуг (ЖКХ, связь, интернет) из каталога платежей.
+type BillProvider struct {
+	ID        string              `json:"id"`
+	Name      string              `json:"name"`
+	Category  string              `json:"category"` // utilities | mobile | internet | tv
+	Fields    []BillProviderField `json:"fields"`
+	MinAmount decimal.Decimal     `json:"min_amount"`
+	MaxAmount decimal.Decimal     `json:"max_amount"`
+}
+
+type BillProviderField struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Pattern  string `json:"pattern"` // регулярное выражение для значения
+	Hint     string `json:"hint,omitempty"`
+	Required bool   `json:"required"`
+}
+
+type BillPayment struct {
+	ID                  string            `json:"id"`
+	UserID              string            `json:"user_id"`
+	AccountID           string            `json:"account_id"`
+	ProviderID          string            `json:"provider_id"`
+	ProviderName        string            `json:"provider_name"`
+	Fields              map[string]string `json:"fields"`
+	Amount              decimal.Decimal   `json:"amount"`
+	Status              string            `json:"status"` // pending | success | failed | refund_pending (отказ, возврат еще не зачислен)
+	Reason              string            `json:"reason,omitempty"`
+	ExternalID          string            `json:"external_id,omitempty"`
+	TemplateID          string            `json:"template_id,omitempty"`
+	TransactionID       string            `json:"transaction_id"`
+	RefundTransactionID string            `json:"refund_transaction_id,omitempty"`
+	CreatedAt           time.Time         `json:"created_at"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+}
+
+type CreateBillPaymentRequest struct {
+	UserID         string            `json:"user_id"`
+	FromAccountID  string            `json:"from_account_id,omitempty"`
+	ProviderID     string            `json:"provider_id,omitempty"`
+	Fields         map[string]string `json:"fields,omitempty"`
+	Amount         decimal.Decimal   `json:"amount,omitempty"`
+	TemplateID     string            `json:"template_id,omitempty"`      // недостающие данные берутся из шаблона
+	SaveAsTemplate string            `json:"save_as_template,omitempty"` // название нового шаблона
+}
+
+// BillTemplate — сохраненный платеж поставщику для повторной оплаты.
+type BillTemplate struct {
+	ID            string            `json:"id"`
+	UserID        string            `json:"user_id"`
+	Name          string            `json:"name"`
+	ProviderID    string            `json:"provider_id"`
+	Fields        map[string]string `json:"fields"`
+	FromAccountID string            `json:"from_account_id,omitempty"`
+	Amount        *decimal.Decimal  `json:"amount,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+type CreateBillTemplateRequest struct {
+	UserID        string            `json:"user_id"`
+	Name          string            `json:"name"`
+	ProviderID    string            `json:"provider_id"`
+	Fields        map[string]string `json:"fields"`
+	FromAccountID string            `json:"from_account_id,omitempty"`
+	Amount        *decimal.Decimal  `json:"amount,omitempty"`
+}
+
+type OperationLimits struct {
+	PerTransaction decimal.Decimal `json:"per_transaction"`
+	Daily          decimal.Decimal `json:"daily"`
+	Monthly        decimal.Decimal `json:"monthly"`
+}
+
+type LimitStatus struct {
+	Category         string          `json:"category"`
+	Limits           OperationLimits `json:"limits"`
+	Default          OperationLimits `json:"default"`
+	Custom           bool            `json:"custom"`
+	UsedToday        decimal.Decimal `json:"used_today"`
+	UsedThisMonth    decimal.Decimal `json:"used_this_month"`
+	RemainingToday   decimal.Decimal `json:"remaining_today"`
+	RemainingMonthly decimal.Decimal `json:"remaining_monthly"`
+}
+
 type Payment struct {
 	DueDate       time.Time       `json:"due_date"`
 	Amount        decimal.Decimal `json:"amount"`
@@ -61,11 +618,16 @@ type Payment struct {
 	Paid          bool            `json:"paid"`
 }
 
-
 type RegisterRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	FullName string `json:"full_name,omitempty"`
+	Phone    string `json:"phone,omitempty"`
+}
+
+type SetDefaultAccountRequest struct {
+	AccountID string `json:"account_id"`
 }
 
 type LoginRequest struct {
@@ -74,17 +636,99 @@ type LoginRequest struct {
 }
 
 type CreateAccountRequest struct {
-	UserID string `json:"user_id"` 
+	UserID   string `json:"user_id"`
+	Type     string `json:"type,omitempty"`
+	Currency string `json:"currency,omitempty"`
 }
 
 type GenerateCardRequest struct {
-	AccountID string `json:"account_id"`
+	AccountID     string           `json:"account_id"`
+	Product       string           `json:"product"` // visa | mastercard | mir, по умолчанию visa
+	Type          string           `json:"type"`    // physical | virtual | single_use, по умолчанию physical
+	ValidMonths   int              `json:"valid_months,omitempty"`
+	SpendingLimit *decimal.Decimal `json:"spending_limit,omitempty"`
+}
+
+type CardStatusRequest struct {
+	Reason string `json:"reason,omitempty"`
+	CardOwnerAuth
+}
+
+// CardOwnerAuth подтверждает действие с картой владельцем: снятие блокировки, перевыпуск.
+type CardOwnerAuth struct {
+	UserID   string `json:"user_id,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// IssuedCard возвращается только при выпуске карты: CVV показывается клиенту один раз и не хранится.
+type IssuedCard struct {
+	Card
+	CVV string `json:"cvv"`
+}
+
+// CardPaymentRef связывает операцию из внешней сети (терминал и RRN) с проводкой, чтобы ее можно было отменить.
+type CardPaymentRef struct {
+	Key           string          `json:"key"`
+	TransactionID string          `json:"transaction_id"`
+	AccountID     string          `json:"account_id"`
+	CardID        string          `json:"card_id"`
+	MerchantID    string          `json:"merchant_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	AuthCode      string          `json:"auth_code"`
+	Reversed      bool            `json:"reversed"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// CardToken заменяет номер карты при оплате у конкретного мерчанта.
+type CardToken struct {
+	Token      string     `json:"token"`
+	CardID     string     `json:"card_id"`
+	MerchantID string     `json:"merchant_id"`
+	Status     string     `json:"status"` // active | revoked
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// SetPINRequest: первичная установка или сброс забытого PIN по паролю владельца либо смена по текущему PIN.
+type SetPINRequest struct {
+	PIN        string `json:"pin"`
+	Password   string `json:"password,omitempty"`
+	CurrentPIN string `json:"current_pin,omitempty"`
+}
+
+type CreateCardTokenRequest struct {
+	MerchantID string `json:"merchant_id"`
+}
+
+// CardRevealChallenge — одноразовый код, отправленный клиенту для показа полного номера карты.
+type CardRevealChallenge struct {
+	ID        string    `json:"id"`
+	CardID    string    `json:"card_id"`
+	CodeHash  string    `json:"-"`
+	Attempts  int       `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CardRevealRequest struct {
+	Password string `json:"password"`
+}
+
+type CardRevealConfirmRequest struct {
+	ChallengeID string `json:"challenge_id"`
+	Code        string `json:"code"`
 }
 
 type PaymentRequest struct {
-	CardNumber string          `json:"card_number"`
-	Amount     decimal.Decimal `json:"amount"`
-	Merchant   string          `json:"merchant"` 
+	CardNumber  string          `json:"card_number"`
+	Token       string          `json:"token"` // вместо номера, срока и CVV мерчант может передать токен карты
+	ExpiryMonth int             `json:"expiry_month"`
+	ExpiryYear  int             `json:"expiry_year"`
+	CVV         string          `json:"cvv"`
+	PIN         string          `json:"pin,omitempty"` // проверяется вместе со сроком действия и CVV
+	Amount      decimal.Decimal `json:"amount"`
+	MerchantID  string          `json:"merchant_id"`
+	CardPresent bool            `json:"-"` // карта предъявлена на терминале: PIN заменяет срок действия и CVV
 }
 
 type TransferRequest struct {
@@ -93,13 +737,201 @@ type TransferRequest struct {
 	Amount        decimal.Decimal `json:"amount"`
 }
 
+// PrepareTransferRequest описывает перевод по реквизитам, которые видит клиент:
+// номеру счета, номеру карты или телефону. ToType можно не указывать — тип определяется по формату.
+type PrepareTransferRequest struct {
+	FromAccountID string          `json:"from_account_id"`
+	To            string          `json:"to"`
+	ToType        string          `json:"to_type,omitempty"`
+	Amount        decimal.Decimal `json:"amount"`
+}
+
+type CreateStandingOrderRequest struct {
+	UserID        string          `json:"user_id"`
+	FromAccountID string          `json:"from_account_id"`
+	To            string          `json:"to"`
+	ToType        string          `json:"to_type,omitempty"`
+	Amount        decimal.Decimal `json:"amount"`
+	Description   string          `json:"description,omitempty"`
+	Frequency     string          `json:"frequency"`
+	StartDate     time.Time       `json:"start_date"`
+	EndDate       *time.Time      `json:"end_date,omitempty"`
+	MaxExecutions int             `json:"max_executions,omitempty"`
+}
+
+type BatchPaymentRequest struct {
+	UserID        string             `json:"user_id"`
+	FromAccountID string             `json:"from_account_id"`
+	Mode          string             `json:"mode"`
+	Lines         []BatchPaymentLine `json:"lines"`
+}
+
+type BatchPaymentLine struct {
+	To          string          `json:"to"`
+	ToType      string          `json:"to_type,omitempty"`
+	Amount      decimal.Decimal `json:"amount"`
+	Description string          `json:"description,omitempty"`
+}
+
+type SBPTransferRequest struct {
+	UserID        string          `json:"user_id"`
+	FromAccountID string          `json:"from_account_id"`
+	Phone         string          `json:"phone"`
+	BankID        string          `json:"bank_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Description   string          `json:"description,omitempty"`
+}
+
+// SBPCallback — уведомление шлюза СБП: статус исходящего перевода или входящий перевод.
+type SBPCallback struct {
+	Type          string          `json:"type"` // outgoing_status | incoming_transfer
+	TransferID    string          `json:"transfer_id,omitempty"`
+	ExternalID    string          `json:"external_id"`
+	Status        string          `json:"status,omitempty"` // confirmed | rejected
+	Reason        string          `json:"reason,omitempty"`
+	Phone         string          `json:"phone,omitempty"`
+	AccountNumber string          `json:"account_number,omitempty"`
+	Amount        decimal.Decimal `json:"amount,omitempty"`
+	SenderName    string          `json:"sender_name,omitempty"`
+	SenderBankID  string          `json:"sender_bank_id,omitempty"`
+	Description   string          `json:"description,omitempty"`
+}
+
+type SetLimitsRequest struct {
+	Category       string           `json:"category"`
+	PerTransaction *decimal.Decimal `json:"per_transaction,omitempty"`
+	Daily          *decimal.Decimal `json:"daily,omitempty"`
+	Monthly        *decimal.Decimal `json:"monthly,omitempty"`
+	Reset          bool             `json:"reset,omitempty"` // вернуть лимиты банка по умолчанию
+}
+
 type DepositRequest struct {
 	ToAccountID string          `json:"to_account_id"`
 	Amount      decimal.Decimal `json:"amount"`
+	Channel     string          `json:"channel,omitempty"` // branch | atm | transfer_in, по умолчанию branch
+	ATMID       string          `json:"atm_id,omitempty"`
+}
+
+// ATMWithdrawal — выдача наличных по карте с PIN в банкомате.
+type ATMWithdrawal struct {
+	ID                     string          `json:"id"`
+	CardID                 string          `json:"card_id"`
+	AccountID              string          `json:"account_id"`
+	ATMID                  string          `json:"atm_id"`
+	NetworkID              string          `json:"network_id"`
+	NetworkType            string          `json:"network_type"` // on_us | partner | off_us
+	Amount                 decimal.Decimal `json:"amount"`
+	Fee                    decimal.Decimal `json:"fee"`
+	DispensedAmount        decimal.Decimal `json:"dispensed_amount"`
+	Status                 string          `json:"status"` // completed | reversed | partially_reversed
+	TransactionID          string          `json:"transaction_id"`
+	FeeTransactionID       string          `json:"fee_transaction_id,omitempty"`
+	ReversalTransactionIDs []string        `json:"reversal_transaction_ids,omitempty"`
+	CreatedAt              time.Time       `json:"created_at"`
+	ReversedAt             *time.Time      `json:"reversed_at,omitempty"`
+}
+
+type ATMWithdrawalRequest struct {
+	CardNumber string          `json:"card_number"`
+	PIN        string          `json:"pin"`
+	Amount     decimal.Decimal `json:"amount"`
+	ATMID      string          `json:"atm_id"` // необязательно: банкомат определяется по X-ATM-ID
+}
+
+// ATM — банкомат, подключенный к процессингу банка. Сеть банкомата определяет комиссию за снятие.
+type ATM struct {
+	ID        string    `json:"id"`
+	NetworkID string    `json:"network_id"`
+	Location  string    `json:"location,omitempty"`
+	KeyHash   string    `json:"-"` // хэш ключа, которым банкомат подписывает запросы к процессингу
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RegisteredATM возвращается только при регистрации: ключ банкомата показывается один раз и не хранится.
+type RegisteredATM struct {
+	ATM
+	Key string `json:"key"`
+}
+
+type RegisterATMRequest struct {
+	ID        string `json:"id"`
+	NetworkID string `json:"network_id"` // по умолчанию собственная сеть банка
+	Location  string `json:"location"`
+}
+
+type CreateCashbackRuleRequest struct {
+	MerchantID string           `json:"merchant_id"`
+	MCC        string           `json:"mcc"`
+	Percent    decimal.Decimal  `json:"percent"`
+	MonthlyCap *decimal.Decimal `json:"monthly_cap"`
+}
+
+type CashbackPayoutRequest struct {
+	Period string `json:"period"` // YYYY-MM; по умолчанию — все завершенные месяцы
+}
+
+type CreateFeeRuleRequest struct {
+	Operation         string           `json:"operation"`
+	Tier              string           `json:"tier"`
+	Network           string           `json:"network"`
+	MinAmount         decimal.Decimal  `json:"min_amount"`
+	MaxAmount         *decimal.Decimal `json:"max_amount"`
+	Percent           decimal.Decimal  `json:"percent"`
+	Fixed             decimal.Decimal  `json:"fixed"`
+	MinFee            decimal.Decimal  `json:"min_fee"`
+	MaxFee            *decimal.Decimal `json:"max_fee"`
+	FreeMonthlyCount  int              `json:"free_monthly_count"`
+	FreeMonthlyAmount decimal.Decimal  `json:"free_monthly_amount"`
+}
+
+type FeePreviewRequest struct {
+	AccountID   string          `json:"account_id"`
+	ToAccountID string          `json:"to_account_id"` // для transfer: переводы между своими счетами бесплатны
+	Operation   string          `json:"operation"`
+	Amount      decimal.Decimal `json:"amount"`
+	Network     string          `json:"network"`
+	Pending     []Transaction   `json:"-"` // еще не проведенные операции того же пакета, учитываются в бесплатных лимитах
+}
+
+type SetUserTierRequest struct {
+	Tier string `json:"tier"`
+}
+
+// DispenseFailureRequest — сообщение банкомата о том, что наличные не выданы или выданы не полностью.
+type DispenseFailureRequest struct {
+	DispensedAmount decimal.Decimal `json:"dispensed_amount"`
+}
+
+type OpenTermDepositRequest struct {
+	UserID         string          `json:"user_id"`
+	AccountID      string          `json:"account_id"`
+	Amount         decimal.Decimal `json:"amount"`
+	TermMonths     int             `json:"term_months"`
+	RateType       string          `json:"rate_type"`
+	InterestPayout string          `json:"interest_payout"`
+	AutoRollover   bool            `json:"auto_rollover"`
+}
+
+type TermDepositRolloverRequest struct {
+	AutoRollover bool `json:"auto_rollover"`
+}
+
+type SetOverdraftRequest struct {
+	Limit        decimal.Decimal  `json:"limit"`
+	InterestRate *decimal.Decimal `json:"interest_rate,omitempty"`
+}
+
+type FreezeAccountRequest struct {
+	Reason string `json:"reason"`
+}
+
+type CloseAccountRequest struct {
+	UserID              string `json:"user_id"`
+	TransferToAccountID string `json:"transfer_to_account_id,omitempty"`
 }
 
 type ApplyLoanRequest struct {
-	UserID     string          `json:"user_id"` 
+	UserID     string          `json:"user_id"`
 	AccountID  string          `json:"account_id"`
 	Amount     decimal.Decimal `json:"amount"`
 	TermMonths int             `json:"term_months"`
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var moneyRequestConfig = struct {
+	DefaultValidDays int
+	MaxValidDays     int
+}{
+	DefaultValidDays: 7,
+	MaxValidDays:     30,
+}
+
+// resolvePayer находит клиента, которому адресован запрос денег или счет: по телефону или ID.
+func resolvePayer(payer string) (User, error) {
+	payer = strings.TrimSpace(payer)
+	if phone, err := NormalizePhone(payer); err == nil {
+		if user, ok := GetUserByPhone(phone); ok {
+			return user, nil
+		}
+	}
+	if user, ok := GetUser(payer); ok {
+		return user, nil
+	}
+	return User{}, &RecipientError{Code: "RECIPIENT_NOT_FOUND", Message: "no customer with this phone number or ID"}
+}
+
+// checkReceivingAccount проверяет, что деньги по запросу или счету поступят на активный счет его автора.
+func checkReceivingAccount(accountID, userID string) (Account, error) {
+	account, ok := GetAccount(accountID)
+	if !ok {
+		return Account{}, fmt.Errorf("account %s %w", accountID, errNotFound)
+	}
+	if account.UserID != userID {
+		return Account{}, errAccountNotOwned
+	}
+	if account.Status != "active" {
+		return Account{}, fmt.Errorf("account %s is not active", accountID)
+	}
+	return account, nil
+}
+
+func NewMoneyRequest(req CreateMoneyRequestRequest, now time.Time) (MoneyRequest, error) {
+	if !req.Amount.IsPositive() {
+		return MoneyRequest{}, errNonPositiveAmount
+	}
+	if _, err := checkReceivingAccount(req.ToAccountID, req.UserID); err != nil {
+		return MoneyRequest{}, err
+	}
+	payer, err := resolvePayer(req.Payer)
+	if err != nil {
+		return MoneyRequest{}, err
+	}
+	if payer.ID == req.UserID {
+		return MoneyRequest{}, fmt.Errorf("cannot request money from yourself")
+	}
+	requester, ok := GetUser(req.UserID)
+	if !ok {
+		return MoneyRequest{}, fmt.Errorf("user %s %w", req.UserID, errNotFound)
+	}
+
+	validDays := req.ValidDays
+	if validDays == 0 {
+		validDays = moneyRequestConfig.DefaultValidDays
+	}
+	if validDays < 1 || validDays > moneyRequestConfig.MaxValidDays {
+		return MoneyRequest{}, fmt.Errorf("valid_days must be between 1 and %d", moneyRequestConfig.MaxValidDays)
+	}
+
+	return MoneyRequest{
+		ID:            GenerateID(),
+		RequesterID:   requester.ID,
+		ToAccountID:   req.ToAccountID,
+		PayerID:       payer.ID,
+		RequesterName: MaskName(requester),
+		Amount:        req.Amount,
+		Comment:       strings.TrimSpace(req.Comment),
+		Status:        "pending",
+		ExpiresAt:     now.AddDate(0, 0, validDays),
+		CreatedAt:     now,
+	}, nil
+}
+
+// PayMoneyRequest исполняет перевод по запросу со счета плательщика.
+func PayMoneyRequest(request MoneyRequest, fromAccountID string, now time.Time) (MoneyRequest, error) {
+	from, ok := GetAccount(fromAccountID)
+	if !ok {
+		return MoneyRequest{}, fmt.Errorf("account %s %w", fromAccountID, errNotFound)
+	}
+	to, ok := GetAccount(request.ToAccountID)
+	if !ok {
+		return MoneyRequest{}, fmt.Errorf("account %s %w", request.ToAccountID, errNotFound)
+	}
+	if from.ID == to.ID {
+		return MoneyRequest{}, errSameAccount
+	}
+
+	description := fmt.Sprintf("Payment of money request %s", request.ID)
+	if request.Comment != "" {
+		description += ": " + request.Comment
+	}
+	tx := NewTransferTransaction(from, to, request.Amount, description)
+	tx.Timestamp = now
+	txs, _, err := ChargeFee(tx, "transfer", "")
+	if err != nil {
+		return MoneyRequest{}, err
+	}
+	return AcceptMoneyRequest(request.ID, txs, now)
+}
+
+// notifyPayer сообщает плательщику о новом запросе денег или счете.
+func notifyPayer(payerID, subject, body string) {
+	payer, ok := GetUser(payerID)
+	if !ok {
+		return
+	}
+	go func() {
+		if err := SendEmailNotification(payer.Email, subject, body); err != nil {
+			log.Printf("Failed to notify %s: %v", payer.Email, err)
+		}
+	}()
+}
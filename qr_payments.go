@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/skip2/go-qrcode"
+)
+
+var qrConfig = struct {
+	PayloadHost string // хост платежных ссылок НСПК
+	PublicURL   string // базовый адрес платежных ссылок банка
+	DefaultTTL  time.Duration
+	MaxTTL      time.Duration
+	ImageSize   int
+}{
+	PayloadHost: "qr.nspk.ru",
+	PublicURL:   strings.TrimRight(envOrDefault("BANKAPP_PUBLIC_URL", "http://localhost:8080"), "/"),
+	DefaultTTL:  30 * time.Minute,
+	MaxTTL:      72 * time.Hour,
+	ImageSize:   256,
+}
+
+const qrIDAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// qrCallbackClient соединяется только с публичными адресами, в том числе после редиректов и повторного разрешения имени.
+var qrCallbackClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+					return fmt.Errorf("callback address %s is not public", host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+// isPublicIP отсекает loopback, частные, link-local и служебные адреса.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// ValidateCallbackURL принимает только http(s)-адреса, все адреса хоста которых публичные.
+func ValidateCallbackURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("invalid callback URL")
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("callback host %s cannot be resolved", u.Hostname())
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("callback host %s resolves to a non-public address", u.Hostname())
+		}
+	}
+	return nil
+}
+
+// generateQRID формирует 32-символьный идентификатор: AS — статический QR, AD — динамический.
+func generateQRID(qrType string) string {
+	prefix := "AS"
+	if qrType == "dynamic" {
+		prefix = "AD"
+	}
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	max := big.NewInt(int64(len(qrIDAlphabet)))
+	for sb.Len() < 32 {
+		n, _ := rand.Int(rand.Reader, max)
+		sb.WriteByte(qrIDAlphabet[n.Int64()])
+	}
+	return sb.String()
+}
+
+// NewQRPayment проверяет заявку мерчанта и формирует платежное требование с полезной нагрузкой QR и ссылкой.
+func NewQRPayment(merchant Merchant, req CreateQRPaymentRequest, now time.Time) (QRPayment, error) {
+	if req.Type == "" {
+		req.Type = "dynamic"
+	}
+	if req.CallbackURL != "" {
+		if err := ValidateCallbackURL(req.CallbackURL); err != nil {
+			return QRPayment{}, err
+		}
+	}
+
+	qr := QRPayment{
+		ID:          generateQRID(req.Type),
+		MerchantID:  merchant.ID,
+		Type:        req.Type,
+		Purpose:     strings.TrimSpace(req.Purpose),
+		CallbackURL: req.CallbackURL,
+		Status:      "active",
+		CreatedAt:   now,
+	}
+	switch req.Type {
+	case "static":
+		if req.Amount != nil || req.ValidMinutes != 0 {
+			return QRPayment{}, fmt.Errorf("static QR cannot have amount or expiry")
+		}
+	case "dynamic":
+		if req.Amount == nil || !req.Amount.IsPositive() {
+			return QRPayment{}, fmt.Errorf("dynamic QR requires a positive amount")
+		}
+		ttl := qrConfig.DefaultTTL
+		if req.ValidMinutes != 0 {
+			ttl = time.Duration(req.ValidMinutes) * time.Minute
+		}
+		if ttl <= 0 || ttl > qrConfig.MaxTTL {
+			return QRPayment{}, fmt.Errorf("valid_minutes must be between 1 and %d", int(qrConfig.MaxTTL.Minutes()))
+		}
+		amount := req.Amount.Round(2)
+		expiresAt := now.Add(ttl)
+		qr.Amount = &amount
+		qr.ExpiresAt = &expiresAt
+	default:
+		return QRPayment{}, fmt.Errorf("unknown QR type '%s'", req.Type)
+	}
+	qr.Payload = BuildQRPayload(qr)
+	qr.Link = qrConfig.PublicURL + "/pay/" + qr.ID
+	return qr, nil
+}
+
+// BuildQRPayload формирует строку в формате платежных ссылок НСПК:
+// https://qr.nspk.ru/{id}?type=01|02&bank={участник СБП}&sum={копейки}&cur=RUB&crc={CRC16}
+func BuildQRPayload(qr QRPayment) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "https://%s/%s?type=", qrConfig.PayloadHost, qr.ID)
+	if qr.Type == "dynamic" {
+		sb.WriteString("02")
+	} else {
+		sb.WriteString("01")
+	}
+	sb.WriteString("&bank=" + sbpConfig.BankID)
+	if qr.Amount != nil {
+		sb.WriteString("&sum=" + qr.Amount.Shift(2).Round(0).String())
+	}
+	sb.WriteString("&cur=RUB")
+	return fmt.Sprintf("%s&crc=%04X", sb.String(), crc16CCITT([]byte(sb.String())))
+}
+
+// ParseQRPayload извлекает идентификатор требования из строки QR или платежной ссылки.
+func ParseQRPayload(payload string) (string, error) {
+	payload = strings.TrimSpace(payload)
+	if id, ok := strings.CutPrefix(payload, qrConfig.PublicURL+"/pay/"); ok {
+		if id == "" || strings.ContainsAny(id, "/?#") {
+			return "", errInvalidQRPayload
+		}
+		return id, nil
+	}
+
+	u, err := url.Parse(payload)
+	if err != nil || u.Host != qrConfig.PayloadHost {
+		return "", errInvalidQRPayload
+	}
+	i := strings.LastIndex(payload, "&crc=")
+	if i < 0 || payload[i+5:] != fmt.Sprintf("%04X", crc16CCITT([]byte(payload[:i]))) {
+		return "", errInvalidQRPayload
+	}
+	if u.Query().Get("bank") != sbpConfig.BankID {
+		return "", fmt.Errorf("QR code of another bank is not supported")
+	}
+	return strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// crc16CCITT — CRC-16/CCITT-FALSE (полином 0x1021, начальное значение 0xFFFF).
+func crc16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func RenderQRPNG(payload string) ([]byte, error) {
+	return qrcode.Encode(payload, qrcode.Medium, qrConfig.ImageSize)
+}
+
+// CheckQRPayable проверяет, что требование можно оплатить указанной суммой.
+func CheckQRPayable(qr QRPayment, amount decimal.Decimal, now time.Time) error {
+	if qr.Status != "active" {
+		return errQRPaymentNotActive
+	}
+	if qr.ExpiresAt != nil && !now.Before(*qr.ExpiresAt) {
+		return errQRPaymentExpired
+	}
+	if qr.Amount != nil && !qr.Amount.Equal(amount) {
+		return errQRAmountMismatch
+	}
+	return nil
+}
+
+// PayByQR списывает оплату по QR или ссылке со счета покупателя в пользу мерчанта.
+func PayByQR(req PayQRRequest, now time.Time) (QRPayment, Transaction, error) {
+	qrID, err := ParseQRPayload(req.Payload)
+	if err != nil {
+		return QRPayment{}, Transaction{}, err
+	}
+	qr, ok := GetQRPayment(qrID)
+	if !ok || (qr.Payload != strings.TrimSpace(req.Payload) && qr.Link != strings.TrimSpace(req.Payload)) {
+		return QRPayment{}, Transaction{}, errInvalidQRPayload
+	}
+	merchant, err := ResolvePaymentMerchant(qr.MerchantID)
+	if err != nil {
+		return QRPayment{}, Transaction{}, err
+	}
+
+	amount := req.Amount
+	if qr.Amount != nil && amount.IsZero() {
+		amount = *qr.Amount
+	}
+	if !amount.IsPositive() {
+		return QRPayment{}, Transaction{}, errNonPositiveAmount
+	}
+
+	description := fmt.Sprintf("QR payment to %s", merchant.Name)
+	if qr.Purpose != "" {
+		description += ": " + qr.Purpose
+	}
+	tx := Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   req.FromAccountID,
+		Amount:          amount,
+		Timestamp:       now,
+		TransactionType: "qr_payment",
+		Description:     description,
+		MerchantID:      merchant.ID,
+	}
+	qr, err = PayQRPayment(qr.ID, tx, now)
+	if err != nil {
+		return QRPayment{}, Transaction{}, err
+	}
+
+	if qr.CallbackURL != "" {
+		go notifyQRPayment(merchant, qr, tx)
+	}
+	return qr, tx, nil
+}
+
+func SignQRNotification(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyQRPayment отправляет мерчанту уведомление об оплате с подписью HMAC-SHA256 его секретом в заголовке X-Signature.
+// Мерчант, не принимающий уведомления, может опрашивать GET /qr-payments/{qrId}.
+func notifyQRPayment(merchant Merchant, qr QRPayment, tx Transaction) {
+	if merchant.CallbackSecret == "" {
+		log.Printf("Merchant %s has no callback secret, QR payment notification for %s is not sent", merchant.ID, qr.ID)
+		return
+	}
+	body, err := json.Marshal(QRPaymentNotification{
+		QRPaymentID:   qr.ID,
+		MerchantID:    qr.MerchantID,
+		Status:        "paid",
+		TransactionID: tx.ID,
+		Amount:        tx.Amount,
+		PaidAt:        tx.Timestamp,
+	})
+	if err != nil {
+		log.Printf("Failed to build QR payment notification for %s: %v", qr.ID, err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, qr.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to build QR payment notification for %s: %v", qr.ID, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature", SignQRNotification(merchant.CallbackSecret, body))
+
+	resp, err := qrCallbackClient.Do(req)
+	if err != nil {
+		log.Printf("QR payment notification for %s failed: %v", qr.ID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("QR payment notification for %s rejected with status %d", qr.ID, resp.StatusCode)
+	}
+}
+
+// ExpireQRPayments переводит просроченные динамические QR в статус expired.
+func ExpireQRPayments(now time.Time) {
+	for _, merchant := range GetMerchants() {
+		for _, qr := range GetMerchantQRPayments(merchant.ID) {
+			if qr.Status != "active" || qr.ExpiresAt == nil || now.Before(*qr.ExpiresAt) {
+				continue
+			}
+			_, err := UpdateQRPayment(qr.ID, func(q *QRPayment) error {
+				if q.Status == "active" {
+					q.Status = "expired"
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("Failed to expire QR payment %s: %v", qr.ID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var sbpConfig = struct {
+	BankID         string // идентификатор нашего банка в СБП
+	GatewayURL     string // пусто — используется локальный мок-шлюз
+	Secret         string // общий секрет для подписи уведомлений шлюза; без него уведомления не принимаются
+	MinAmount      decimal.Decimal
+	MaxPerTransfer decimal.Decimal
+	DailyLimit     decimal.Decimal
+}{
+	BankID:         "100000000999",
+	GatewayURL:     envOrDefault("BANKAPP_SBP_GATEWAY_URL", ""),
+	Secret:         os.Getenv("BANKAPP_SBP_SECRET"),
+	MinAmount:      decimal.NewFromInt(10),
+	MaxPerTransfer: decimal.NewFromInt(1000000),
+	DailyLimit:     decimal.NewFromInt(1500000),
+}
+
+type SBPOutgoingRequest struct {
+	TransferID   string          `json:"transfer_id"`
+	SenderBankID string          `json:"sender_bank_id"`
+	SenderName   string          `json:"sender_name"`
+	Phone        string          `json:"phone"`
+	BankID       string          `json:"bank_id"`
+	Amount       decimal.Decimal `json:"amount"`
+	Description  string          `json:"description,omitempty"`
+}
+
+// SBPConnector отправляет исходящие переводы в шлюз СБП. Ответ о зачислении
+// приходит позже через POST /sbp/callback.
+type SBPConnector interface {
+	SendTransfer(req SBPOutgoingRequest) (externalID string, err error)
+}
+
+var sbpConnector SBPConnector
+
+func InitSBPConnector() {
+	if sbpConfig.GatewayURL == "" {
+		log.Println("SBP gateway URL is not set, using mock SBP connector")
+		sbpConnector = &MockSBPConnector{Delay: 2 * time.Second}
+		return
+	}
+	if sbpConfig.Secret == "" {
+		log.Println("BANKAPP_SBP_SECRET is not set, SBP gateway requests and callbacks will be rejected")
+	}
+	sbpConnector = &HTTPSBPConnector{
+		URL:    sbpConfig.GatewayURL,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type HTTPSBPConnector struct {
+	URL    string
+	Client *http.Client
+}
+
+func (c *HTTPSBPConnector) SendTransfer(req SBPOutgoingRequest) (string, error) {
+	if sbpConfig.Secret == "" {
+		return "", fmt.Errorf("SBP gateway secret is not configured")
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, c.URL+"/transfers", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-SBP-Signature", SignSBPPayload(body))
+
+	resp, err := c.Client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("SBP gateway unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return "", fmt.Errorf("SBP gateway rejected transfer with status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		ExternalID string `json:"external_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid SBP gateway response: %w", err)
+	}
+	return result.ExternalID, nil
+}
+
+// MockSBPConnector имитирует шлюз СБП локально: принимает перевод и через Delay
+// подтверждает его тем же путем, что и настоящий шлюз. Переводы на телефоны из Reject отклоняются.
+type MockSBPConnector struct {
+	Delay  time.Duration
+	Reject map[string]string // phone -> reason
+}
+
+func (m *MockSBPConnector) SendTransfer(req SBPOutgoingRequest) (string, error) {
+	externalID := "MOCK-" + GenerateID()
+	callback := SBPCallback{
+		Type:       "outgoing_status",
+		TransferID: req.TransferID,
+		ExternalID: externalID,
+		Status:     "confirmed",
+	}
+	if reason, ok := m.Reject[req.Phone]; ok {
+		callback.Status = "rejected"
+		callback.Reason = reason
+	}
+	go func() {
+		time.Sleep(m.Delay)
+		if err := ProcessSBPCallback(callback); err != nil {
+			log.Printf("Mock SBP callback for %s failed: %v", req.TransferID, err)
+		}
+	}()
+	return externalID, nil
+}
+
+func SignSBPPayload(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(sbpConfig.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifySBPSignature(body []byte, signature string) bool {
+	if sbpConfig.Secret == "" {
+		return false
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(sbpConfig.Secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// checkSBPDailyLimitLocked проверяет дневной лимит исходящих переводов СБП со счета. Вызывается под той же
+// блокировкой, что и списание перевода, чтобы параллельные переводы не превысили лимит вместе.
+func checkSBPDailyLimitLocked(transfer SBPTransfer) error {
+	now := transfer.CreatedAt
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	sent := decimal.Zero
+	for _, id := range storage.sbpIndex[transfer.AccountID] {
+		t := storage.sbpTransfers[id]
+		if t.Direction == "outgoing" && t.Status != "rejected" && !t.CreatedAt.Before(startOfDay) {
+			sent = sent.Add(t.Amount)
+		}
+	}
+	if sent.Add(transfer.Amount).GreaterThan(sbpConfig.DailyLimit) {
+		return &LimitExceededError{Scope: "account", OwnerID: transfer.AccountID, Category: "sbp", Period: "daily",
+			Limit: sbpConfig.DailyLimit, Remaining: decimal.Max(sbpConfig.DailyLimit.Sub(sent), decimal.Zero)}
+	}
+	return nil
+}
+
+func ProcessSBPCallback(callback SBPCallback) error {
+	switch callback.Type {
+	case "outgoing_status":
+		return processSBPOutgoingStatus(callback)
+	case "incoming_transfer":
+		_, err := processSBPIncoming(callback)
+		return err
+	default:
+		return fmt.Errorf("unknown SBP callback type '%s'", callback.Type)
+	}
+}
+
+func processSBPOutgoingStatus(callback SBPCallback) error {
+	transfer, ok := GetSBPTransfer(callback.TransferID)
+	if !ok {
+		transfer, ok = GetSBPTransferByExternalID(callback.ExternalID)
+	}
+	if !ok {
+		return fmt.Errorf("SBP transfer %s not found", callback.TransferID)
+	}
+
+	now := time.Now()
+	switch callback.Status {
+	case "confirmed":
+		if _, err := SettleSBPTransfer(transfer.ID, "confirmed", "", nil, now); err != nil {
+			return err
+		}
+		log.Printf("SBP transfer %s confirmed by gateway", transfer.ID)
+	case "rejected":
+		refunds := sbpRefundTransactions(transfer, now)
+		if _, err := SettleSBPTransfer(transfer.ID, "rejected", callback.Reason, refunds, now); err != nil {
+			return err
+		}
+		log.Printf("SBP transfer %s rejected by gateway: %s", transfer.ID, callback.Reason)
+	default:
+		return fmt.Errorf("unknown SBP transfer status '%s'", callback.Status)
+	}
+	return nil
+}
+
+func sbpRefundTransactions(transfer SBPTransfer, now time.Time) []Transaction {
+	refunds := []Transaction{{
+		ID:              GenerateID(),
+		ToAccountID:     transfer.AccountID,
+		Amount:          transfer.Amount,
+		Timestamp:       now,
+		TransactionType: "sbp_refund",
+		Description:     fmt.Sprintf("Refund of rejected SBP transfer (ID: %s)", transfer.ID),
+		RelatedTxID:     transfer.TransactionID,
+	}}
+	if transfer.Fee.IsPositive() {
+		refunds = append(refunds, Transaction{
+			ID:              GenerateID(),
+			ToAccountID:     transfer.AccountID,
+			Amount:          transfer.Fee,
+			Timestamp:       now,
+			TransactionType: "sbp_fee_refund",
+			Description:     fmt.Sprintf("Fee refund for rejected SBP transfer (ID: %s)", transfer.ID),
+			RelatedTxID:     transfer.FeeTransactionID,
+		})
+	}
+	return refunds
+}
+
+func processSBPIncoming(callback SBPCallback) (SBPTransfer, error) {
+	if existing, ok := GetSBPTransferByExternalID(callback.ExternalID); ok {
+		return existing, nil
+	}
+	if callback.ExternalID == "" {
+		return SBPTransfer{}, fmt.Errorf("external ID is required")
+	}
+	if !callback.Amount.IsPositive() {
+		return SBPTransfer{}, errNonPositiveAmount
+	}
+
+	var account Account
+	var err error
+	if callback.AccountNumber != "" {
+		account, err = resolveRecipientAs(callback.AccountNumber, "account_number")
+	} else {
+		account, err = resolveRecipientAs(callback.Phone, "phone")
+	}
+	if err != nil {
+		return SBPTransfer{}, err
+	}
+
+	now := time.Now()
+	transfer := SBPTransfer{
+		ID:               GenerateID(),
+		Direction:        "incoming",
+		UserID:           account.UserID,
+		AccountID:        account.ID,
+		Phone:            callback.Phone,
+		BankID:           callback.SenderBankID,
+		CounterpartyName: callback.SenderName,
+		Amount:           callback.Amount,
+		Fee:              decimal.Zero,
+		Description:      callback.Description,
+		Status:           "confirmed",
+		ExternalID:       callback.ExternalID,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	tx := Transaction{
+		ID:              GenerateID(),
+		ToAccountID:     account.ID,
+		Amount:          callback.Amount,
+		Timestamp:       now,
+		TransactionType: "sbp_incoming",
+		Description:     fmt.Sprintf("SBP transfer from %s", callback.SenderName),
+	}
+	transfer.TransactionID = tx.ID
+
+	if err := AddSBPTransfer(transfer, tx); err != nil {
+		return SBPTransfer{}, err
+	}
+	log.Printf("Incoming SBP transfer %s of %s credited to account %s", transfer.ExternalID, transfer.Amount.String(), account.ID)
+	return transfer, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/smtp"
+	"os"
 	"sync"
 	"time"
 
@@ -67,6 +68,32 @@ var smtpConfig = struct {
 	From:     "bankapp@example.com",
 }
 
+var bankConfig = struct {
+	Name        string
+	BIK         string
+	Branch      string // код подразделения, 4 цифры номера счета после контрольного ключа
+	CorrAccount string // корреспондентский счет в Банке России
+	INN         string
+	KPP         string
+	Address     string
+}{
+	Name:        "Simple Bank",
+	BIK:         mustParseBIK(envOrDefault("BANKAPP_BIK", "044525999")),
+	Branch:      "0000",
+	CorrAccount: envOrDefault("BANKAPP_CORR_ACCOUNT", "30101810400000000999"),
+	INN:         envOrDefault("BANKAPP_INN", "7700000000"),
+	KPP:         envOrDefault("BANKAPP_KPP", "770001001"),
+	Address:     envOrDefault("BANKAPP_ADDRESS", "1 Example St, Moscow, 101000"),
+}
+
+var overdraftConfig = struct {
+	MaxLimit    decimal.Decimal
+	DefaultRate decimal.Decimal
+}{
+	MaxLimit:    decimal.NewFromInt(500000),
+	DefaultRate: decimal.NewFromInt(24),
+}
+
 func SendEmailNotification(to, subject, body string) error {
 	if smtpConfig.Host == "smtp.example.com" {
 		log.Printf("SMTP not configured. Skipping email to %s: Subject: %s", to, subject)
@@ -89,3 +116,19 @@ func SendEmailNotification(to, subject, body string) error {
 	log.Printf("Email sent successfully to %s", to)
 	return nil
 }
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func runPeriodically(name string, interval time.Duration, job func(now time.Time)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		log.Printf("Running %s job", name)
+		job(now)
+	}
+}
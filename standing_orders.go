@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var errStandingOrderNotActive = errors.New("standing order is not active")
+
+var standingOrderFrequencies = map[string]bool{
+	"once":              true,
+	"daily":             true,
+	"weekly":            true,
+	"monthly":           true,
+	"last_business_day": true,
+}
+
+func lastBusinessDay(year int, month time.Month, ref time.Time) time.Time {
+	day := time.Date(year, month+1, 0, ref.Hour(), ref.Minute(), 0, 0, ref.Location())
+	for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		day = day.AddDate(0, 0, -1)
+	}
+	return day
+}
+
+// addMonthsClamped сдвигает дату на n месяцев, сохраняя день месяца; если такого дня нет — берется последний день.
+func addMonthsClamped(t time.Time, n int, dayOfMonth int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), 0, 0, t.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	if dayOfMonth > lastDay {
+		dayOfMonth = lastDay
+	}
+	return first.AddDate(0, 0, dayOfMonth-1)
+}
+
+// FirstStandingOrderRun возвращает дату первого исполнения не раньше StartDate. StartDate в прошлом
+// отклоняется при создании поручения, поэтому пропущенных исполнений не бывает.
+func FirstStandingOrderRun(order StandingOrder) time.Time {
+	if order.Frequency == "last_business_day" {
+		run := lastBusinessDay(order.StartDate.Year(), order.StartDate.Month(), order.StartDate)
+		if run.Before(order.StartDate) {
+			run = lastBusinessDay(order.StartDate.Year(), order.StartDate.Month()+1, order.StartDate)
+		}
+		return run
+	}
+	return order.StartDate
+}
+
+// NextStandingOrderRun возвращает дату следующего исполнения после prev. Для разовых поручений — нулевое время.
+func NextStandingOrderRun(order StandingOrder, prev time.Time) time.Time {
+	switch order.Frequency {
+	case "daily":
+		return prev.AddDate(0, 0, 1)
+	case "weekly":
+		return prev.AddDate(0, 0, 7)
+	case "monthly":
+		return addMonthsClamped(prev, 1, order.DayOfMonth)
+	case "last_business_day":
+		return lastBusinessDay(prev.Year(), prev.Month()+1, prev)
+	default:
+		return time.Time{}
+	}
+}
+
+func standingOrderFinished(order StandingOrder) bool {
+	if order.NextRunAt.IsZero() {
+		return true
+	}
+	if order.MaxExecutions > 0 && order.ExecutionsCount >= order.MaxExecutions {
+		return true
+	}
+	return order.EndDate != nil && order.NextRunAt.After(*order.EndDate)
+}
+
+// skipMissedRuns переносит дату исполнения вперед, пропуская даты, прошедшие за время паузы.
+func skipMissedRuns(order *StandingOrder, now time.Time) {
+	for !order.NextRunAt.IsZero() && order.NextRunAt.Before(now) && order.Frequency != "once" {
+		order.NextRunAt = NextStandingOrderRun(*order, order.NextRunAt)
+	}
+}
+
+func notifyStandingOrderFailure(order StandingOrder, reason error) {
+	user, ok := GetUser(order.UserID)
+	if !ok {
+		return
+	}
+	go func() {
+		subject := "Standing order was not executed"
+		body := fmt.Sprintf("Hello %s,\n\nYour standing order %s for %s could not be executed: %v.\nPlease top up your account.",
+			user.Username, order.ID, order.Amount.String(), reason)
+		if err := SendEmailNotification(user.Email, subject, body); err != nil {
+			log.Printf("Failed to send standing order notification to %s: %v", user.Email, err)
+		}
+	}()
+}
+
+func ExecuteStandingOrder(order StandingOrder, now time.Time) {
+	if current, ok := GetStandingOrder(order.ID); !ok || current.Status != "active" {
+		return
+	}
+
+	execution := StandingOrderExecution{
+		ScheduledAt: order.NextRunAt,
+		ExecutedAt:  now,
+		Status:      "success",
+	}
+
+	tx, err := newInternalTransfer(order.FromAccountID, order.ToAccountID, order.Amount)
+	if err == nil {
+		tx, err = PostStandingOrderTransfer(order.ID, tx)
+	}
+	if errors.Is(err, errStandingOrderNotActive) {
+		return // поручение приостановили или отменили, пока оно готовилось к исполнению
+	}
+	if err != nil {
+		execution.Status = "failed"
+		execution.Error = err.Error()
+	} else {
+		execution.TransactionID = tx.ID
+	}
+
+	updated, updateErr := UpdateStandingOrder(order.ID, func(o *StandingOrder) error {
+		o.Executions = append(o.Executions, execution)
+		if execution.Status == "success" {
+			o.ExecutionsCount++
+		}
+		o.NextRunAt = NextStandingOrderRun(*o, o.NextRunAt)
+		if o.Status == "active" && standingOrderFinished(*o) {
+			o.Status = "completed"
+		}
+		return nil
+	})
+	if updateErr != nil {
+		log.Printf("Failed to record execution of standing order %s: %v", order.ID, updateErr)
+		return
+	}
+
+	var insufficient *InsufficientFundsError
+	if errors.As(err, &insufficient) {
+		notifyStandingOrderFailure(updated, err)
+	}
+	if err != nil {
+		log.Printf("Standing order %s failed: %v", order.ID, err)
+	} else {
+		log.Printf("Standing order %s executed, transaction %s", order.ID, tx.ID)
+	}
+}
+
+func ProcessStandingOrders(now time.Time) {
+	for _, order := range GetDueStandingOrders(now) {
+		ExecuteStandingOrder(order, now)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"go.mozilla.org/pkcs7"
+)
+
+// statementSignatureSize — место под подпись PKCS#7 в PDF (байт); подпись с цепочкой сертификатов должна поместиться.
+const statementSignatureSize = 8192
+
+type statementSigner struct {
+	cert *x509.Certificate
+	key  crypto.PrivateKey
+}
+
+var statementSigning = loadStatementSigner()
+
+var errStatementSigningDisabled = errors.New("PDF statements are unavailable: statement signing certificate is not configured")
+
+// loadStatementSigner читает сертификат и ключ подписи выписок из PEM-файлов BANKAPP_STATEMENT_CERT
+// и BANKAPP_STATEMENT_KEY. Без них подписать выписку нечем и выписки в PDF не выдаются.
+func loadStatementSigner() *statementSigner {
+	certPath := envOrDefault("BANKAPP_STATEMENT_CERT", "")
+	keyPath := envOrDefault("BANKAPP_STATEMENT_KEY", "")
+	if certPath == "" || keyPath == "" {
+		log.Println("BANKAPP_STATEMENT_CERT/BANKAPP_STATEMENT_KEY are not set, PDF statements are disabled")
+		return nil
+	}
+
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		log.Fatalf("Failed to read statement signing certificate: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		log.Fatalf("Statement signing certificate %s is not PEM encoded", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		log.Fatalf("Invalid statement signing certificate: %v", err)
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		log.Fatalf("Failed to read statement signing key: %v", err)
+	}
+	key, err := parseStatementKey(keyPEM)
+	if err != nil {
+		log.Fatalf("Invalid statement signing key: %v", err)
+	}
+	return &statementSigner{cert: cert, key: key}
+}
+
+func parseStatementKey(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		switch key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey:
+			return key, nil
+		}
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key format")
+}
+
+// sign возвращает отсоединенную подпись PKCS#7 (adbe.pkcs7.detached) над данными.
+func (s *statementSigner) sign(data []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSigner(s.cert, s.key, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	sd.Detach()
+	return sd.Finish()
+}
+
+// Разметка страницы A4 в пунктах.
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 40
+	pdfRowHeight  = 12
+	pdfTableFont  = 8
+)
+
+// pdfPage — поток содержимого одной страницы. F1 — Helvetica, F2 — Helvetica-Bold.
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func (p *pdfPage) text(font string, size float64, x, y float64, s string) {
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+// textRight выравнивает по правому краю; ширина считается по метрикам Helvetica, чего достаточно для сумм.
+func (p *pdfPage) textRight(font string, size float64, right, y float64, s string) {
+	p.text(font, size, right-pdfTextWidth(s, size), y, s)
+}
+
+func (p *pdfPage) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "%.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+func pdfTextWidth(s string, size float64) float64 {
+	width := 0
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			width += 556
+		case r == '.' || r == ',' || r == ' ':
+			width += 278
+		case r == '-':
+			width += 333
+		default:
+			width += 556
+		}
+	}
+	return float64(width) * size / 1000
+}
+
+var pdfTranslit = map[rune]string{
+	'А': "A", 'Б': "B", 'В': "V", 'Г': "G", 'Д': "D", 'Е': "E", 'Ё': "E", 'Ж': "Zh", 'З': "Z", 'И': "I", 'Й': "Y",
+	'К': "K", 'Л': "L", 'М': "M", 'Н': "N", 'О': "O", 'П': "P", 'Р': "R", 'С': "S", 'Т': "T", 'У': "U", 'Ф': "F",
+	'Х': "Kh", 'Ц': "Ts", 'Ч': "Ch", 'Ш': "Sh", 'Щ': "Shch", 'Ъ': "", 'Ы': "Y", 'Ь': "", 'Э': "E", 'Ю': "Yu", 'Я': "Ya",
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i", 'й': "y",
+	'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f",
+	'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'№': "No",
+}
+
+// pdfString готовит строку для стандартного шрифта в кодировке WinAnsi: кириллица транслитерируется,
+// прочие символы вне Latin-1 заменяются на '?'.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if t, ok := pdfTranslit[r]; ok {
+			b.WriteString(t)
+			continue
+		}
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// layoutStatementPDF раскладывает выписку по страницам: реквизиты банка и счета, таблица движений, обороты и остатки.
+func layoutStatementPDF(st Statement) []*pdfPage {
+	amount := func(d decimal.Decimal) string { return d.StringFixed(2) }
+	right := float64(pdfPageWidth - pdfMargin)
+
+	var pages []*pdfPage
+	page := &pdfPage{}
+	pages = append(pages, page)
+	y := float64(pdfPageHeight - pdfMargin)
+
+	page.text("F2", 14, pdfMargin, y, bankConfig.Name)
+	y -= 14
+	for _, line := range []string{
+		bankConfig.Address,
+		fmt.Sprintf("BIK %s   Corr. account %s", bankConfig.BIK, bankConfig.CorrAccount),
+		fmt.Sprintf("INN %s   KPP %s", bankConfig.INN, bankConfig.KPP),
+	} {
+		page.text("F1", 9, pdfMargin, y, line)
+		y -= 11
+	}
+	y -= 14
+	page.text("F2", 13, pdfMargin, y, "Account statement")
+	y -= 18
+	for _, row := range [][2]string{
+		{"Account", st.AccountNumber},
+		{"Currency", st.Currency},
+		{"Account holder", st.OwnerName},
+		{"Period", fmt.Sprintf("%s - %s", st.From.Format("02.01.2006"), st.To.AddDate(0, 0, -1).Format("02.01.2006"))},
+		{"Generated", st.GeneratedAt.Format("02.01.2006 15:04:05")},
+		{"Statement ID", st.ID},
+	} {
+		page.text("F2", 9, pdfMargin, y, row[0])
+		page.text("F1", 9, pdfMargin+90, y, row[1])
+		y -= 12
+	}
+	y -= 8
+	page.text("F2", 10, pdfMargin, y, "Opening balance")
+	page.textRight("F2", 10, right, y, amount(st.OpeningBalance)+" "+st.Currency)
+	y -= 22
+
+	tableHeader := func() {
+		page.text("F2", pdfTableFont, pdfMargin, y, "Date")
+		page.text("F2", pdfTableFont, 112, y, "Type")
+		page.text("F2", pdfTableFont, 185, y, "Description")
+		page.text("F2", pdfTableFont, 318, y, "Counterparty")
+		page.textRight("F2", pdfTableFont, 460, y, "Debit")
+		page.textRight("F2", pdfTableFont, 508, y, "Credit")
+		page.textRight("F2", pdfTableFont, right, y, "Balance")
+		page.line(pdfMargin, y-4, right, y-4)
+		y -= pdfRowHeight + 4
+	}
+	newPage := func() {
+		page = &pdfPage{}
+		pages = append(pages, page)
+		y = float64(pdfPageHeight - pdfMargin)
+	}
+
+	tableHeader()
+	if len(st.Entries) == 0 {
+		page.text("F1", pdfTableFont, pdfMargin, y, "No transactions in this period")
+		y -= pdfRowHeight
+	}
+	for _, e := range st.Entries {
+		if y < pdfMargin+30 {
+			newPage()
+			tableHeader()
+		}
+		page.text("F1", pdfTableFont, pdfMargin, y, e.Timestamp.Format("02.01.2006 15:04"))
+		page.text("F1", pdfTableFont, 112, y, truncateRunes(e.TransactionType, 15))
+		page.text("F1", pdfTableFont, 185, y, truncateRunes(e.Description, 28))
+		if e.Counterparty != "" {
+			page.text("F1", pdfTableFont, 318, y, truncateRunes(e.Counterparty, 20))
+		}
+		if e.Direction == "debit" {
+			page.textRight("F1", pdfTableFont, 460, y, amount(e.Amount))
+		} else {
+			page.textRight("F1", pdfTableFont, 508, y, amount(e.Amount))
+		}
+		page.textRight("F1", pdfTableFont, right, y, amount(e.BalanceAfter))
+		y -= pdfRowHeight
+	}
+
+	if y < pdfMargin+90 {
+		newPage()
+	}
+	page.line(pdfMargin, y+8, right, y+8)
+	y -= 6
+	for _, row := range [][2]string{
+		{fmt.Sprintf("Total debit (%d)", st.DebitCount), amount(st.TotalDebit)},
+		{fmt.Sprintf("Total credit (%d)", st.CreditCount), amount(st.TotalCredit)},
+		{"Closing balance", amount(st.ClosingBalance) + " " + st.Currency},
+	} {
+		page.text("F2", 10, pdfMargin, y, row[0])
+		page.textRight("F2", 10, right, y, row[1])
+		y -= 14
+	}
+	y -= 10
+	page.text("F1", 8, pdfMargin, y, "This statement is electronically signed by "+bankConfig.Name+".")
+
+	for i, p := range pages {
+		p.textRight("F1", 8, right, pdfMargin-15, fmt.Sprintf("Page %d of %d", i+1, len(pages)))
+	}
+	return pages
+}
+
+// RenderStatementPDF формирует PDF выписки с подписью банка (adbe.pkcs7.detached). Подпись покрывает
+// весь файл, кроме значения /Contents, границы которого задает /ByteRange.
+func RenderStatementPDF(w io.Writer, st Statement) error {
+	pages := layoutStatementPDF(st)
+
+	// Нумерация объектов: каталог, дерево страниц, два шрифта, подпись, поле подписи, затем страницы с содержимым.
+	const (
+		catalogObj = iota + 1
+		pagesObj
+		fontObj
+		fontBoldObj
+		sigObj
+		sigFieldObj
+		firstPageObj
+	)
+	pageObj := func(i int) int { return firstPageObj + 2*i }
+	objCount := firstPageObj - 1 + 2*len(pages)
+
+	var buf bytes.Buffer
+	offsets := make([]int, objCount+1)
+	beginObj := func(n int) {
+		offsets[n] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n", n)
+	}
+	endObj := func() { buf.WriteString("endobj\n") }
+
+	buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+
+	beginObj(catalogObj)
+	fmt.Fprintf(&buf, "<< /Type /Catalog /Pages %d 0 R /AcroForm << /Fields [%d 0 R] /SigFlags 3 >> >>\n", pagesObj, sigFieldObj)
+	endObj()
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageObj(i))
+	}
+	beginObj(pagesObj)
+	fmt.Fprintf(&buf, "<< /Type /Pages /Kids [%s] /Count %d >>\n", strings.Join(kids, " "), len(pages))
+	endObj()
+
+	beginObj(fontObj)
+	buf.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>\n")
+	endObj()
+	beginObj(fontBoldObj)
+	buf.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>\n")
+	endObj()
+
+	beginObj(sigObj)
+	fmt.Fprintf(&buf, "<< /Type /Sig /Filter /Adobe.PPKLite /SubFilter /adbe.pkcs7.detached /Name (%s) /Reason (%s) /M (D:%s) ",
+		pdfString(bankConfig.Name), pdfString("Account statement "+st.ID), st.GeneratedAt.UTC().Format("20060102150405")+"Z")
+	byteRangeAt := buf.Len()
+	buf.WriteString("/ByteRange [0 0000000000 0000000000 0000000000] ")
+	byteRangeLen := buf.Len() - byteRangeAt
+	buf.WriteString("/Contents ")
+	contentsStart := buf.Len()
+	buf.WriteString("<" + strings.Repeat("0", 2*statementSignatureSize) + ">")
+	contentsEnd := buf.Len()
+	buf.WriteString(" >>\n")
+	endObj()
+
+	beginObj(sigFieldObj)
+	fmt.Fprintf(&buf, "<< /Type /Annot /Subtype /Widget /FT /Sig /T (Signature1) /V %d 0 R /Rect [0 0 0 0] /F 132 /P %d 0 R >>\n",
+		sigObj, pageObj(0))
+	endObj()
+
+	for i, p := range pages {
+		annots := ""
+		if i == 0 {
+			annots = fmt.Sprintf(" /Annots [%d 0 R]", sigFieldObj)
+		}
+		beginObj(pageObj(i))
+		fmt.Fprintf(&buf, "<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> >> /Contents %d 0 R%s >>\n",
+			pagesObj, pdfPageWidth, pdfPageHeight, fontObj, fontBoldObj, pageObj(i)+1, annots)
+		endObj()
+		beginObj(pageObj(i) + 1)
+		fmt.Fprintf(&buf, "<< /Length %d >>\nstream\n", p.content.Len())
+		buf.Write(p.content.Bytes())
+		buf.WriteString("endstream\n")
+		endObj()
+	}
+
+	xrefAt := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", objCount+1)
+	for _, offset := range offsets[1:] {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", objCount+1, catalogObj, xrefAt)
+
+	data := buf.Bytes()
+	byteRange := fmt.Sprintf("/ByteRange [0 %d %d %d]", contentsStart, contentsEnd, len(data)-contentsEnd)
+	copy(data[byteRangeAt:], byteRange+strings.Repeat(" ", byteRangeLen-len(byteRange)))
+
+	signed := make([]byte, 0, len(data)-(contentsEnd-contentsStart))
+	signed = append(signed, data[:contentsStart]...)
+	signed = append(signed, data[contentsEnd:]...)
+	if statementSigning == nil {
+		return errStatementSigningDisabled
+	}
+	signature, err := statementSigning.sign(signed)
+	if err != nil {
+		return fmt.Errorf("failed to sign statement: %w", err)
+	}
+	if len(signature) > statementSignatureSize {
+		return fmt.Errorf("statement signature is too large: %d bytes", len(signature))
+	}
+	hex.Encode(data[contentsStart+1:], signature)
+
+	_, err = w.Write(data)
+	return err
+}
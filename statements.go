@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const maxStatementDays = 366
+
+// statementFormats — форматы выписки: тип содержимого и расширение файла.
+var statementFormats = map[string]struct {
+	ContentType string
+	Extension   string
+}{
+	"csv":     {ContentType: "text/csv; charset=utf-8", Extension: "csv"},
+	"ofx":     {ContentType: "application/x-ofx", Extension: "ofx"},
+	"camt053": {ContentType: "application/xml", Extension: "xml"},
+	"pdf":     {ContentType: "application/pdf", Extension: "pdf"},
+}
+
+// ParseStatementPeriod читает период выписки: period=YYYY-MM (календарный месяц) либо from и to (даты включительно).
+func ParseStatementPeriod(query url.Values) (time.Time, time.Time, error) {
+	if period := query.Get("period"); period != "" {
+		start, err := time.ParseInLocation("2006-01", period, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("period must be in YYYY-MM format")
+		}
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	if query.Get("from") == "" || query.Get("to") == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("either period or both from and to are required")
+	}
+	from, err := parseHistoryTime("from", query.Get("from"), false)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := parseHistoryTime("to", query.Get("to"), true)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be later than from")
+	}
+	if to.Sub(from) > maxStatementDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("statement period must not exceed %d days", maxStatementDays)
+	}
+	return from, to, nil
+}
+
+// BuildStatement собирает выписку за [from, to). Остатки считаются от текущего остатка счета назад,
+// поэтому исходящий остаток за текущий период совпадает с балансом.
+func BuildStatement(accountID string, from, to, now time.Time) (Statement, error) {
+	account, txs, ok := GetAccountLedger(accountID)
+	if !ok {
+		return Statement{}, fmt.Errorf("account %s not found", accountID)
+	}
+	sort.SliceStable(txs, func(i, j int) bool {
+		return txs[i].Timestamp.Before(txs[j].Timestamp)
+	})
+
+	owner, _ := GetUser(account.UserID)
+	ownerName := owner.FullName
+	if ownerName == "" {
+		ownerName = owner.Username
+	}
+	st := Statement{
+		ID:            GenerateID(),
+		AccountID:     account.ID,
+		AccountNumber: account.Number,
+		Currency:      account.Currency,
+		OwnerName:     ownerName,
+		From:          from,
+		To:            to,
+		TotalDebit:    decimal.Zero,
+		TotalCredit:   decimal.Zero,
+		Entries:       make([]StatementEntry, 0),
+		GeneratedAt:   now,
+	}
+
+	opening := account.Balance
+	for _, tx := range txs {
+		if !tx.Timestamp.Before(from) {
+			opening = opening.Sub(signedAmount(tx, account.ID))
+		}
+	}
+	st.OpeningBalance = opening
+
+	balance := opening
+	counterparties := make(map[string]string)
+	for _, tx := range txs {
+		if tx.Timestamp.Before(from) || !tx.Timestamp.Before(to) {
+			continue
+		}
+		entry := StatementEntry{Transaction: tx, Direction: "credit", Counterparty: statementCounterparty(tx, account.ID, counterparties)}
+		if tx.FromAccountID == account.ID {
+			entry.Direction = "debit"
+			st.DebitCount++
+			st.TotalDebit = st.TotalDebit.Add(tx.Amount)
+		} else {
+			st.CreditCount++
+			st.TotalCredit = st.TotalCredit.Add(tx.Amount)
+		}
+		balance = balance.Add(signedAmount(tx, account.ID))
+		entry.BalanceAfter = balance
+		st.Entries = append(st.Entries, entry)
+	}
+	st.ClosingBalance = balance
+	return st, nil
+}
+
+func signedAmount(tx Transaction, accountID string) decimal.Decimal {
+	if tx.FromAccountID == accountID {
+		return tx.Amount.Neg()
+	}
+	return tx.Amount
+}
+
+// statementCounterparty показывает вторую сторону операции: мерчанта или номер счета.
+func statementCounterparty(tx Transaction, accountID string, cache map[string]string) string {
+	key := tx.MerchantID
+	if key == "" {
+		key = tx.FromAccountID
+		if key == accountID {
+			key = tx.ToAccountID
+		}
+	}
+	if key == "" {
+		return ""
+	}
+	if name, ok := cache[key]; ok {
+		return name
+	}
+	name := ""
+	if tx.MerchantID != "" {
+		if merchant, ok := GetMerchant(tx.MerchantID); ok {
+			name = merchant.Name
+		}
+	} else if acc, ok := GetAccount(key); ok {
+		name = acc.Number
+	}
+	cache[key] = name
+	return name
+}
+
+func RenderStatementCSV(w io.Writer, st Statement) error {
+	cw := csv.NewWriter(w)
+	amount := func(d decimal.Decimal) string { return d.StringFixed(2) }
+	records := [][]string{
+		{"Bank", bankConfig.Name, "BIK", bankConfig.BIK},
+		{"Account", st.AccountNumber, "Currency", st.Currency},
+		{"Owner", st.OwnerName},
+		{"Period", st.From.Format("2006-01-02"), st.To.AddDate(0, 0, -1).Format("2006-01-02")},
+		{"Opening balance", amount(st.OpeningBalance)},
+		{},
+		{"Date", "Transaction ID", "Type", "Description", "Counterparty", "Debit", "Credit", "Balance"},
+	}
+	for _, e := range st.Entries {
+		debit, credit := "", ""
+		if e.Direction == "debit" {
+			debit = amount(e.Amount)
+		} else {
+			credit = amount(e.Amount)
+		}
+		records = append(records, []string{e.Timestamp.Format("2006-01-02 15:04:05"), e.ID, e.TransactionType,
+			e.Description, e.Counterparty, debit, credit, amount(e.BalanceAfter)})
+	}
+	records = append(records,
+		[]string{},
+		[]string{"Total debit", amount(st.TotalDebit), "Operations", strconv.Itoa(st.DebitCount)},
+		[]string{"Total credit", amount(st.TotalCredit), "Operations", strconv.Itoa(st.CreditCount)},
+		[]string{"Closing balance", amount(st.ClosingBalance)},
+	)
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// Структуры OFX 2.2: выписка по банковскому счету (STMTRS).
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxTransaction struct {
+	Type     string `xml:"TRNTYPE"`
+	Posted   string `xml:"DTPOSTED"`
+	Amount   string `xml:"TRNAMT"`
+	FITID    string `xml:"FITID"`
+	Name     string `xml:"NAME,omitempty"`
+	Memo     string `xml:"MEMO,omitempty"`
+	Category string `xml:"SIC,omitempty"`
+}
+
+type ofxDocument struct {
+	XMLName xml.Name `xml:"OFX"`
+	Signon  struct {
+		Status   ofxStatus `xml:"SONRS>STATUS"`
+		Server   string    `xml:"SONRS>DTSERVER"`
+		Language string    `xml:"SONRS>LANGUAGE"`
+		Org      string    `xml:"SONRS>FI>ORG"`
+		FID      string    `xml:"SONRS>FI>FID"`
+	} `xml:"SIGNONMSGSRSV1"`
+	Bank struct {
+		UID      string    `xml:"STMTTRNRS>TRNUID"`
+		Status   ofxStatus `xml:"STMTTRNRS>STATUS"`
+		Currency string    `xml:"STMTTRNRS>STMTRS>CURDEF"`
+		Account  struct {
+			BankID string `xml:"BANKID"`
+			AcctID string `xml:"ACCTID"`
+			Type   string `xml:"ACCTTYPE"`
+		} `xml:"STMTTRNRS>STMTRS>BANKACCTFROM"`
+		TranList struct {
+			Start        string           `xml:"DTSTART"`
+			End          string           `xml:"DTEND"`
+			Transactions []ofxTransaction `xml:"STMTTRN"`
+		} `xml:"STMTTRNRS>STMTRS>BANKTRANLIST"`
+		LedgerBalance string `xml:"STMTTRNRS>STMTRS>LEDGERBAL>BALAMT"`
+		LedgerAsOf    string `xml:"STMTTRNRS>STMTRS>LEDGERBAL>DTASOF"`
+	} `xml:"BANKMSGSRSV1"`
+}
+
+const ofxTimeLayout = "20060102150405"
+
+func RenderStatementOFX(w io.Writer, st Statement) error {
+	var doc ofxDocument
+	doc.Signon.Status = ofxStatus{Code: 0, Severity: "INFO"}
+	doc.Signon.Server = st.GeneratedAt.Format(ofxTimeLayout)
+	doc.Signon.Language = "ENG"
+	doc.Signon.Org = bankConfig.Name
+	doc.Signon.FID = bankConfig.BIK
+	doc.Bank.UID = st.ID
+	doc.Bank.Status = ofxStatus{Code: 0, Severity: "INFO"}
+	doc.Bank.Currency = st.Currency
+	doc.Bank.Account.BankID = bankConfig.BIK
+	doc.Bank.Account.AcctID = st.AccountNumber
+	doc.Bank.Account.Type = "CHECKING"
+	doc.Bank.TranList.Start = st.From.Format(ofxTimeLayout)
+	doc.Bank.TranList.End = st.To.Format(ofxTimeLayout)
+	for _, e := range st.Entries {
+		t := ofxTransaction{
+			Type:   "CREDIT",
+			Posted: e.Timestamp.Format(ofxTimeLayout),
+			Amount: e.Amount.StringFixed(2),
+			FITID:  e.ID,
+			Name:   truncateRunes(e.Counterparty, 32),
+			Memo:   truncateRunes(e.Description, 255),
+		}
+		if e.Direction == "debit" {
+			t.Type = "DEBIT"
+			t.Amount = e.Amount.Neg().StringFixed(2)
+		}
+		doc.Bank.TranList.Transactions = append(doc.Bank.TranList.Transactions, t)
+	}
+	doc.Bank.LedgerBalance = st.ClosingBalance.StringFixed(2)
+	doc.Bank.LedgerAsOf = st.To.Format(ofxTimeLayout)
+
+	if _, err := io.WriteString(w, xml.Header+
+		`<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+// Структуры ISO 20022 camt.053.001.08 (BankToCustomerStatement) в объеме, нужном для выписки по одному счету.
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtBalance struct {
+	Type      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	Indicator string     `xml:"CdtDbtInd"`
+	Date      string     `xml:"Dt>Dt"`
+}
+
+type camtTotals struct {
+	Count int    `xml:"NbOfNtries"`
+	Sum   string `xml:"Sum"`
+}
+
+type camtParty struct {
+	Name string `xml:"Pty>Nm"`
+}
+
+type camtParties struct {
+	Debtor   *camtParty `xml:"Dbtr,omitempty"`
+	Creditor *camtParty `xml:"Cdtr,omitempty"`
+}
+
+type camtEntry struct {
+	Reference     string       `xml:"NtryRef"`
+	Amount        camtAmount   `xml:"Amt"`
+	Indicator     string       `xml:"CdtDbtInd"`
+	Status        string       `xml:"Sts>Cd"`
+	BookingDate   string       `xml:"BookgDt>DtTm"`
+	ValueDate     string       `xml:"ValDt>Dt"`
+	ServicerRef   string       `xml:"AcctSvcrRef"`
+	BankTxCode    string       `xml:"BkTxCd>Prtry>Cd"`
+	EndToEndID    string       `xml:"NtryDtls>TxDtls>Refs>EndToEndId"`
+	TransactionID string       `xml:"NtryDtls>TxDtls>Refs>TxId"`
+	Parties       *camtParties `xml:"NtryDtls>TxDtls>RltdPties,omitempty"`
+	Unstructured  string       `xml:"NtryDtls>TxDtls>RmtInf>Ustrd,omitempty"`
+}
+
+type camtDocument struct {
+	XMLName   xml.Name `xml:"urn:iso:std:iso:20022:tech:xsd:camt.053.001.08 Document"`
+	MessageID string   `xml:"BkToCstmrStmt>GrpHdr>MsgId"`
+	Created   string   `xml:"BkToCstmrStmt>GrpHdr>CreDtTm"`
+	Stmt      struct {
+		ID       string `xml:"Id"`
+		Created  string `xml:"CreDtTm"`
+		FromDate string `xml:"FrToDt>FrDtTm"`
+		ToDate   string `xml:"FrToDt>ToDtTm"`
+		Account  struct {
+			ID       string `xml:"Id>Othr>Id"`
+			Currency string `xml:"Ccy"`
+			Owner    string `xml:"Ownr>Nm"`
+			Servicer string `xml:"Svcr>FinInstnId>ClrSysMmbId>MmbId"`
+			Bank     string `xml:"Svcr>FinInstnId>Nm"`
+		} `xml:"Acct"`
+		Balances []camtBalance `xml:"Bal"`
+		Summary  struct {
+			Entries camtTotals `xml:"TtlNtries"`
+			Credits camtTotals `xml:"TtlCdtNtries"`
+			Debits  camtTotals `xml:"TtlDbtNtries"`
+		} `xml:"TxsSummry"`
+		Entries []camtEntry `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+func camtIndicator(amount decimal.Decimal) (string, string) {
+	if amount.IsNegative() {
+		return "DBIT", amount.Neg().StringFixed(2)
+	}
+	return "CRDT", amount.StringFixed(2)
+}
+
+func RenderStatementCAMT053(w io.Writer, st Statement) error {
+	var doc camtDocument
+	doc.MessageID = st.ID
+	doc.Created = st.GeneratedAt.Format(time.RFC3339)
+	doc.Stmt.ID = st.ID
+	doc.Stmt.Created = doc.Created
+	doc.Stmt.FromDate = st.From.Format(time.RFC3339)
+	doc.Stmt.ToDate = st.To.Format(time.RFC3339)
+	doc.Stmt.Account.ID = st.AccountNumber
+	doc.Stmt.Account.Currency = st.Currency
+	doc.Stmt.Account.Owner = st.OwnerName
+	doc.Stmt.Account.Servicer = bankConfig.BIK
+	doc.Stmt.Account.Bank = bankConfig.Name
+
+	for _, b := range []struct {
+		code   string
+		amount decimal.Decimal
+		date   time.Time
+	}{
+		{"OPBD", st.OpeningBalance, st.From},
+		{"CLBD", st.ClosingBalance, st.To.AddDate(0, 0, -1)},
+	} {
+		indicator, value := camtIndicator(b.amount)
+		doc.Stmt.Balances = append(doc.Stmt.Balances, camtBalance{
+			Type:      b.code,
+			Amount:    camtAmount{Currency: st.Currency, Value: value},
+			Indicator: indicator,
+			Date:      b.date.Format("2006-01-02"),
+		})
+	}
+	doc.Stmt.Summary.Entries = camtTotals{Count: len(st.Entries), Sum: st.TotalDebit.Add(st.TotalCredit).StringFixed(2)}
+	doc.Stmt.Summary.Credits = camtTotals{Count: st.CreditCount, Sum: st.TotalCredit.StringFixed(2)}
+	doc.Stmt.Summary.Debits = camtTotals{Count: st.DebitCount, Sum: st.TotalDebit.StringFixed(2)}
+
+	for _, e := range st.Entries {
+		entry := camtEntry{
+			Reference:     e.ID,
+			Amount:        camtAmount{Currency: st.Currency, Value: e.Amount.StringFixed(2)},
+			Indicator:     "CRDT",
+			Status:        "BOOK",
+			BookingDate:   e.Timestamp.Format(time.RFC3339),
+			ValueDate:     e.Timestamp.Format("2006-01-02"),
+			ServicerRef:   e.ID,
+			BankTxCode:    e.TransactionType,
+			Unstructured:  truncateRunes(e.Description, 140),
+			EndToEndID:    "NOTPROVIDED",
+			TransactionID: e.ID,
+		}
+		// Вторая сторона — получатель для списаний и плательщик для зачислений.
+		var party *camtParty
+		if e.Counterparty != "" {
+			party = &camtParty{Name: e.Counterparty}
+		}
+		if e.Direction == "debit" {
+			entry.Indicator = "DBIT"
+			if party != nil {
+				entry.Parties = &camtParties{Creditor: party}
+			}
+		} else if party != nil {
+			entry.Parties = &camtParties{Debtor: party}
+		}
+		doc.Stmt.Entries = append(doc.Stmt.Entries, entry)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
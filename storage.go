@@ -2,39 +2,124 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
 
 type InMemoryStorage struct {
-	users        map[string]User     // key: UserID
-	accounts     map[string]Account  // key: AccountID
-	cards        map[string]Card     // key: CardID
-	loans        map[string]Loan     // key: LoanID
-	transactions []Transaction       // Просто список всех транзакций
-	userIndex    map[string]string   // key: Username -> UserID (для быстрой проверки уникальности)
-	emailIndex   map[string]string   // key: Email -> UserID
-	accountIndex map[string][]string // key: UserID -> []AccountID
-	cardIndex    map[string][]string // key: AccountID -> []CardID
-	loanIndex    map[string][]string // key: UserID -> []LoanID
-	mu           sync.RWMutex        // Mutex для защиты доступа к данным
+	users               map[string]User                       // key: UserID
+	accounts            map[string]Account                    // key: AccountID
+	cards               map[string]Card                       // key: CardID
+	loans               map[string]Loan                       // key: LoanID
+	termDeposits        map[string]TermDeposit                // key: TermDepositID
+	transactions        []Transaction                         // Просто список всех транзакций
+	userIndex           map[string]string                     // key: Username -> UserID (для быстрой проверки уникальности)
+	emailIndex          map[string]string                     // key: Email -> UserID
+	phoneIndex          map[string]string                     // key: Phone -> UserID
+	accountIndex        map[string][]string                   // key: UserID -> []AccountID
+	numberIndex         map[string]string                     // key: Account.Number -> AccountID
+	cardIndex           map[string][]string                   // key: AccountID -> []CardID
+	cardNumberIndex     map[string]string                     // key: Card.NumberHash -> CardID
+	cardTokens          map[string]CardToken                  // key: Token
+	cardTokenIndex      map[string][]string                   // key: CardID -> []Token
+	revealChallenges    map[string]CardRevealChallenge        // key: ChallengeID
+	cardPaymentRefs     map[string]CardPaymentRef             // key: "terminal:RRN"
+	merchants           map[string]Merchant                   // key: MerchantID
+	acceptorIndex       map[string]string                     // key: Merchant.AcceptorID -> MerchantID
+	settlements         map[string]MerchantSettlement         // key: SettlementID
+	settlementIndex     map[string][]string                   // key: MerchantID -> []SettlementID
+	settledTransactions map[string]string                     // key: TransactionID -> SettlementID
+	qrPayments          map[string]QRPayment                  // key: QRPayment.ID
+	merchantQRIndex     map[string][]string                   // key: MerchantID -> []QRPayment.ID
+	moneyRequests       map[string]MoneyRequest               // key: MoneyRequest.ID
+	moneyRequestIndex   map[string][]string                   // key: UserID -> []MoneyRequestID (отправленные и входящие)
+	invoices            map[string]Invoice                    // key: InvoiceID
+	invoiceIndex        map[string][]string                   // key: UserID -> []InvoiceID (выставленные и полученные)
+	billPayments        map[string]BillPayment                // key: BillPaymentID
+	billPaymentIndex    map[string][]string                   // key: UserID -> []BillPaymentID
+	billTemplates       map[string]BillTemplate               // key: BillTemplateID
+	billTemplateIndex   map[string][]string                   // key: UserID -> []BillTemplateID
+	atmWithdrawals      map[string]ATMWithdrawal              // key: ATMWithdrawalID
+	atms                map[string]ATM                        // key: ATMID
+	feeRules            map[string]FeeRule                    // key: FeeRuleID
+	cashbackRules       map[string]CashbackRule               // key: CashbackRuleID
+	cashbackRewards     map[string]CashbackReward             // key: CashbackRewardID
+	cashbackIndex       map[string][]string                   // key: UserID -> []CashbackRewardID
+	cashbackTxIndex     map[string]string                     // key: TransactionID (покупка) -> CashbackRewardID
+	accountTxIndex      map[string][]int                      // key: AccountID -> позиции транзакций счета в storage.transactions
+	loanIndex           map[string][]string                   // key: UserID -> []LoanID
+	termDepositIndex    map[string][]string                   // key: UserID -> []TermDepositID
+	confirmations       map[string]TransferConfirmation       // key: ConfirmationID
+	standingOrders      map[string]StandingOrder              // key: StandingOrderID
+	standingOrderIndex  map[string][]string                   // key: UserID -> []StandingOrderID
+	batches             map[string]PaymentBatch               // key: BatchID
+	batchIndex          map[string][]string                   // key: UserID -> []BatchID
+	sbpTransfers        map[string]SBPTransfer                // key: SBPTransferID
+	sbpExternalIndex    map[string]string                     // key: ExternalID -> SBPTransferID
+	sbpIndex            map[string][]string                   // key: AccountID -> []SBPTransferID
+	customLimits        map[string]map[string]OperationLimits // key: "scope:ownerID" -> category -> лимиты
+	mu                  sync.RWMutex                          // Mutex для защиты доступа к данным
 }
 
 var storage *InMemoryStorage
 
 func InitStorage() {
 	storage = &InMemoryStorage{
-		users:        make(map[string]User),
-		accounts:     make(map[string]Account),
-		cards:        make(map[string]Card),
-		loans:        make(map[string]Loan),
-		transactions: make([]Transaction, 0),
-		userIndex:    make(map[string]string),
-		emailIndex:   make(map[string]string),
-		accountIndex: make(map[string][]string),
-		cardIndex:    make(map[string][]string),
-		loanIndex:    make(map[string][]string),
+		users:               make(map[string]User),
+		accounts:            make(map[string]Account),
+		cards:               make(map[string]Card),
+		loans:               make(map[string]Loan),
+		termDeposits:        make(map[string]TermDeposit),
+		transactions:        make([]Transaction, 0),
+		userIndex:           make(map[string]string),
+		emailIndex:          make(map[string]string),
+		phoneIndex:          make(map[string]string),
+		accountIndex:        make(map[string][]string),
+		numberIndex:         make(map[string]string),
+		cardIndex:           make(map[string][]string),
+		cardNumberIndex:     make(map[string]string),
+		cardTokens:          make(map[string]CardToken),
+		cardTokenIndex:      make(map[string][]string),
+		revealChallenges:    make(map[string]CardRevealChallenge),
+		cardPaymentRefs:     make(map[string]CardPaymentRef),
+		merchants:           make(map[string]Merchant),
+		acceptorIndex:       make(map[string]string),
+		settlements:         make(map[string]MerchantSettlement),
+		settlementIndex:     make(map[string][]string),
+		settledTransactions: make(map[string]string),
+		qrPayments:          make(map[string]QRPayment),
+		merchantQRIndex:     make(map[string][]string),
+		moneyRequests:       make(map[string]MoneyRequest),
+		moneyRequestIndex:   make(map[string][]string),
+		invoices:            make(map[string]Invoice),
+		invoiceIndex:        make(map[string][]string),
+		billPayments:        make(map[string]BillPayment),
+		billPaymentIndex:    make(map[string][]string),
+		billTemplates:       make(map[string]BillTemplate),
+		billTemplateIndex:   make(map[string][]string),
+		atmWithdrawals:      make(map[string]ATMWithdrawal),
+		atms:                make(map[string]ATM),
+		feeRules:            make(map[string]FeeRule),
+		cashbackRules:       make(map[string]CashbackRule),
+		cashbackRewards:     make(map[string]CashbackReward),
+		cashbackIndex:       make(map[string][]string),
+		cashbackTxIndex:     make(map[string]string),
+		accountTxIndex:      make(map[string][]int),
+		loanIndex:           make(map[string][]string),
+		termDepositIndex:    make(map[string][]string),
+		confirmations:       make(map[string]TransferConfirmation),
+		standingOrders:      make(map[string]StandingOrder),
+		standingOrderIndex:  make(map[string][]string),
+		batches:             make(map[string]PaymentBatch),
+		batchIndex:          make(map[string][]string),
+		sbpTransfers:        make(map[string]SBPTransfer),
+		sbpExternalIndex:    make(map[string]string),
+		sbpIndex:            make(map[string][]string),
+		customLimits:        make(map[string]map[string]OperationLimits),
 	}
 }
 
@@ -48,13 +133,72 @@ func AddUser(user User) error {
 	if _, exists := storage.emailIndex[user.Email]; exists {
 		return fmt.Errorf("email '%s' already registered", user.Email)
 	}
+	if _, exists := storage.phoneIndex[user.Phone]; exists && user.Phone != "" {
+		return fmt.Errorf("phone '%s' already registered", user.Phone)
+	}
 
 	storage.users[user.ID] = user
 	storage.userIndex[user.Username] = user.ID
 	storage.emailIndex[user.Email] = user.ID
+	if user.Phone != "" {
+		storage.phoneIndex[user.Phone] = user.ID
+	}
 	return nil
 }
 
+func GetUser(userID string) (User, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	user, ok := storage.users[userID]
+	return user, ok
+}
+
+func GetUserByPhone(phone string) (User, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	userID, ok := storage.phoneIndex[phone]
+	if !ok {
+		return User{}, false
+	}
+	user, ok := storage.users[userID]
+	return user, ok
+}
+
+func SetUserDefaultAccount(userID, accountID string) (User, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	user, ok := storage.users[userID]
+	if !ok {
+		return User{}, fmt.Errorf("user %s not found", userID)
+	}
+	acc, ok := storage.accounts[accountID]
+	if !ok {
+		return User{}, fmt.Errorf("account %s not found", accountID)
+	}
+	if acc.UserID != userID {
+		return User{}, fmt.Errorf("account %s does not belong to user %s", accountID, userID)
+	}
+	if err := checkAccountActiveLocked(acc); err != nil {
+		return User{}, err
+	}
+	user.DefaultAccountID = accountID
+	storage.users[userID] = user
+	return user, nil
+}
+
+func SetUserTier(userID, tier string) (User, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	user, ok := storage.users[userID]
+	if !ok {
+		return User{}, fmt.Errorf("user %s not found", userID)
+	}
+	user.Tier = tier
+	storage.users[userID] = user
+	return user, nil
+}
+
 func GetUserByUsername(username string) (User, bool) {
 	storage.mu.RLock()
 	defer storage.mu.RUnlock()
@@ -72,11 +216,26 @@ func AddAccount(account Account) error {
 	if _, exists := storage.users[account.UserID]; !exists {
 		return fmt.Errorf("user with ID %s not found", account.UserID)
 	}
+	if _, exists := storage.numberIndex[account.Number]; exists {
+		return errAccountNumberTaken
+	}
 	storage.accounts[account.ID] = account
 	storage.accountIndex[account.UserID] = append(storage.accountIndex[account.UserID], account.ID)
+	storage.numberIndex[account.Number] = account.ID
 	return nil
 }
 
+func GetAccountByNumber(number string) (Account, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	accountID, ok := storage.numberIndex[number]
+	if !ok {
+		return Account{}, false
+	}
+	acc, ok := storage.accounts[accountID]
+	return acc, ok
+}
+
 func GetAccount(accountID string) (Account, bool) {
 	storage.mu.RLock()
 	defer storage.mu.RUnlock()
@@ -84,6 +243,94 @@ func GetAccount(accountID string) (Account, bool) {
 	return acc, ok
 }
 
+func SetAccountStatus(accountID, status, reason string, now time.Time) (Account, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	acc, ok := storage.accounts[accountID]
+	if !ok {
+		return Account{}, fmt.Errorf("account %s not found", accountID)
+	}
+	if acc.Status == "closed" {
+		return Account{}, &AccountStatusError{AccountID: accountID, Status: acc.Status}
+	}
+	acc.Status = status
+	acc.StatusReason = reason
+	acc.StatusChangedAt = &now
+	storage.accounts[accountID] = acc
+	return acc, nil
+}
+
+// CloseAccount закрывает счет клиента. Остаток, если он есть, переводится на sweepToID.
+func CloseAccount(accountID, userID, sweepToID string, now time.Time) (Account, *Transaction, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	acc, ok := storage.accounts[accountID]
+	if !ok {
+		return Account{}, nil, fmt.Errorf("account %s not found", accountID)
+	}
+	if acc.UserID != userID {
+		return Account{}, nil, fmt.Errorf("account %s does not belong to user %s", accountID, userID)
+	}
+	if err := checkAccountActiveLocked(acc); err != nil {
+		return Account{}, nil, err
+	}
+	for _, loanID := range storage.loanIndex[acc.UserID] {
+		if loan := storage.loans[loanID]; loan.AccountID == accountID && loan.RemainingAmount.IsPositive() {
+			return Account{}, nil, fmt.Errorf("account %s has an active loan %s", accountID, loan.ID)
+		}
+	}
+	for _, depositID := range storage.termDepositIndex[acc.UserID] {
+		if deposit := storage.termDeposits[depositID]; deposit.AccountID == accountID && deposit.Status == "active" {
+			return Account{}, nil, fmt.Errorf("account %s has an active term deposit %s", accountID, deposit.ID)
+		}
+	}
+	for _, cardID := range storage.cardIndex[accountID] {
+		if card := storage.cards[cardID]; card.IsLive(now) {
+			return Account{}, nil, fmt.Errorf("account %s has an active card %s", accountID, card.ID)
+		}
+	}
+	if acc.Balance.IsNegative() || acc.OverdraftAccrued.IsPositive() {
+		return Account{}, nil, fmt.Errorf("account %s has an outstanding overdraft debt", accountID)
+	}
+
+	var sweep *Transaction
+	if acc.Balance.IsPositive() {
+		if sweepToID == "" {
+			return Account{}, nil, fmt.Errorf("account %s has a non-zero balance, specify an account to transfer it to", accountID)
+		}
+		target, ok := storage.accounts[sweepToID]
+		if !ok || sweepToID == accountID {
+			return Account{}, nil, fmt.Errorf("account %s not found", sweepToID)
+		}
+		if target.UserID != userID {
+			return Account{}, nil, fmt.Errorf("account %s does not belong to user %s", sweepToID, userID)
+		}
+		tx := Transaction{
+			ID:              GenerateID(),
+			FromAccountID:   accountID,
+			ToAccountID:     sweepToID,
+			Amount:          acc.Balance,
+			Timestamp:       now,
+			TransactionType: "account_closure",
+			Description:     fmt.Sprintf("Balance transfer on closure of account %s", acc.Number),
+		}
+		if err := applyTransactionLocked(tx); err != nil {
+			return Account{}, nil, err
+		}
+		sweep = &tx
+		acc = storage.accounts[accountID]
+	}
+
+	acc.Status = "closed"
+	acc.StatusReason = "closed by customer"
+	acc.StatusChangedAt = &now
+	acc.OverdraftLimit = decimal.Zero
+	storage.accounts[accountID] = acc
+	return acc, sweep, nil
+}
+
 func GetUserAccounts(userID string) []Account {
 	storage.mu.RLock()
 	defer storage.mu.RUnlock()
@@ -97,50 +344,334 @@ func GetUserAccounts(userID string) []Account {
 	return accounts
 }
 
-func UpdateAccountBalance(accountID string, amount decimal.Decimal) error {
+// appendTransactionLocked сохраняет транзакцию в журнале и в индексах счетов отправителя и получателя.
+func appendTransactionLocked(tx Transaction) {
+	pos := len(storage.transactions)
+	storage.transactions = append(storage.transactions, tx)
+	for _, id := range transactionAccountIDs(tx) {
+		storage.accountTxIndex[id] = append(storage.accountTxIndex[id], pos)
+	}
+}
+
+// truncateTransactionsLocked откатывает журнал до n транзакций вместе с индексами счетов.
+func truncateTransactionsLocked(n int) {
+	for pos := len(storage.transactions) - 1; pos >= n; pos-- {
+		for _, id := range transactionAccountIDs(storage.transactions[pos]) {
+			positions := storage.accountTxIndex[id]
+			storage.accountTxIndex[id] = positions[:len(positions)-1]
+		}
+	}
+	storage.transactions = storage.transactions[:n]
+}
+
+func transactionAccountIDs(tx Transaction) []string {
+	switch {
+	case tx.FromAccountID == "":
+		return []string{tx.ToAccountID}
+	case tx.ToAccountID == "" || tx.ToAccountID == tx.FromAccountID:
+		return []string{tx.FromAccountID}
+	default:
+		return []string{tx.FromAccountID, tx.ToAccountID}
+	}
+}
+
+func checkAccountActiveLocked(acc Account) error {
+	if acc.Status != "active" {
+		return &AccountStatusError{AccountID: acc.ID, Status: acc.Status}
+	}
+	return nil
+}
+
+// checkDebitLocked проверяет, что списание не выходит за пределы остатка и лимита овердрафта.
+func checkDebitLocked(acc Account, amount decimal.Decimal) error {
+	available := acc.AvailableBalance()
+	if available.LessThan(amount) {
+		if available.IsNegative() {
+			available = decimal.Zero
+		}
+		return &InsufficientFundsError{AccountID: acc.ID, Requested: amount, Available: available}
+	}
+	return nil
+}
+
+// checkTransactionLocked выполняет все проверки проводки, не изменяя балансы.
+func checkTransactionLocked(tx Transaction) error {
+	if tx.FromAccountID != "" {
+		from, ok := storage.accounts[tx.FromAccountID]
+		if !ok {
+			return fmt.Errorf("account %s not found", tx.FromAccountID)
+		}
+		if err := checkAccountActiveLocked(from); err != nil {
+			return err
+		}
+		if err := checkCardDebitLocked(tx); err != nil {
+			return err
+		}
+		if err := checkTransactionLimitsLocked(tx, from); err != nil {
+			return err
+		}
+		if err := checkDebitLocked(from, tx.Amount); err != nil {
+			return err
+		}
+	}
+	if tx.ToAccountID != "" {
+		to, ok := storage.accounts[tx.ToAccountID]
+		if !ok {
+			return fmt.Errorf("account %s not found", tx.ToAccountID)
+		}
+		if err := checkAccountActiveLocked(to); err != nil {
+			return err
+		}
+		// конвертации при переводе нет, поэтому валюты счетов должны совпадать
+		if from, ok := storage.accounts[tx.FromAccountID]; ok && from.Currency != to.Currency {
+			return errCurrencyMismatch
+		}
+	}
+	return nil
+}
+
+func applyTransactionLocked(tx Transaction) error {
+	if err := checkTransactionLocked(tx); err != nil {
+		return err
+	}
+
+	if tx.FromAccountID != "" {
+		from := storage.accounts[tx.FromAccountID]
+		from.Balance = from.Balance.Sub(tx.Amount)
+		storage.accounts[from.ID] = from
+	}
+	if tx.ToAccountID != "" {
+		to := storage.accounts[tx.ToAccountID]
+		to.Balance = to.Balance.Add(tx.Amount)
+		storage.accounts[to.ID] = to
+	}
+	if tx.CardID != "" && tx.FromAccountID != "" {
+		consumeSingleUseCardLocked(tx)
+	}
+	appendTransactionLocked(tx)
+	return nil
+}
+
+// checkCardDebitLocked повторяет проверку статуса карты под блокировкой, чтобы одноразовую
+// карту нельзя было использовать дважды параллельными платежами. Карта проверяется, только если
+// списание идет с ее счета: возврат, списанный с расчетного счета мерчанта, ссылается на карту покупателя.
+func checkCardDebitLocked(tx Transaction) error {
+	if tx.CardID == "" {
+		return nil
+	}
+	card, ok := storage.cards[tx.CardID]
+	if !ok {
+		return fmt.Errorf("card %s not found", tx.CardID)
+	}
+	if card.AccountID != tx.FromAccountID {
+		return nil
+	}
+	return CheckCardUsable(card, time.Now())
+}
+
+// consumeSingleUseCardLocked деактивирует одноразовую карту после первого успешного списания.
+func consumeSingleUseCardLocked(tx Transaction) {
+	card, ok := storage.cards[tx.CardID]
+	if !ok || card.Type != "single_use" || card.AccountID != tx.FromAccountID {
+		return
+	}
+	now := tx.Timestamp
+	card.Status = "used"
+	card.StatusReason = "single-use card was used for a payment"
+	card.StatusChangedAt = &now
+	storage.cards[card.ID] = card
+}
+
+// PostTransaction атомарно проводит транзакцию: списывает, зачисляет и сохраняет ее.
+func PostTransaction(tx Transaction) error {
 	storage.mu.Lock()
 	defer storage.mu.Unlock()
+	return applyTransactionLocked(tx)
+}
 
-	acc, ok := storage.accounts[accountID]
-	if !ok {
-		return fmt.Errorf("account %s not found", accountID)
+// CheckTransaction проверяет, может ли транзакция быть проведена сейчас (статусы, лимиты, средства).
+func CheckTransaction(tx Transaction) error {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	return checkTransactionLocked(tx)
+}
+
+// PostTransactionsAtomic проводит все транзакции или ни одной: при ошибке балансы счетов откатываются.
+func PostTransactionsAtomic(txs []Transaction) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	return applyTransactionsAtomicLocked(txs)
+}
+
+func applyTransactionsAtomicLocked(txs []Transaction) error {
+	snapshot := make(map[string]Account)
+	for _, tx := range txs {
+		for _, id := range []string{tx.FromAccountID, tx.ToAccountID} {
+			if acc, ok := storage.accounts[id]; ok {
+				snapshot[id] = acc
+			}
+		}
+	}
+	cardSnapshot := make(map[string]Card)
+	for _, tx := range txs {
+		if card, ok := storage.cards[tx.CardID]; ok {
+			cardSnapshot[card.ID] = card
+		}
 	}
+	txCount := len(storage.transactions)
 
-	newBalance := acc.Balance.Add(amount)
-	if newBalance.IsNegative() {
+	for i, tx := range txs {
+		if err := applyTransactionLocked(tx); err != nil {
+			for id, acc := range snapshot {
+				storage.accounts[id] = acc
+			}
+			for id, card := range cardSnapshot {
+				storage.cards[id] = card
+			}
+			truncateTransactionsLocked(txCount)
+			if len(txs) == 1 {
+				return err
+			}
+			return fmt.Errorf("transaction %d of %d: %w", i+1, len(txs), err)
+		}
 	}
+	return nil
+}
+
+func SetAccountOverdraft(accountID string, limit, rate decimal.Decimal) (Account, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
 
-	acc.Balance = newBalance
+	acc, ok := storage.accounts[accountID]
+	if !ok {
+		return Account{}, fmt.Errorf("account %s not found", accountID)
+	}
+	if acc.Status == "closed" {
+		return Account{}, &AccountStatusError{AccountID: accountID, Status: acc.Status}
+	}
+	if acc.Balance.Add(limit).IsNegative() {
+		return Account{}, fmt.Errorf("account %s is already overdrawn by %s, limit cannot be lower", accountID, acc.Balance.Neg().String())
+	}
+	acc.OverdraftLimit = limit
+	acc.OverdraftRate = rate
 	storage.accounts[accountID] = acc
-	return nil
+	return acc, nil
 }
 
-func AddTransaction(tx Transaction) {
+// AccrueOverdraftInterest начисляет дневные проценты на отрицательный остаток
+// и раз в месяц списывает накопленную сумму отдельной транзакцией.
+func AccrueOverdraftInterest(now time.Time) {
 	storage.mu.Lock()
 	defer storage.mu.Unlock()
-	storage.transactions = append(storage.transactions, tx)
+
+	for id, acc := range storage.accounts {
+		if acc.Status == "closed" {
+			continue
+		}
+		if acc.Balance.IsNegative() && acc.OverdraftRate.IsPositive() {
+			daily := acc.Balance.Neg().Mul(acc.OverdraftRate).Div(decimal.NewFromInt(100)).Div(decimal.NewFromInt(365))
+			acc.OverdraftAccrued = acc.OverdraftAccrued.Add(daily)
+		}
+
+		if acc.OverdraftChargedAt.IsZero() {
+			acc.OverdraftChargedAt = now
+		}
+		sameMonth := acc.OverdraftChargedAt.Year() == now.Year() && acc.OverdraftChargedAt.Month() == now.Month()
+		charge := acc.OverdraftAccrued.RoundBank(2)
+		if !sameMonth && charge.IsPositive() {
+			// Проценты списываются независимо от лимита, иначе долг по ним нельзя было бы взыскать.
+			acc.Balance = acc.Balance.Sub(charge)
+			acc.OverdraftAccrued = decimal.Zero
+			acc.OverdraftChargedAt = now
+			appendTransactionLocked(Transaction{
+				ID:              GenerateID(),
+				FromAccountID:   id,
+				Amount:          charge,
+				Timestamp:       now,
+				TransactionType: "overdraft_interest",
+				Description:     fmt.Sprintf("Overdraft interest for %s", now.AddDate(0, -1, 0).Format("01.2006")),
+			})
+			log.Printf("Charged overdraft interest %s to account %s", charge.String(), id)
+		} else if !sameMonth {
+			acc.OverdraftChargedAt = now
+		}
+		storage.accounts[id] = acc
+	}
 }
 
-func GetAccountTransactions(accountID string) []Transaction {
+func accountTransactionsLocked(accountID string) []Transaction {
+	positions := storage.accountTxIndex[accountID]
+	accountTxs := make([]Transaction, 0, len(positions))
+	for _, pos := range positions {
+		accountTxs = append(accountTxs, storage.transactions[pos])
+	}
+	return accountTxs
+}
+
+// GetAccountLedger возвращает счет и все его транзакции в одном согласованном снимке: остаток счета
+// соответствует журналу, что нужно для расчета остатков в выписке.
+func GetAccountLedger(accountID string) (Account, []Transaction, bool) {
 	storage.mu.RLock()
 	defer storage.mu.RUnlock()
-	var accountTxs []Transaction
-	for _, tx := range storage.transactions {
-		if tx.FromAccountID == accountID || tx.ToAccountID == accountID {
-			accountTxs = append(accountTxs, tx)
+	account, ok := storage.accounts[accountID]
+	if !ok {
+		return Account{}, nil, false
+	}
+	return account, accountTransactionsLocked(accountID), true
+}
+
+// QueryAccountTransactions возвращает страницу истории счета от новых к старым. Курсор — позиция
+// в журнале, с которой продолжается выборка.
+func QueryAccountTransactions(accountID string, filter TransactionFilter) TransactionPage {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+
+	positions := storage.accountTxIndex[accountID]
+	page := TransactionPage{Transactions: make([]Transaction, 0, filter.Limit)}
+	last := 0
+	i := len(positions) - 1
+	if filter.Cursor != nil {
+		// Позиции в индексе возрастают, поэтому начало страницы находится бинарным поиском.
+		i = sort.SearchInts(positions, *filter.Cursor) - 1
+	}
+	for ; i >= 0; i-- {
+		tx := storage.transactions[positions[i]]
+		if !filter.Matches(tx, accountID) {
+			continue
+		}
+		if filter.Limit > 0 && len(page.Transactions) == filter.Limit {
+			page.NextCursor = EncodeHistoryCursor(last)
+			page.HasMore = true
+			break
 		}
+		page.Transactions = append(page.Transactions, tx)
+		last = positions[i]
 	}
-	return accountTxs
+	return page
+}
+
+func GetAccountTransactions(accountID string) []Transaction {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	return accountTransactionsLocked(accountID)
 }
 
 func AddCard(card Card) error {
 	storage.mu.Lock()
 	defer storage.mu.Unlock()
-	if _, exists := storage.accounts[card.AccountID]; !exists {
+	acc, exists := storage.accounts[card.AccountID]
+	if !exists {
 		return fmt.Errorf("account %s not found", card.AccountID)
 	}
+	if err := checkAccountActiveLocked(acc); err != nil {
+		return err
+	}
+	if _, exists := storage.cardNumberIndex[card.NumberHash]; exists {
+		return errCardNumberTaken
+	}
 	storage.cards[card.ID] = card
 	storage.cardIndex[card.AccountID] = append(storage.cardIndex[card.AccountID], card.ID)
+	storage.cardNumberIndex[card.NumberHash] = card.ID
 	return nil
 }
 
@@ -157,47 +688,1470 @@ func GetAccountCards(accountID string) []Card {
 	return cards
 }
 
-func GetCardByNumber(number string) (Card, bool) {
+func GetCard(cardID string) (Card, bool) {
 	storage.mu.RLock()
 	defer storage.mu.RUnlock()
+	card, ok := storage.cards[cardID]
+	return card, ok
+}
+
+// GetCardByNumber находит карту по номеру. Карта с истекшим сроком сразу переводится в статус expired.
+func GetCardByNumber(number string) (Card, bool) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	cardID, ok := storage.cardNumberIndex[CardNumberHash(number)]
+	if !ok {
+		return Card{}, false
+	}
+	card, ok := storage.cards[cardID]
+	if ok {
+		card = expireCardLocked(card, time.Now())
+	}
+	return card, ok
+}
+
+func expireCardLocked(card Card, now time.Time) Card {
+	if (card.Status == "active" || card.Status == "blocked" || card.Status == "security_blocked") && card.IsExpired(now) {
+		card.Status = "expired"
+		card.StatusChangedAt = &now
+		storage.cards[card.ID] = card
+	}
+	return card
+}
+
+// ExpireCards переводит все карты с истекшим сроком в статус expired.
+func ExpireCards(now time.Time) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
 	for _, card := range storage.cards {
-		if card.Number == number {
-			return card, true
+		if updated := expireCardLocked(card, now); updated.Status != card.Status {
+			log.Printf("Card %s expired", card.ID)
 		}
 	}
-	return Card{}, false
 }
 
-func AddLoan(loan Loan) error {
+func ExpireMoneyRequests(now time.Time) {
 	storage.mu.Lock()
 	defer storage.mu.Unlock()
-	if _, exists := storage.users[loan.UserID]; !exists {
-		return fmt.Errorf("user %s not found", loan.UserID)
-	}
-	if _, exists := storage.accounts[loan.AccountID]; !exists {
-		return fmt.Errorf("account %s not found", loan.AccountID)
+	for id, request := range storage.moneyRequests {
+		if request.Status == "pending" && !now.Before(request.ExpiresAt) {
+			request.Status = "expired"
+			storage.moneyRequests[id] = request
+			log.Printf("Money request %s expired", id)
+		}
 	}
-	storage.loans[loan.ID] = loan
-	storage.loanIndex[loan.UserID] = append(storage.loanIndex[loan.UserID], loan.ID)
-	return nil
 }
 
-func GetUserLoans(userID string) []Loan {
-	storage.mu.RLock()
-	defer storage.mu.RUnlock()
-	loanIDs := storage.loanIndex[userID]
-	loans := make([]Loan, 0, len(loanIDs))
-	for _, id := range loanIDs {
-		if loan, ok := storage.loans[id]; ok {
-			loans = append(loans, loan)
+func MarkOverdueInvoices(now time.Time) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	for id, invoice := range storage.invoices {
+		if (invoice.Status == "issued" || invoice.Status == "partially_paid") && now.After(invoice.DueDate) {
+			invoice.Status = "overdue"
+			storage.invoices[id] = invoice
+			log.Printf("Invoice %s is overdue, outstanding %s", id, invoice.Outstanding.String())
 		}
 	}
-	return loans
 }
 
-func GetLoan(loanID string) (Loan, bool) {
-	storage.mu.RLock()
-	defer storage.mu.RUnlock()
-	loan, ok := storage.loans[loanID]
-	return loan, ok
+// UpdateCard изменяет карту под блокировкой хранилища. update не должен обращаться к storage.
+func UpdateCard(cardID string, update func(card *Card) error) (Card, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	card, ok := storage.cards[cardID]
+	if !ok {
+		return Card{}, fmt.Errorf("card %s not found", cardID)
+	}
+	card = expireCardLocked(card, time.Now())
+	if err := update(&card); err != nil {
+		return Card{}, err
+	}
+	storage.cards[cardID] = card
+	return card, nil
+}
+
+// ReplaceCard выпускает новую карту взамен старой и связывает их между собой.
+// Карту с блокировкой безопасности заменяет только сотрудник банка (byOperator).
+func ReplaceCard(oldCardID string, newCard Card, byOperator bool, now time.Time) (Card, Card, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	old, ok := storage.cards[oldCardID]
+	if !ok {
+		return Card{}, Card{}, fmt.Errorf("card %s not found", oldCardID)
+	}
+	// Навсегда заблокированная карта после перевыпуска сохраняет статус, поэтому смотрим и на ссылку на новую карту.
+	if old.Status == "replaced" || old.ReplacedByCardID != "" {
+		return Card{}, Card{}, &CardStatusError{CardID: old.ID, Status: "replaced"}
+	}
+	if old.Status == "security_blocked" && !byOperator {
+		return Card{}, Card{}, &CardStatusError{CardID: old.ID, Status: old.Status}
+	}
+	acc, ok := storage.accounts[old.AccountID]
+	if !ok {
+		return Card{}, Card{}, fmt.Errorf("account %s not found", old.AccountID)
+	}
+	if err := checkAccountActiveLocked(acc); err != nil {
+		return Card{}, Card{}, err
+	}
+	if _, exists := storage.cardNumberIndex[newCard.NumberHash]; exists {
+		return Card{}, Card{}, errCardNumberTaken
+	}
+
+	newCard.AccountID = old.AccountID
+	newCard.ReplacesCardID = old.ID
+	storage.cards[newCard.ID] = newCard
+	storage.cardIndex[newCard.AccountID] = append(storage.cardIndex[newCard.AccountID], newCard.ID)
+	storage.cardNumberIndex[newCard.NumberHash] = newCard.ID
+
+	// Токены мерчантов переходят на новую карту, чтобы подписки продолжали работать после перевыпуска.
+	for _, token := range storage.cardTokenIndex[old.ID] {
+		t := storage.cardTokens[token]
+		t.CardID = newCard.ID
+		storage.cardTokens[token] = t
+	}
+	storage.cardTokenIndex[newCard.ID] = storage.cardTokenIndex[old.ID]
+	delete(storage.cardTokenIndex, old.ID)
+
+	// Навсегда заблокированная карта сохраняет свой статус, чтобы была видна причина перевыпуска.
+	if old.Status != "permanently_blocked" {
+		old.Status = "replaced"
+		old.StatusChangedAt = &now
+	}
+	old.ReplacedByCardID = newCard.ID
+	storage.cards[old.ID] = old
+	return old, newCard, nil
+}
+
+// RewrapCardNumbers перешифровывает номера карт, зашифрованные не ключом version.
+// Изменения применяются только если перешифрованы все карты.
+func RewrapCardNumbers(version int, rewrap func(Card) (Card, error)) (int, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	updated := make(map[string]Card)
+	for id, card := range storage.cards {
+		if card.KeyVersion == version {
+			continue
+		}
+		rewrapped, err := rewrap(card)
+		if err != nil {
+			return 0, fmt.Errorf("card %s: %w", id, err)
+		}
+		updated[id] = rewrapped
+	}
+	for id, card := range updated {
+		storage.cards[id] = card
+	}
+	return len(updated), nil
+}
+
+// PostCardPayment проводит оплату из внешней сети и запоминает ссылку на нее. Повтор с тем же ключом отклоняется.
+func PostCardPayment(ref CardPaymentRef, txs ...Transaction) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if _, exists := storage.cardPaymentRefs[ref.Key]; exists {
+		return errDuplicateCardPayment
+	}
+	if err := applyTransactionsAtomicLocked(txs); err != nil {
+		return err
+	}
+	storage.cardPaymentRefs[ref.Key] = ref
+	accrueCashbackLocked(txs[0])
+	return nil
+}
+
+// refundedAmountLocked суммирует возвраты и отмены по оплате: они зачисляются на счет, с которого она была списана.
+func refundedAmountLocked(paymentID, accountID string) decimal.Decimal {
+	refunded := decimal.Zero
+	for _, tx := range accountTransactionsLocked(accountID) {
+		if tx.RelatedTxID == paymentID && (tx.TransactionType == "refund" || tx.TransactionType == "payment_reversal") {
+			refunded = refunded.Add(tx.Amount)
+		}
+	}
+	return refunded
+}
+
+// ReverseCardPayment возвращает средства по ранее проведенной оплате за вычетом уже сделанных возвратов.
+// Повторная отмена ничего не меняет, отмена полностью возвращенной оплаты отклоняется.
+func ReverseCardPayment(key string, now time.Time) (CardPaymentRef, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	ref, ok := storage.cardPaymentRefs[key]
+	if !ok {
+		return CardPaymentRef{}, fmt.Errorf("original card payment %s not found", key)
+	}
+	if ref.Reversed {
+		return ref, nil
+	}
+	remaining := ref.Amount.Sub(refundedAmountLocked(ref.TransactionID, ref.AccountID))
+	if !remaining.IsPositive() {
+		return CardPaymentRef{}, &RefundExceedsPaymentError{PaymentID: ref.TransactionID, Refundable: decimal.Zero}
+	}
+	reversal := Transaction{
+		ID:              GenerateID(),
+		ToAccountID:     ref.AccountID,
+		Amount:          remaining,
+		Timestamp:       now,
+		TransactionType: "payment_reversal",
+		Description:     fmt.Sprintf("Reversal of card payment (ID: %s)", ref.TransactionID),
+		CardID:          ref.CardID,
+		MerchantID:      ref.MerchantID,
+		RelatedTxID:     ref.TransactionID,
+	}
+	if err := applyTransactionLocked(reversal); err != nil {
+		return CardPaymentRef{}, err
+	}
+	adjustCashbackLocked(ref.TransactionID, remaining)
+	ref.Reversed = true
+	storage.cardPaymentRefs[key] = ref
+	return ref, nil
+}
+
+func AddMerchant(merchant Merchant) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	acc, ok := storage.accounts[merchant.SettlementAccountID]
+	if !ok {
+		return fmt.Errorf("settlement account %s not found", merchant.SettlementAccountID)
+	}
+	if err := checkAccountActiveLocked(acc); err != nil {
+		return err
+	}
+	if _, exists := storage.acceptorIndex[merchant.AcceptorID]; exists {
+		return errAcceptorIDTaken
+	}
+	storage.merchants[merchant.ID] = merchant
+	storage.acceptorIndex[merchant.AcceptorID] = merchant.ID
+	return nil
+}
+
+func GetMerchant(merchantID string) (Merchant, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	merchant, ok := storage.merchants[merchantID]
+	return merchant, ok
+}
+
+func GetMerchantByAcceptorID(acceptorID string) (Merchant, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	merchant, ok := storage.merchants[storage.acceptorIndex[acceptorID]]
+	return merchant, ok
+}
+
+func GetMerchants() []Merchant {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	merchants := make([]Merchant, 0, len(storage.merchants))
+	for _, merchant := range storage.merchants {
+		merchants = append(merchants, merchant)
+	}
+	return merchants
+}
+
+func UpdateMerchant(merchantID string, update func(*Merchant) error) (Merchant, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	merchant, ok := storage.merchants[merchantID]
+	if !ok {
+		return Merchant{}, fmt.Errorf("merchant %s not found", merchantID)
+	}
+	if err := update(&merchant); err != nil {
+		return Merchant{}, err
+	}
+	storage.merchants[merchantID] = merchant
+	return merchant, nil
+}
+
+// merchantUnsettledLocked возвращает операции мерчанта до cutoff, еще не вошедшие в расчет.
+func merchantUnsettledLocked(merchantID string, cutoff time.Time) []Transaction {
+	var txs []Transaction
+	for _, tx := range storage.transactions {
+		if tx.MerchantID != merchantID || tx.Timestamp.After(cutoff) {
+			continue
+		}
+		if _, settled := storage.settledTransactions[tx.ID]; settled {
+			continue
+		}
+		if _, ok := merchantPositionTypes[tx.TransactionType]; ok {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+func GetMerchantPosition(merchantID string, now time.Time) (MerchantPosition, error) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	merchant, ok := storage.merchants[merchantID]
+	if !ok {
+		return MerchantPosition{}, fmt.Errorf("merchant %s not found", merchantID)
+	}
+	return CalculateMerchantPosition(merchant, merchantUnsettledLocked(merchantID, now)), nil
+}
+
+// SettleMerchant выплачивает мерчанту нетто-позицию по операциям до now. Если позиция не положительна,
+// расчет не выполняется и операции переходят в следующий.
+func SettleMerchant(merchantID string, now time.Time) (MerchantSettlement, bool, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	merchant, ok := storage.merchants[merchantID]
+	if !ok {
+		return MerchantSettlement{}, false, fmt.Errorf("merchant %s not found", merchantID)
+	}
+	txs := merchantUnsettledLocked(merchantID, now)
+	position := CalculateMerchantPosition(merchant, txs)
+	if len(txs) == 0 || !position.NetAmount.IsPositive() {
+		return MerchantSettlement{}, false, nil
+	}
+
+	settlement := MerchantSettlement{
+		ID:            GenerateID(),
+		MerchantID:    merchant.ID,
+		PeriodStart:   txs[0].Timestamp,
+		PeriodEnd:     now,
+		PaymentsCount: position.PaymentsCount,
+		RefundsCount:  position.RefundsCount,
+		GrossAmount:   position.GrossAmount,
+		RefundsAmount: position.RefundsAmount,
+		FeesAmount:    position.FeesAmount,
+		NetAmount:     position.NetAmount,
+		CreatedAt:     now,
+	}
+	payout := Transaction{
+		ID:              GenerateID(),
+		ToAccountID:     merchant.SettlementAccountID,
+		Amount:          position.NetAmount,
+		Timestamp:       now,
+		TransactionType: "merchant_settlement",
+		Description:     fmt.Sprintf("Acquiring settlement %s (%d payments, fees %s)", settlement.ID, position.PaymentsCount, position.FeesAmount.StringFixed(2)),
+		MerchantID:      merchant.ID,
+	}
+	if err := applyTransactionLocked(payout); err != nil {
+		return MerchantSettlement{}, false, err
+	}
+
+	settlement.TransactionID = payout.ID
+	for _, tx := range txs {
+		settlement.TransactionIDs = append(settlement.TransactionIDs, tx.ID)
+		storage.settledTransactions[tx.ID] = settlement.ID
+	}
+	storage.settlements[settlement.ID] = settlement
+	storage.settlementIndex[merchant.ID] = append(storage.settlementIndex[merchant.ID], settlement.ID)
+	return settlement, true, nil
+}
+
+func GetMerchantSettlements(merchantID string) []MerchantSettlement {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	ids := storage.settlementIndex[merchantID]
+	settlements := make([]MerchantSettlement, 0, len(ids))
+	for _, id := range ids {
+		settlements = append(settlements, storage.settlements[id])
+	}
+	return settlements
+}
+
+func GetMerchantSettlement(settlementID string) (MerchantSettlement, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	settlement, ok := storage.settlements[settlementID]
+	return settlement, ok
+}
+
+// RefundCardPayment возвращает покупателю часть или всю сумму оплаты. Сумма возвратов не может превышать оплату.
+// Возврат удерживается из еще не выплаченной позиции мерчанта, а если ее не хватает — списывается с расчетного счета.
+func RefundCardPayment(merchantID, paymentID string, amount decimal.Decimal, now time.Time) (Transaction, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	var payment *Transaction
+	for i, tx := range storage.transactions {
+		if tx.ID == paymentID {
+			payment = &storage.transactions[i]
+			break
+		}
+	}
+	if payment == nil || !merchantPaymentTypes[payment.TransactionType] || payment.MerchantID != merchantID {
+		return Transaction{}, fmt.Errorf("payment %s not found for merchant %s", paymentID, merchantID)
+	}
+	refunded := refundedAmountLocked(paymentID, payment.FromAccountID)
+	if refunded.Add(amount).GreaterThan(payment.Amount) {
+		return Transaction{}, &RefundExceedsPaymentError{PaymentID: paymentID, Refundable: payment.Amount.Sub(refunded)}
+	}
+
+	merchant, ok := storage.merchants[merchantID]
+	if !ok {
+		return Transaction{}, errMerchantNotFound
+	}
+	fundingAccountID := ""
+	if amount.GreaterThan(CalculateMerchantPosition(merchant, merchantUnsettledLocked(merchantID, now)).NetAmount) {
+		fundingAccountID = merchant.SettlementAccountID
+	}
+
+	refund := Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   fundingAccountID,
+		ToAccountID:     payment.FromAccountID,
+		Amount:          amount,
+		Timestamp:       now,
+		TransactionType: "refund",
+		Description:     fmt.Sprintf("Refund of payment (ID: %s)", paymentID),
+		CardID:          payment.CardID,
+		MerchantID:      merchantID,
+		RelatedTxID:     paymentID,
+	}
+	if err := applyTransactionLocked(refund); err != nil {
+		return Transaction{}, err
+	}
+	adjustCashbackLocked(paymentID, amount)
+	return refund, nil
+}
+
+func AddQRPayment(qr QRPayment) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if _, exists := storage.qrPayments[qr.ID]; exists {
+		return fmt.Errorf("QR payment %s already exists", qr.ID)
+	}
+	storage.qrPayments[qr.ID] = qr
+	storage.merchantQRIndex[qr.MerchantID] = append(storage.merchantQRIndex[qr.MerchantID], qr.ID)
+	return nil
+}
+
+func GetQRPayment(qrID string) (QRPayment, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	qr, ok := storage.qrPayments[qrID]
+	return qr, ok
+}
+
+func GetMerchantQRPayments(merchantID string) []QRPayment {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	payments := make([]QRPayment, 0, len(storage.merchantQRIndex[merchantID]))
+	for _, id := range storage.merchantQRIndex[merchantID] {
+		payments = append(payments, storage.qrPayments[id])
+	}
+	return payments
+}
+
+func UpdateQRPayment(qrID string, update func(*QRPayment) error) (QRPayment, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	qr, ok := storage.qrPayments[qrID]
+	if !ok {
+		return QRPayment{}, fmt.Errorf("QR payment %s not found", qrID)
+	}
+	if err := update(&qr); err != nil {
+		return QRPayment{}, err
+	}
+	storage.qrPayments[qrID] = qr
+	return qr, nil
+}
+
+// PayQRPayment проводит оплату по QR под той же блокировкой, что и смену статуса требования,
+// чтобы динамический QR нельзя было оплатить дважды.
+func PayQRPayment(qrID string, tx Transaction, now time.Time) (QRPayment, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	qr, ok := storage.qrPayments[qrID]
+	if !ok {
+		return QRPayment{}, fmt.Errorf("QR payment %s not found", qrID)
+	}
+	if err := CheckQRPayable(qr, tx.Amount, now); err != nil {
+		return QRPayment{}, err
+	}
+	if err := applyTransactionLocked(tx); err != nil {
+		return QRPayment{}, err
+	}
+	qr.TransactionIDs = append(qr.TransactionIDs, tx.ID)
+	qr.PaidAt = &now
+	if qr.Type == "dynamic" {
+		qr.Status = "paid"
+	}
+	storage.qrPayments[qrID] = qr
+	return qr, nil
+}
+
+func AddMoneyRequest(request MoneyRequest) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.moneyRequests[request.ID] = request
+	storage.moneyRequestIndex[request.RequesterID] = append(storage.moneyRequestIndex[request.RequesterID], request.ID)
+	storage.moneyRequestIndex[request.PayerID] = append(storage.moneyRequestIndex[request.PayerID], request.ID)
+}
+
+func GetMoneyRequest(requestID string) (MoneyRequest, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	request, ok := storage.moneyRequests[requestID]
+	return request, ok
+}
+
+func GetUserMoneyRequests(userID string) []MoneyRequest {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	requests := make([]MoneyRequest, 0, len(storage.moneyRequestIndex[userID]))
+	for _, id := range storage.moneyRequestIndex[userID] {
+		requests = append(requests, storage.moneyRequests[id])
+	}
+	return requests
+}
+
+func UpdateMoneyRequest(requestID string, update func(*MoneyRequest) error) (MoneyRequest, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	request, ok := storage.moneyRequests[requestID]
+	if !ok {
+		return MoneyRequest{}, fmt.Errorf("money request %s %w", requestID, errNotFound)
+	}
+	if err := update(&request); err != nil {
+		return MoneyRequest{}, err
+	}
+	storage.moneyRequests[requestID] = request
+	return request, nil
+}
+
+// AcceptMoneyRequest исполняет перевод по запросу (txs — перевод и комиссия за него) и закрывает запрос
+// под одной блокировкой, чтобы запрос нельзя было оплатить дважды.
+func AcceptMoneyRequest(requestID string, txs []Transaction, now time.Time) (MoneyRequest, error) {
+	tx := txs[0]
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	request, ok := storage.moneyRequests[requestID]
+	if !ok {
+		return MoneyRequest{}, fmt.Errorf("money request %s %w", requestID, errNotFound)
+	}
+	if request.Status != "pending" || !now.Before(request.ExpiresAt) {
+		return MoneyRequest{}, errMoneyRequestClosed
+	}
+	if from, ok := storage.accounts[tx.FromAccountID]; !ok || from.UserID != request.PayerID {
+		return MoneyRequest{}, errAccountNotOwned
+	}
+	if err := applyTransactionsAtomicLocked(txs); err != nil {
+		return MoneyRequest{}, err
+	}
+	request.Status = "accepted"
+	request.TransactionID = tx.ID
+	request.RespondedAt = &now
+	storage.moneyRequests[requestID] = request
+	return request, nil
+}
+
+func AddInvoice(invoice Invoice) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.invoices[invoice.ID] = invoice
+	storage.invoiceIndex[invoice.IssuerID] = append(storage.invoiceIndex[invoice.IssuerID], invoice.ID)
+	storage.invoiceIndex[invoice.PayerID] = append(storage.invoiceIndex[invoice.PayerID], invoice.ID)
+}
+
+func GetInvoice(invoiceID string) (Invoice, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	invoice, ok := storage.invoices[invoiceID]
+	return invoice, ok
+}
+
+func GetUserInvoices(userID string) []Invoice {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	invoices := make([]Invoice, 0, len(storage.invoiceIndex[userID]))
+	for _, id := range storage.invoiceIndex[userID] {
+		invoices = append(invoices, storage.invoices[id])
+	}
+	return invoices
+}
+
+func UpdateInvoice(invoiceID string, update func(*Invoice) error) (Invoice, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	invoice, ok := storage.invoices[invoiceID]
+	if !ok {
+		return Invoice{}, fmt.Errorf("invoice %s %w", invoiceID, errNotFound)
+	}
+	if err := update(&invoice); err != nil {
+		return Invoice{}, err
+	}
+	storage.invoices[invoiceID] = invoice
+	return invoice, nil
+}
+
+// PayInvoice проводит оплату счета (полную или частичную) вместе с комиссией и обновляет остаток под одной блокировкой.
+func PayInvoice(invoiceID string, txs []Transaction, now time.Time) (Invoice, error) {
+	tx := txs[0]
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	invoice, ok := storage.invoices[invoiceID]
+	if !ok {
+		return Invoice{}, fmt.Errorf("invoice %s %w", invoiceID, errNotFound)
+	}
+	if err := CheckInvoicePayable(invoice, tx.Amount); err != nil {
+		return Invoice{}, err
+	}
+	if from, ok := storage.accounts[tx.FromAccountID]; !ok || from.UserID != invoice.PayerID {
+		return Invoice{}, errAccountNotOwned
+	}
+	if err := applyTransactionsAtomicLocked(txs); err != nil {
+		return Invoice{}, err
+	}
+	invoice.Payments = append(invoice.Payments, InvoicePayment{
+		TransactionID: tx.ID,
+		FromAccountID: tx.FromAccountID,
+		Amount:        tx.Amount,
+		PaidAt:        now,
+	})
+	ApplyInvoicePayment(&invoice, tx.Amount, now)
+	storage.invoices[invoiceID] = invoice
+	return invoice, nil
+}
+
+func AddBillPayment(payment BillPayment, tx Transaction) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if err := applyTransactionLocked(tx); err != nil {
+		return err
+	}
+	storage.billPayments[payment.ID] = payment
+	storage.billPaymentIndex[payment.UserID] = append(storage.billPaymentIndex[payment.UserID], payment.ID)
+	return nil
+}
+
+// SettleBillPayment переводит ожидающий платеж в конечный статус и при неудаче возвращает деньги на счет.
+func SettleBillPayment(paymentID, status, reason string, refund *Transaction, now time.Time) (BillPayment, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	payment, ok := storage.billPayments[paymentID]
+	if !ok {
+		return BillPayment{}, fmt.Errorf("bill payment %s not found", paymentID)
+	}
+	retry := payment.Status == "refund_pending" && status == "failed"
+	if payment.Status != "pending" && !retry {
+		if payment.Status == status {
+			return payment, nil
+		}
+		return BillPayment{}, fmt.Errorf("bill payment %s is already %s", paymentID, payment.Status)
+	}
+	if refund != nil {
+		if err := applyTransactionLocked(*refund); err != nil {
+			// Деньги клиента уже списаны: если счет заморожен или закрыт, возврат повторяет RetryBillPaymentRefunds.
+			log.Printf("Refund for bill payment %s is postponed: %v", paymentID, err)
+			payment.Status = "refund_pending"
+			payment.Reason = reason
+			payment.UpdatedAt = now
+			storage.billPayments[paymentID] = payment
+			return payment, nil
+		}
+		payment.RefundTransactionID = refund.ID
+	}
+	payment.Status = status
+	payment.Reason = reason
+	payment.UpdatedAt = now
+	storage.billPayments[paymentID] = payment
+	return payment, nil
+}
+
+func SetBillPaymentExternalID(paymentID, externalID string) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if payment, ok := storage.billPayments[paymentID]; ok {
+		payment.ExternalID = externalID
+		storage.billPayments[paymentID] = payment
+	}
+}
+
+func GetBillPayment(paymentID string) (BillPayment, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	payment, ok := storage.billPayments[paymentID]
+	return payment, ok
+}
+
+// GetBillPaymentsByStatus возвращает платежи всех клиентов в указанном статусе.
+func GetBillPaymentsByStatus(status string) []BillPayment {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	var payments []BillPayment
+	for _, payment := range storage.billPayments {
+		if payment.Status == status {
+			payments = append(payments, payment)
+		}
+	}
+	return payments
+}
+
+func GetUserBillPayments(userID string) []BillPayment {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	payments := make([]BillPayment, 0, len(storage.billPaymentIndex[userID]))
+	for _, id := range storage.billPaymentIndex[userID] {
+		payments = append(payments, storage.billPayments[id])
+	}
+	return payments
+}
+
+func AddBillTemplate(template BillTemplate) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.billTemplates[template.ID] = template
+	storage.billTemplateIndex[template.UserID] = append(storage.billTemplateIndex[template.UserID], template.ID)
+}
+
+func GetBillTemplate(templateID string) (BillTemplate, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	template, ok := storage.billTemplates[templateID]
+	return template, ok
+}
+
+func GetUserBillTemplates(userID string) []BillTemplate {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	templates := make([]BillTemplate, 0, len(storage.billTemplateIndex[userID]))
+	for _, id := range storage.billTemplateIndex[userID] {
+		templates = append(templates, storage.billTemplates[id])
+	}
+	return templates
+}
+
+func DeleteBillTemplate(templateID string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	template, ok := storage.billTemplates[templateID]
+	if !ok {
+		return fmt.Errorf("bill template %s not found", templateID)
+	}
+	delete(storage.billTemplates, templateID)
+	ids := storage.billTemplateIndex[template.UserID]
+	for i, id := range ids {
+		if id == templateID {
+			storage.billTemplateIndex[template.UserID] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func AddATM(atm ATM) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if _, exists := storage.atms[atm.ID]; exists {
+		return errATMIDTaken
+	}
+	storage.atms[atm.ID] = atm
+	return nil
+}
+
+func GetATM(atmID string) (ATM, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	atm, ok := storage.atms[atmID]
+	return atm, ok
+}
+
+func AddATMWithdrawal(withdrawal ATMWithdrawal, txs ...Transaction) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if err := applyTransactionsAtomicLocked(txs); err != nil {
+		return err
+	}
+	storage.atmWithdrawals[withdrawal.ID] = withdrawal
+	return nil
+}
+
+func GetATMWithdrawal(withdrawalID string) (ATMWithdrawal, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	withdrawal, ok := storage.atmWithdrawals[withdrawalID]
+	return withdrawal, ok
+}
+
+// ReverseATMWithdrawal возвращает на счет сумму, которую банкомат не выдал. Сообщение принимается только
+// от банкомата, выдававшего наличные, в пределах atmConfig.ReportWindow. Повторное сообщение о сбое
+// для уже отмененной выдачи ничего не меняет.
+func ReverseATMWithdrawal(withdrawalID, atmID string, dispensed decimal.Decimal, now time.Time) (ATMWithdrawal, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	withdrawal, ok := storage.atmWithdrawals[withdrawalID]
+	if !ok {
+		return ATMWithdrawal{}, fmt.Errorf("ATM withdrawal %s not found", withdrawalID)
+	}
+	if withdrawal.ATMID != atmID {
+		return ATMWithdrawal{}, errATMMismatch
+	}
+	if withdrawal.Status != "completed" {
+		return withdrawal, nil
+	}
+	if now.Sub(withdrawal.CreatedAt) > atmConfig.ReportWindow {
+		return ATMWithdrawal{}, errATMReportTooLate
+	}
+	if dispensed.IsNegative() || !dispensed.LessThan(withdrawal.Amount) {
+		return ATMWithdrawal{}, fmt.Errorf("%w: dispensed amount must be less than the withdrawal amount", errInvalidCashAmount)
+	}
+	txs := atmReversalTransactions(withdrawal, dispensed, now)
+	if err := applyTransactionsAtomicLocked(txs); err != nil {
+		return ATMWithdrawal{}, err
+	}
+	for _, tx := range txs {
+		withdrawal.ReversalTransactionIDs = append(withdrawal.ReversalTransactionIDs, tx.ID)
+	}
+	withdrawal.DispensedAmount = dispensed
+	withdrawal.Status = "reversed"
+	if dispensed.IsPositive() {
+		withdrawal.Status = "partially_reversed"
+	}
+	withdrawal.ReversedAt = &now
+	storage.atmWithdrawals[withdrawalID] = withdrawal
+	return withdrawal, nil
+}
+
+func AddFeeRule(rule FeeRule) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.feeRules[rule.ID] = rule
+}
+
+func GetFeeRules() []FeeRule {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	rules := make([]FeeRule, 0, len(storage.feeRules))
+	for _, rule := range storage.feeRules {
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func DeleteFeeRule(ruleID string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if _, ok := storage.feeRules[ruleID]; !ok {
+		return fmt.Errorf("fee rule %s not found", ruleID)
+	}
+	delete(storage.feeRules, ruleID)
+	return nil
+}
+
+func AddCashbackRule(rule CashbackRule) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.cashbackRules[rule.ID] = rule
+}
+
+func GetCashbackRules() []CashbackRule {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	rules := make([]CashbackRule, 0, len(storage.cashbackRules))
+	for _, rule := range storage.cashbackRules {
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func DeleteCashbackRule(ruleID string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if _, ok := storage.cashbackRules[ruleID]; !ok {
+		return fmt.Errorf("cashback rule %s not found", ruleID)
+	}
+	delete(storage.cashbackRules, ruleID)
+	return nil
+}
+
+// PostCardPurchase проводит оплату картой вместе с комиссией и начисляет за нее кешбэк под одной блокировкой:
+// возврат или отмена, пришедшие сразу после оплаты, уже находят начисленный кешбэк и уменьшают его.
+func PostCardPurchase(txs []Transaction) (CashbackReward, bool, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if err := applyTransactionsAtomicLocked(txs); err != nil {
+		return CashbackReward{}, false, err
+	}
+	reward, ok := accrueCashbackLocked(txs[0])
+	return reward, ok, nil
+}
+
+func GetUserCashbackRewards(userID string) []CashbackReward {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	rewards := make([]CashbackReward, 0, len(storage.cashbackIndex[userID]))
+	for _, id := range storage.cashbackIndex[userID] {
+		rewards = append(rewards, storage.cashbackRewards[id])
+	}
+	return rewards
+}
+
+func GetRewardsBalance(userID string, now time.Time) RewardsBalance {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	balance := RewardsBalance{
+		UserID:        userID,
+		Pending:       decimal.Zero,
+		Paid:          decimal.Zero,
+		CurrentPeriod: CashbackPeriod(now),
+		MonthlyCap:    cashbackConfig.MonthlyCap,
+	}
+	for _, id := range storage.cashbackIndex[userID] {
+		reward := storage.cashbackRewards[id]
+		switch reward.Status {
+		case "pending":
+			balance.Pending = balance.Pending.Add(reward.Amount)
+		case "paid":
+			balance.Paid = balance.Paid.Add(reward.Amount)
+		}
+	}
+	balance.AccruedInPeriod = cashbackAccruedLocked(userID, balance.CurrentPeriod, "")
+	return balance
+}
+
+// GetPendingCashbackUsers возвращает клиентов с невыплаченным кешбэком за месяцы по period включительно.
+func GetPendingCashbackUsers(period string) []string {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	var userIDs []string
+	for userID, ids := range storage.cashbackIndex {
+		for _, id := range ids {
+			if reward := storage.cashbackRewards[id]; reward.Status == "pending" && reward.Period <= period {
+				userIDs = append(userIDs, userID)
+				break
+			}
+		}
+	}
+	return userIDs
+}
+
+// PayUserCashback зачисляет ожидающий кешбэк клиента за месяцы по period включительно одной транзакцией
+// на каждый счет. Если счет карты уже не активен, кешбэк зачисляется на основной счет клиента.
+func PayUserCashback(userID, period string, now time.Time) ([]Transaction, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	byAccount := make(map[string][]string)
+	var accountIDs []string
+	for _, id := range storage.cashbackIndex[userID] {
+		reward := storage.cashbackRewards[id]
+		if reward.Status != "pending" || reward.Period > period {
+			continue
+		}
+		accountID := reward.AccountID
+		if acc, ok := storage.accounts[accountID]; !ok || acc.Status != "active" {
+			accountID = storage.users[userID].DefaultAccountID
+		}
+		if accountID == "" {
+			continue
+		}
+		if _, seen := byAccount[accountID]; !seen {
+			accountIDs = append(accountIDs, accountID)
+		}
+		byAccount[accountID] = append(byAccount[accountID], id)
+	}
+
+	var payouts []Transaction
+	for _, accountID := range accountIDs {
+		ids := byAccount[accountID]
+		total := decimal.Zero
+		for _, id := range ids {
+			total = total.Add(storage.cashbackRewards[id].Amount)
+		}
+		payout := Transaction{
+			ID:              GenerateID(),
+			ToAccountID:     accountID,
+			Amount:          total,
+			Timestamp:       now,
+			TransactionType: "cashback",
+			Description:     fmt.Sprintf("Cashback through %s (%d purchases)", period, len(ids)),
+		}
+		if err := applyTransactionLocked(payout); err != nil {
+			return payouts, fmt.Errorf("account %s: %w", accountID, err)
+		}
+		for _, id := range ids {
+			reward := storage.cashbackRewards[id]
+			reward.Status = "paid"
+			reward.PayoutTransactionID = payout.ID
+			reward.PaidAt = &now
+			storage.cashbackRewards[id] = reward
+		}
+		payouts = append(payouts, payout)
+	}
+	return payouts, nil
+}
+
+func AddCardToken(token CardToken) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if _, ok := storage.cards[token.CardID]; !ok {
+		return fmt.Errorf("card %s not found", token.CardID)
+	}
+	storage.cardTokens[token.Token] = token
+	storage.cardTokenIndex[token.CardID] = append(storage.cardTokenIndex[token.CardID], token.Token)
+	return nil
+}
+
+func GetCardTokens(cardID string) []CardToken {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	tokens := make([]CardToken, 0, len(storage.cardTokenIndex[cardID]))
+	for _, token := range storage.cardTokenIndex[cardID] {
+		tokens = append(tokens, storage.cardTokens[token])
+	}
+	return tokens
+}
+
+func RevokeCardToken(cardID, token string, now time.Time) (CardToken, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	t, ok := storage.cardTokens[token]
+	if !ok || t.CardID != cardID {
+		return CardToken{}, fmt.Errorf("card token not found")
+	}
+	if t.Status != "revoked" {
+		t.Status = "revoked"
+		t.RevokedAt = &now
+		storage.cardTokens[token] = t
+	}
+	return t, nil
+}
+
+// GetCardByToken находит карту по платежному токену. Как и GetCardByNumber, сразу переводит карту с истекшим сроком в expired.
+func GetCardByToken(token string) (CardToken, Card, bool) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	t, ok := storage.cardTokens[token]
+	if !ok {
+		return CardToken{}, Card{}, false
+	}
+	card, ok := storage.cards[t.CardID]
+	if !ok {
+		return CardToken{}, Card{}, false
+	}
+	return t, expireCardLocked(card, time.Now()), true
+}
+
+func AddCardRevealChallenge(challenge CardRevealChallenge) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.revealChallenges[challenge.ID] = challenge
+}
+
+// TakeCardRevealChallenge проверяет код подтверждения. Подтверждение одноразовое и удаляется
+// после успешной проверки, истечения срока или maxAttempts неверных кодов.
+func TakeCardRevealChallenge(challengeID, cardID string, now time.Time, maxAttempts int, check func(CardRevealChallenge) bool) (CardRevealChallenge, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	challenge, ok := storage.revealChallenges[challengeID]
+	if !ok || challenge.CardID != cardID {
+		return CardRevealChallenge{}, fmt.Errorf("reveal challenge %s not found", challengeID)
+	}
+	if now.After(challenge.ExpiresAt) {
+		delete(storage.revealChallenges, challengeID)
+		return CardRevealChallenge{}, fmt.Errorf("reveal challenge %s has expired", challengeID)
+	}
+	if !check(challenge) {
+		challenge.Attempts++
+		if challenge.Attempts >= maxAttempts {
+			delete(storage.revealChallenges, challengeID)
+		} else {
+			storage.revealChallenges[challengeID] = challenge
+		}
+		return CardRevealChallenge{}, errInvalidRevealCode
+	}
+	delete(storage.revealChallenges, challengeID)
+	return challenge, nil
+}
+
+// AddLoan сохраняет кредит вместе с зачислением выдачи на счет: без проведенной выдачи кредит не создается.
+func AddLoan(loan Loan, disbursement Transaction) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if _, exists := storage.users[loan.UserID]; !exists {
+		return fmt.Errorf("user %s not found", loan.UserID)
+	}
+	acc, exists := storage.accounts[loan.AccountID]
+	if !exists {
+		return fmt.Errorf("account %s not found", loan.AccountID)
+	}
+	if err := checkAccountActiveLocked(acc); err != nil {
+		return err
+	}
+	if err := applyTransactionLocked(disbursement); err != nil {
+		return err
+	}
+	storage.loans[loan.ID] = loan
+	storage.loanIndex[loan.UserID] = append(storage.loanIndex[loan.UserID], loan.ID)
+	return nil
+}
+
+func GetUserLoans(userID string) []Loan {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	loanIDs := storage.loanIndex[userID]
+	loans := make([]Loan, 0, len(loanIDs))
+	for _, id := range loanIDs {
+		if loan, ok := storage.loans[id]; ok {
+			loans = append(loans, loan)
+		}
+	}
+	return loans
+}
+
+func GetLoan(loanID string) (Loan, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	loan, ok := storage.loans[loanID]
+	return loan, ok
+}
+
+func OpenTermDeposit(deposit TermDeposit, tx Transaction) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	acc, ok := storage.accounts[deposit.AccountID]
+	if !ok {
+		return fmt.Errorf("account %s not found", deposit.AccountID)
+	}
+	if acc.UserID != deposit.UserID {
+		return fmt.Errorf("account %s does not belong to user %s", deposit.AccountID, deposit.UserID)
+	}
+	if err := applyTransactionLocked(tx); err != nil {
+		return err
+	}
+	storage.termDeposits[deposit.ID] = deposit
+	storage.termDepositIndex[deposit.UserID] = append(storage.termDepositIndex[deposit.UserID], deposit.ID)
+	return nil
+}
+
+// UpdateTermDeposit изменяет активный вклад под блокировкой и атомарно проводит выплаты, которые вернул update,
+// чтобы изменения клиента и ежедневная обработка вкладов не затирали друг друга.
+func UpdateTermDeposit(depositID string, update func(deposit *TermDeposit) ([]Transaction, error)) (TermDeposit, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	deposit, exists := storage.termDeposits[depositID]
+	if !exists {
+		return TermDeposit{}, fmt.Errorf("term deposit %s not found", depositID)
+	}
+	if deposit.Status != "active" {
+		return TermDeposit{}, fmt.Errorf("term deposit %s is already %s", depositID, deposit.Status)
+	}
+	txs, err := update(&deposit)
+	if err != nil {
+		return TermDeposit{}, err
+	}
+	if err := applyTransactionsAtomicLocked(txs); err != nil {
+		return TermDeposit{}, err
+	}
+	storage.termDeposits[depositID] = deposit
+	return deposit, nil
+}
+
+func GetTermDeposit(depositID string) (TermDeposit, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	deposit, ok := storage.termDeposits[depositID]
+	return deposit, ok
+}
+
+func GetUserTermDeposits(userID string) []TermDeposit {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	depositIDs := storage.termDepositIndex[userID]
+	deposits := make([]TermDeposit, 0, len(depositIDs))
+	for _, id := range depositIDs {
+		if deposit, ok := storage.termDeposits[id]; ok {
+			deposits = append(deposits, deposit)
+		}
+	}
+	return deposits
+}
+
+func GetActiveTermDeposits() []TermDeposit {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	deposits := make([]TermDeposit, 0)
+	for _, deposit := range storage.termDeposits {
+		if deposit.Status == "active" {
+			deposits = append(deposits, deposit)
+		}
+	}
+	return deposits
+}
+
+func AddTransferConfirmation(confirmation TransferConfirmation) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.confirmations[confirmation.ID] = confirmation
+}
+
+// TakeTransferConfirmation помечает подтверждение использованным, чтобы перевод нельзя было выполнить дважды.
+func TakeTransferConfirmation(confirmationID string, now time.Time) (TransferConfirmation, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	confirmation, ok := storage.confirmations[confirmationID]
+	if !ok {
+		return TransferConfirmation{}, fmt.Errorf("transfer confirmation %s not found", confirmationID)
+	}
+	if confirmation.Status != "pending" {
+		return TransferConfirmation{}, fmt.Errorf("transfer confirmation %s is already %s", confirmationID, confirmation.Status)
+	}
+	if now.After(confirmation.ExpiresAt) {
+		delete(storage.confirmations, confirmationID)
+		return TransferConfirmation{}, fmt.Errorf("transfer confirmation %s has expired", confirmationID)
+	}
+	confirmation.Status = "executed"
+	storage.confirmations[confirmationID] = confirmation
+	return confirmation, nil
+}
+
+func ReleaseTransferConfirmation(confirmationID string) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if confirmation, ok := storage.confirmations[confirmationID]; ok {
+		confirmation.Status = "pending"
+		storage.confirmations[confirmationID] = confirmation
+	}
+}
+
+func AddStandingOrder(order StandingOrder) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	acc, ok := storage.accounts[order.FromAccountID]
+	if !ok {
+		return fmt.Errorf("account %s not found", order.FromAccountID)
+	}
+	if acc.UserID != order.UserID {
+		return fmt.Errorf("account %s does not belong to user %s", order.FromAccountID, order.UserID)
+	}
+	storage.standingOrders[order.ID] = order
+	storage.standingOrderIndex[order.UserID] = append(storage.standingOrderIndex[order.UserID], order.ID)
+	return nil
+}
+
+func GetStandingOrder(orderID string) (StandingOrder, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	order, ok := storage.standingOrders[orderID]
+	return order, ok
+}
+
+func GetUserStandingOrders(userID string) []StandingOrder {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	orderIDs := storage.standingOrderIndex[userID]
+	orders := make([]StandingOrder, 0, len(orderIDs))
+	for _, id := range orderIDs {
+		if order, ok := storage.standingOrders[id]; ok {
+			orders = append(orders, order)
+		}
+	}
+	return orders
+}
+
+func GetDueStandingOrders(now time.Time) []StandingOrder {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	var orders []StandingOrder
+	for _, order := range storage.standingOrders {
+		if order.Status == "active" && !order.NextRunAt.After(now) {
+			orders = append(orders, order)
+		}
+	}
+	return orders
+}
+
+// UpdateStandingOrder изменяет поручение под блокировкой, чтобы действия клиента
+// (пауза, отмена) не затирались планировщиком и наоборот. update не должен обращаться к storage.
+func UpdateStandingOrder(orderID string, update func(order *StandingOrder) error) (StandingOrder, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	order, ok := storage.standingOrders[orderID]
+	if !ok {
+		return StandingOrder{}, fmt.Errorf("standing order %s not found", orderID)
+	}
+	if err := update(&order); err != nil {
+		return StandingOrder{}, err
+	}
+	storage.standingOrders[orderID] = order
+	return order, nil
+}
+
+// PostStandingOrderTransfer проводит перевод по поручению вместе с комиссией, проверяя под той же блокировкой,
+// что поручение все еще активно: приостановка или отмена перед самым проведением не дает списать деньги.
+func PostStandingOrderTransfer(orderID string, tx Transaction) (Transaction, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if order, ok := storage.standingOrders[orderID]; !ok || order.Status != "active" {
+		return Transaction{}, errStandingOrderNotActive
+	}
+	tx, _, err := postWithFeeLocked(tx, "transfer", "", nil)
+	return tx, err
+}
+
+func AddPaymentBatch(batch PaymentBatch) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.batches[batch.ID] = batch
+	storage.batchIndex[batch.UserID] = append(storage.batchIndex[batch.UserID], batch.ID)
+}
+
+func UpdatePaymentBatch(batch PaymentBatch) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.batches[batch.ID] = batch
+}
+
+func GetPaymentBatch(batchID string) (PaymentBatch, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	batch, ok := storage.batches[batchID]
+	return batch, ok
+}
+
+func GetUserPaymentBatches(userID string) []PaymentBatch {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	batchIDs := storage.batchIndex[userID]
+	batches := make([]PaymentBatch, 0, len(batchIDs))
+	for _, id := range batchIDs {
+		if batch, ok := storage.batches[id]; ok {
+			batches = append(batches, batch)
+		}
+	}
+	return batches
+}
+
+// AddSBPTransfer сохраняет перевод СБП и атомарно проводит связанные с ним транзакции.
+func AddSBPTransfer(transfer SBPTransfer, txs ...Transaction) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	if transfer.ExternalID != "" {
+		if _, exists := storage.sbpExternalIndex[transfer.ExternalID]; exists {
+			return fmt.Errorf("SBP transfer with external ID %s already processed", transfer.ExternalID)
+		}
+	}
+	if transfer.Direction == "outgoing" {
+		if err := checkSBPDailyLimitLocked(transfer); err != nil {
+			return err
+		}
+	}
+	if err := applyTransactionsAtomicLocked(txs); err != nil {
+		return err
+	}
+
+	storage.sbpTransfers[transfer.ID] = transfer
+	storage.sbpIndex[transfer.AccountID] = append(storage.sbpIndex[transfer.AccountID], transfer.ID)
+	if transfer.ExternalID != "" {
+		storage.sbpExternalIndex[transfer.ExternalID] = transfer.ID
+	}
+	return nil
+}
+
+// SettleSBPTransfer переводит ожидающий перевод в конечный статус и проводит возвраты при отказе.
+func SettleSBPTransfer(transferID, status, reason string, refunds []Transaction, now time.Time) (SBPTransfer, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	transfer, ok := storage.sbpTransfers[transferID]
+	if !ok {
+		return SBPTransfer{}, fmt.Errorf("SBP transfer %s not found", transferID)
+	}
+	if transfer.Status != "pending" {
+		if transfer.Status == status {
+			return transfer, nil
+		}
+		return SBPTransfer{}, fmt.Errorf("SBP transfer %s is already %s", transferID, transfer.Status)
+	}
+	if err := applyTransactionsAtomicLocked(refunds); err != nil {
+		return SBPTransfer{}, err
+	}
+	transfer.Status = status
+	transfer.Reason = reason
+	transfer.UpdatedAt = now
+	storage.sbpTransfers[transferID] = transfer
+	return transfer, nil
+}
+
+func SetSBPTransferExternalID(transferID, externalID string) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if transfer, ok := storage.sbpTransfers[transferID]; ok {
+		transfer.ExternalID = externalID
+		storage.sbpTransfers[transferID] = transfer
+		storage.sbpExternalIndex[externalID] = transferID
+	}
+}
+
+func GetSBPTransfer(transferID string) (SBPTransfer, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	transfer, ok := storage.sbpTransfers[transferID]
+	return transfer, ok
+}
+
+func GetSBPTransferByExternalID(externalID string) (SBPTransfer, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	transferID, ok := storage.sbpExternalIndex[externalID]
+	if !ok {
+		return SBPTransfer{}, false
+	}
+	transfer, ok := storage.sbpTransfers[transferID]
+	return transfer, ok
+}
+
+func GetAccountSBPTransfers(accountID string) []SBPTransfer {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	transferIDs := storage.sbpIndex[accountID]
+	transfers := make([]SBPTransfer, 0, len(transferIDs))
+	for _, id := range transferIDs {
+		if transfer, ok := storage.sbpTransfers[id]; ok {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers
+}
+
+// SetCustomLimits сохраняет пониженные клиентом лимиты. Повысить лимит сверх лимита банка нельзя.
+func SetCustomLimits(scope, ownerID, category string, limits OperationLimits) error {
+	defaults, ok := defaultLimits[scope][category]
+	if !ok {
+		return fmt.Errorf("limits for %s are not available on %s level", category, scope)
+	}
+	if limits.PerTransaction.GreaterThan(defaults.PerTransaction) ||
+		limits.Daily.GreaterThan(defaults.Daily) ||
+		limits.Monthly.GreaterThan(defaults.Monthly) {
+		return fmt.Errorf("limits cannot exceed bank defaults (%s per transaction, %s daily, %s monthly)",
+			defaults.PerTransaction.String(), defaults.Daily.String(), defaults.Monthly.String())
+	}
+	if limits.PerTransaction.IsNegative() || limits.Daily.IsNegative() || limits.Monthly.IsNegative() {
+		return fmt.Errorf("limits must not be negative")
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	key := limitKey(scope, ownerID)
+	if storage.customLimits[key] == nil {
+		storage.customLimits[key] = make(map[string]OperationLimits)
+	}
+	storage.customLimits[key][category] = limits
+	return nil
+}
+
+func ResetCustomLimits(scope, ownerID, category string) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	delete(storage.customLimits[limitKey(scope, ownerID)], category)
+}
+
+func GetEffectiveLimits(scope, ownerID, category string) (OperationLimits, bool) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	return effectiveLimitsLocked(scope, ownerID, category)
 }
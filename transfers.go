@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/shopspring/decimal"
+)
+
+const transferConfirmationTTL = 5 * time.Minute
+
+// ExecuteTransfer переводит средства между двумя внутренними счетами банка и списывает комиссию по тарифу.
+// quotedFee — комиссия, подтвержденная клиентом; если тариф изменился, перевод отклоняется.
+func ExecuteTransfer(fromAccountID, toAccountID string, amount decimal.Decimal, quotedFee *decimal.Decimal) (Transaction, error) {
+	tx, err := newInternalTransfer(fromAccountID, toAccountID, amount)
+	if err != nil {
+		return Transaction{}, err
+	}
+	tx, _, err = PostWithFee(tx, "transfer", "", quotedFee)
+	return tx, err
+}
+
+// newInternalTransfer проверяет параметры перевода между счетами банка и готовит его проводку.
+func newInternalTransfer(fromAccountID, toAccountID string, amount decimal.Decimal) (Transaction, error) {
+	if fromAccountID == toAccountID {
+		return Transaction{}, errSameAccount
+	}
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return Transaction{}, errNonPositiveAmount
+	}
+
+	fromAccount, ok := GetAccount(fromAccountID)
+	if !ok {
+		return Transaction{}, fmt.Errorf("source account %s not found", fromAccountID)
+	}
+	toAccount, ok := GetAccount(toAccountID)
+	if !ok {
+		return Transaction{}, fmt.Errorf("destination account %s not found", toAccountID)
+	}
+	return NewTransferTransaction(fromAccount, toAccount, amount, ""), nil
+}
+
+func NewTransferTransaction(from, to Account, amount decimal.Decimal, description string) Transaction {
+	if description == "" {
+		description = fmt.Sprintf("Transfer from %s to %s", from.Number, to.Number)
+	}
+	return Transaction{
+		ID:              GenerateID(),
+		FromAccountID:   from.ID,
+		ToAccountID:     to.ID,
+		Amount:          amount,
+		Timestamp:       time.Now(),
+		TransactionType: "transfer",
+		Description:     description,
+	}
+}
+
+// NormalizePhone приводит российский номер телефона к виду +7XXXXXXXXXX.
+func NormalizePhone(phone string) (string, error) {
+	var digits strings.Builder
+	for _, ch := range phone {
+		switch {
+		case unicode.IsDigit(ch):
+			digits.WriteRune(ch)
+		case ch == '+' || ch == ' ' || ch == '-' || ch == '(' || ch == ')':
+		default:
+			return "", fmt.Errorf("invalid phone number '%s'", phone)
+		}
+	}
+	d := digits.String()
+	switch {
+	case len(d) == 11 && (d[0] == '7' || d[0] == '8'):
+		return "+7" + d[1:], nil
+	case len(d) == 10 && d[0] == '9':
+		return "+7" + d, nil
+	default:
+		return "", fmt.Errorf("invalid phone number '%s'", phone)
+	}
+}
+
+// MaskName показывает имя получателя в виде "Иван И.", чтобы клиент мог убедиться, что перевод уходит нужному человеку.
+func MaskName(user User) string {
+	parts := strings.Fields(user.FullName)
+	if len(parts) == 0 {
+		name := []rune(user.Username)
+		if len(name) <= 2 {
+			return string(name[:1]) + "***"
+		}
+		return string(name[:1]) + "***" + string(name[len(name)-1:])
+	}
+	masked := parts[0]
+	if len(parts) > 1 {
+		masked += " " + string([]rune(parts[1])[:1]) + "."
+	}
+	return masked
+}
+
+func MaskAccountNumber(number string) string {
+	if len(number) < 4 {
+		return number
+	}
+	return "**" + number[len(number)-4:]
+}
+
+func detectRecipientTypes(to string) []string {
+	digitsOnly := to != ""
+	for _, ch := range to {
+		if !unicode.IsDigit(ch) {
+			digitsOnly = false
+			break
+		}
+	}
+
+	var types []string
+	if len(to) == 36 && strings.Count(to, "-") == 4 {
+		types = append(types, "account_id")
+	}
+	if digitsOnly && len(to) == 20 {
+		types = append(types, "account_number")
+	}
+	if digitsOnly && len(to) >= 16 && len(to) <= 19 {
+		types = append(types, "card_number")
+	}
+	if _, err := NormalizePhone(to); err == nil {
+		types = append(types, "phone")
+	}
+	return types
+}
+
+func resolveRecipientAs(to, toType string) (Account, error) {
+	switch toType {
+	case "account_id":
+		acc, ok := GetAccount(to)
+		if !ok {
+			return Account{}, &RecipientError{Code: "RECIPIENT_NOT_FOUND", Message: fmt.Sprintf("account %s not found", to)}
+		}
+		return acc, nil
+	case "account_number":
+		if err := ValidateAccountNumber(to); err != nil {
+			return Account{}, &RecipientError{Code: "INVALID_IDENTIFIER", Message: err.Error()}
+		}
+		acc, ok := GetAccountByNumber(to)
+		if !ok {
+			return Account{}, &RecipientError{Code: "RECIPIENT_NOT_FOUND", Message: "no account with this number"}
+		}
+		return acc, nil
+	case "card_number":
+		if err := ValidateCardNumber(to); err != nil {
+			return Account{}, &RecipientError{Code: "INVALID_IDENTIFIER", Message: err.Error()}
+		}
+		card, ok := GetCardByNumber(to)
+		if !ok {
+			return Account{}, &RecipientError{Code: "RECIPIENT_NOT_FOUND", Message: "no card with this number"}
+		}
+		if err := CheckCardUsable(card, time.Now()); err != nil {
+			return Account{}, &RecipientError{Code: "RECIPIENT_NOT_FOUND", Message: "card cannot accept transfers: " + err.Error()}
+		}
+		acc, ok := GetAccount(card.AccountID)
+		if !ok {
+			return Account{}, &RecipientError{Code: "RECIPIENT_NOT_FOUND", Message: "card account not found"}
+		}
+		return acc, nil
+	case "phone":
+		phone, err := NormalizePhone(to)
+		if err != nil {
+			return Account{}, &RecipientError{Code: "INVALID_IDENTIFIER", Message: err.Error()}
+		}
+		user, ok := GetUserByPhone(phone)
+		if !ok {
+			return Account{}, &RecipientError{Code: "RECIPIENT_NOT_FOUND", Message: "no customer with this phone number"}
+		}
+		return resolveUserIncomingAccount(user)
+	default:
+		return Account{}, &RecipientError{Code: "INVALID_IDENTIFIER", Message: fmt.Sprintf("unknown recipient type '%s'", toType)}
+	}
+}
+
+// resolveUserIncomingAccount выбирает счет для зачисления перевода по телефону:
+// основной счет клиента, а если он не задан — единственный активный рублевый счет.
+func resolveUserIncomingAccount(user User) (Account, error) {
+	if user.DefaultAccountID != "" {
+		if acc, ok := GetAccount(user.DefaultAccountID); ok && acc.Status == "active" {
+			return acc, nil
+		}
+	}
+	var candidates []Account
+	for _, acc := range GetUserAccounts(user.ID) {
+		if acc.Status == "active" && acc.Currency == "RUB" {
+			candidates = append(candidates, acc)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return Account{}, &RecipientError{Code: "RECIPIENT_NOT_FOUND", Message: "recipient has no account that can accept transfers"}
+	case 1:
+		return candidates[0], nil
+	default:
+		return Account{}, &RecipientError{Code: "RECIPIENT_AMBIGUOUS", Message: "recipient has several accounts and no default one, use an account or card number"}
+	}
+}
+
+// ResolveRecipient находит счет получателя по идентификатору. Если тип не указан,
+// перебираются все подходящие по формату типы; совпадение в нескольких — ошибка неоднозначности.
+func ResolveRecipient(to, toType string) (Account, string, error) {
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return Account{}, "", &RecipientError{Code: "INVALID_IDENTIFIER", Message: "recipient identifier is required"}
+	}
+	if toType != "" {
+		acc, err := resolveRecipientAs(to, toType)
+		return acc, toType, err
+	}
+
+	types := detectRecipientTypes(to)
+	if len(types) == 0 {
+		return Account{}, "", &RecipientError{Code: "INVALID_IDENTIFIER", Message: "recipient must be an account number, card number or phone number"}
+	}
+
+	var found []Account
+	var foundTypes []string
+	var lastErr error
+	for _, t := range types {
+		acc, err := resolveRecipientAs(to, t)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		found = append(found, acc)
+		foundTypes = append(foundTypes, t)
+	}
+	switch len(found) {
+	case 0:
+		return Account{}, "", lastErr
+	case 1:
+		return found[0], foundTypes[0], nil
+	default:
+		return Account{}, "", &RecipientError{
+			Code:    "RECIPIENT_AMBIGUOUS",
+			Message: fmt.Sprintf("identifier matches several recipients (%s), specify to_type", strings.Join(foundTypes, ", ")),
+		}
+	}
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"crypto/rand"
 	"fmt"
+	"log"
 	"math/big"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,17 +17,177 @@ func GenerateID() string {
 	return uuid.NewString()
 }
 
-func GenerateAccountNumber() string {
-	n, _ := rand.Int(rand.Reader, big.NewInt(9000000000))
-	return fmt.Sprintf("40817810%010d", n.Int64()+1000000000)
+var balanceAccountPrefixes = map[string]string{
+	"personal":     "40817", // счета физических лиц
+	"entrepreneur": "40802", // счета индивидуальных предпринимателей
+	"business":     "40702", // счета коммерческих организаций
 }
 
-func GenerateCardNumber() string {
-	n1, _ := rand.Int(rand.Reader, big.NewInt(9000))
-	n2, _ := rand.Int(rand.Reader, big.NewInt(10000))
-	n3, _ := rand.Int(rand.Reader, big.NewInt(10000))
-	n4, _ := rand.Int(rand.Reader, big.NewInt(10000))
-	return fmt.Sprintf("4%03d%04d%04d%04d", n1.Int64()+100, n2.Int64(), n3.Int64(), n4.Int64())
+// Коды валют в номере счета: для рубля по-прежнему используется 810.
+var accountCurrencyCodes = map[string]string{
+	"RUB": "810",
+	"USD": "840",
+	"EUR": "978",
+	"CNY": "156",
+}
+
+var accountKeyWeights = []int{7, 1, 3}
+
+// accountKeySum считает контрольную сумму по 3 последним цифрам БИК и 20 цифрам счета.
+func accountKeySum(bik, number string) int {
+	digits := bik[len(bik)-3:] + number
+	sum := 0
+	for i, ch := range digits {
+		sum += int(ch-'0') * accountKeyWeights[i%3] % 10
+	}
+	return sum
+}
+
+// CalculateAccountControlKey вычисляет контрольный ключ (9-я цифра счета) по алгоритму ЦБ РФ.
+func CalculateAccountControlKey(bik, number string) byte {
+	masked := number[:8] + "0" + number[9:]
+	return byte('0' + accountKeySum(bik, masked)%10*3%10)
+}
+
+func GenerateAccountNumber(accountType, currency string) (string, error) {
+	prefix, ok := balanceAccountPrefixes[accountType]
+	if !ok {
+		return "", fmt.Errorf("unknown account type '%s'", accountType)
+	}
+	currencyCode, ok := accountCurrencyCodes[currency]
+	if !ok {
+		return "", fmt.Errorf("unsupported currency '%s'", currency)
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(10000000))
+	if err != nil {
+		return "", err
+	}
+
+	number := fmt.Sprintf("%s%s0%s%07d", prefix, currencyCode, bankConfig.Branch, n.Int64())
+	key := CalculateAccountControlKey(bankConfig.BIK, number)
+	return number[:8] + string(key) + number[9:], nil
+}
+
+func ValidateAccountNumber(number string) error {
+	if len(number) != 20 {
+		return fmt.Errorf("account number must contain 20 digits")
+	}
+	for _, ch := range number {
+		if ch < '0' || ch > '9' {
+			return fmt.Errorf("account number must contain only digits")
+		}
+	}
+	if accountKeySum(bankConfig.BIK, number)%10 != 0 {
+		return fmt.Errorf("invalid account number control key")
+	}
+	return nil
+}
+
+type binRange struct {
+	From, To int // шестизначные BIN включительно
+}
+
+// cardProducts задает диапазоны BIN для каждой платежной системы. Диапазоны можно переопределить
+// переменными окружения BANKAPP_BINS_VISA, BANKAPP_BINS_MASTERCARD, BANKAPP_BINS_MIR в виде "400000-400099,400500-400599".
+var cardProducts = map[string][]binRange{
+	"visa":       mustParseBINRanges(envOrDefault("BANKAPP_BINS_VISA", "415400-415499")),
+	"mastercard": mustParseBINRanges(envOrDefault("BANKAPP_BINS_MASTERCARD", "520300-520399")),
+	"mir":        mustParseBINRanges(envOrDefault("BANKAPP_BINS_MIR", "220030-220039")),
+}
+
+func parseBINRanges(spec string) ([]binRange, error) {
+	var ranges []binRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		if len(bounds) == 1 {
+			bounds = append(bounds, bounds[0])
+		}
+		from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid BIN range '%s'", part)
+		}
+		to, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid BIN range '%s'", part)
+		}
+		if from < 100000 || to > 999999 || from > to {
+			return nil, fmt.Errorf("invalid BIN range '%s'", part)
+		}
+		ranges = append(ranges, binRange{From: from, To: to})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no BIN ranges configured")
+	}
+	return ranges, nil
+}
+
+func mustParseBINRanges(spec string) []binRange {
+	ranges, err := parseBINRanges(spec)
+	if err != nil {
+		log.Fatalf("Invalid card BIN configuration: %v", err)
+	}
+	return ranges
+}
+
+// mustParseBIK проверяет БИК банка: от его последних трех цифр зависит контрольный ключ номеров счетов.
+func mustParseBIK(bik string) string {
+	if len(bik) != 9 || !isDigits(bik) {
+		log.Fatalf("Invalid BANKAPP_BIK %q: BIK must contain 9 digits", bik)
+	}
+	return bik
+}
+
+// luhnSum считает контрольную сумму Луна; если withCheckDigit == false, number не содержит контрольной цифры.
+func luhnSum(number string, withCheckDigit bool) int {
+	sum := 0
+	double := !withCheckDigit
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum
+}
+
+// GenerateCardNumber выпускает номер карты из диапазона BIN продукта с контрольной цифрой по алгоритму Луна.
+func GenerateCardNumber(product string) (string, error) {
+	ranges, ok := cardProducts[product]
+	if !ok {
+		return "", fmt.Errorf("unsupported card product '%s'", product)
+	}
+	i, _ := rand.Int(rand.Reader, big.NewInt(int64(len(ranges))))
+	r := ranges[i.Int64()]
+	bin, _ := rand.Int(rand.Reader, big.NewInt(int64(r.To-r.From+1)))
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1_000_000_000))
+
+	body := fmt.Sprintf("%06d%09d", int64(r.From)+bin.Int64(), serial.Int64())
+	check := (10 - luhnSum(body, false)%10) % 10
+	return body + strconv.Itoa(check), nil
+}
+
+func ValidateCardNumber(number string) error {
+	if len(number) < 16 || len(number) > 19 {
+		return fmt.Errorf("card number must contain 16 to 19 digits")
+	}
+	for _, ch := range number {
+		if ch < '0' || ch > '9' {
+			return fmt.Errorf("card number must contain only digits")
+		}
+	}
+	if luhnSum(number, true)%10 != 0 {
+		return fmt.Errorf("invalid card number checksum")
+	}
+	return nil
 }
 
 func GenerateCVV() string {
@@ -32,11 +195,10 @@ func GenerateCVV() string {
 	return fmt.Sprintf("%03d", n.Int64()+100)
 }
 
-func GenerateExpiryDate() (int, int) {
+func GenerateExpiryDate(validMonths int) (int, int) {
 	now := time.Now()
-	year := now.Year() + 4
-	month := int(now.Month())
-	return month, year
+	expiry := time.Date(now.Year(), now.Month()+time.Month(validMonths), 1, 0, 0, 0, 0, time.UTC)
+	return int(expiry.Month()), expiry.Year()
 }
 
 func CalculateMonthlyPayment(loanAmount decimal.Decimal, annualRate decimal.Decimal, termMonths int) decimal.Decimal {